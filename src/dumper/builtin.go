@@ -0,0 +1,49 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// builtinFS holds the profiles of the known apps, in the same format as the
+// profile files, each one used when its fingerprint is found in a database
+//
+//go:embed profiles/*.json
+var builtinFS embed.FS
+
+// BuiltinProfiles returns the profiles of the known apps, in file name order
+func BuiltinProfiles() (Profiles, error) {
+	names, err := builtinFS.ReadDir("profiles")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i].Name() < names[j].Name() })
+	var ret Profiles
+	for _, n := range names {
+		f, err := builtinFS.Open(path.Join("profiles", n.Name()))
+		if err != nil {
+			return nil, err
+		}
+		p, err := LoadProfiles(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("built in profile %s, %s", n.Name(), err)
+		}
+		ret = append(ret, p...)
+	}
+	return ret, nil
+}
@@ -0,0 +1,64 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"strings"
+)
+
+// Columns is the index of the columns holding the position and time of a row,
+// with the preferred column first
+type Columns struct {
+	Lat, Long, Alt, Date []int
+}
+
+// HasPosition returns true if both a latitude and longitude column were found
+func (c Columns) HasPosition() bool {
+	return len(c.Lat) > 0 && len(c.Long) > 0
+}
+
+// IsDateColumn returns true if the column name looks like a date or time
+func IsDateColumn(name string) bool {
+	lcol := strings.ToLower(name)
+	return strings.HasSuffix(lcol, "date") || strings.HasSuffix(lcol, "timestamp")
+}
+
+// DetectColumns finds the position and time columns by their name suffix.
+// An entry date, or better a start date, is used in front of any other date.
+func DetectColumns(clm_names []string) Columns {
+	var c Columns
+	var idate, idate_top []int
+	for i, clm_name := range clm_names {
+		lcol := strings.ToLower(clm_name)
+		switch {
+		case strings.HasSuffix(lcol, "latitude"):
+			c.Lat = append(c.Lat, i)
+		case strings.HasSuffix(lcol, "longitude"):
+			c.Long = append(c.Long, i)
+		case strings.HasSuffix(lcol, "altitude"):
+			c.Alt = append(c.Alt, i)
+		case IsDateColumn(clm_name):
+			switch {
+			case strings.HasSuffix(lcol, "entrydate"):
+				idate_top = append(idate_top, i)
+			case strings.HasSuffix(lcol, "startdate"):
+				idate_top = append([]int{i}, idate_top...)
+			default:
+				idate = append(idate, i)
+			}
+		}
+	}
+	c.Date = append(idate_top, idate...)
+	return c
+}
+
@@ -0,0 +1,113 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// csvSink writes the rows out as CSV.  When the columns are given up front
+// every row is written as soon as it is read, otherwise the rows are spooled
+// to a temporary file until the full set of columns is known for the header.
+type csvSink struct {
+	NopSink
+	w       *bufio.Writer
+	delim   string
+	escape  bool // escape the non-ascii characters in the values
+	columns []string
+	spool   *recordSpool
+}
+
+// NewCSVSink returns a sink writing CSV to w, columns may be nil to use every
+// column which has a value
+func NewCSVSink(w io.Writer, delim string, escape_ascii bool, columns []string) (Sink, error) {
+	c := &csvSink{w: bufio.NewWriter(w), delim: delim, escape: escape_ascii, columns: columns}
+	if len(columns) > 0 {
+		c.writeHeader()
+		return c, nil
+	}
+	var err error
+	c.spool, err = newRecordSpool()
+	return c, err
+}
+
+func (c *csvSink) writeHeader() {
+	for i, clm_name := range c.columns {
+		if i > 0 {
+			c.w.WriteString(c.delim)
+		}
+		fmt.Fprintf(c.w, "%q", clm_name)
+	}
+	c.w.WriteByte('\n')
+}
+
+func (c *csvSink) writeRow(data map[string]interface{}) error {
+	for i, clm_name := range c.columns {
+		if i > 0 {
+			c.w.WriteString(c.delim)
+		}
+		if edat, ok := data[clm_name]; ok {
+			fmt.Fprintf(c.w, "%q", c.format(edat))
+		}
+	}
+	return c.w.WriteByte('\n')
+}
+
+// format turns a value into the text for a CSV field
+func (c *csvSink) format(data interface{}) (data_str string) {
+	switch val := data.(type) {
+	case int, int64:
+		data_str = fmt.Sprintf("%d", val)
+	case float64:
+		data_str = fmt.Sprintf("%f", val)
+	case string:
+		if c.escape {
+			data_str = strconv.QuoteToASCII(val)
+		} else {
+			data_str = strconv.Quote(val)
+		}
+	case []byte:
+		if c.escape {
+			data_str = strconv.QuoteToASCII(string(val))
+		} else {
+			data_str = strconv.Quote(string(val))
+		}
+	default:
+		strB, _ := json.Marshal(val)
+		data_str = string(strB)
+	}
+	return
+}
+
+func (c *csvSink) Record(e *Record) error {
+	if c.spool != nil {
+		return c.spool.add(e)
+	}
+	return c.writeRow(e.Data)
+}
+
+func (c *csvSink) Close() error {
+	if c.spool != nil {
+		defer c.spool.close()
+		c.columns = c.spool.columns.names
+		c.writeHeader()
+		if err := c.spool.replay(c.writeRow); err != nil {
+			return err
+		}
+	}
+	return c.w.Flush()
+}
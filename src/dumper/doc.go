@@ -0,0 +1,34 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dumper reads the location rows out of geo SQLite files and writes
+// them to map and table formats, it is the library behind geo-sqlite-dumper.
+//
+// A Source reads the files, finding the latitude, longitude, altitude and
+// date columns of every table by their names, and hands each row to a Sink
+// as a Record as soon as it is read.  The rows of a table are also split into
+// an Event wherever there is a gap of more than the event time.  The KML,
+// KMZ, GeoJSON, GPX, CSV, XLSX, GeoPackage, shapefile, SQLite, GeoParquet,
+// FlatGeobuf and NDJSON writers are all sinks, and Sinks passes the rows on to
+// several at once:
+//
+//	out := dumper.Sinks{csv_sink, my_sink}
+//	src := &dumper.Source{EventTime: 2 * time.Hour, BusyTimeout: 10 * time.Second}
+//	for _, f := range files {
+//		if err := src.ReadFile(f, out); err != nil {
+//			return err
+//		}
+//	}
+//	return out.Close()
+//
+// A sink only needing some of the calls can embed NopSink.
+package dumper
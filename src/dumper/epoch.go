@@ -0,0 +1,233 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Epochs and units a date column can be stored in
+const (
+	EpochAuto   = "auto"    // detect from the value
+	EpochUnix   = "unix"    // seconds since 1970-01-01
+	EpochUnixMs = "unix-ms" // milliseconds since 1970-01-01
+	EpochUnixUs = "unix-us" // microseconds since 1970-01-01
+	EpochUnixNs = "unix-ns" // nanoseconds since 1970-01-01
+	EpochCocoa  = "cocoa"   // seconds since 2001-01-01, Apple Core Data
+	EpochWebKit = "webkit"  // microseconds since 1601-01-01, Chrome and WebKit
+	EpochTicks  = "ticks"   // 100 nanosecond ticks since 0001-01-01, .NET
+	EpochGPS    = "gps"     // seconds since 1980-01-06, without leap seconds
+	EpochJulian = "julian"  // days since noon 4713-01-01 BC, SQLite julianday()
+	EpochISO    = "iso8601" // text such as 2022-07-20T08:54:21Z
+)
+
+// Epochs is every epoch which can be given in an epoch rule
+var Epochs = []string{EpochAuto, EpochUnix, EpochUnixMs, EpochUnixUs, EpochUnixNs, EpochCocoa,
+	EpochWebKit, EpochTicks, EpochGPS, EpochJulian, EpochISO}
+
+// Offsets of the epochs from the Unix epoch, in seconds
+const (
+	CocoaEpoch  = 978307200
+	webkitEpoch = -11644473600
+	ticksEpoch  = -62135596800
+	gpsEpoch    = 315964800
+	// GPS time is ahead of UTC by the leap seconds since 1980
+	gpsLeapSeconds = 18
+	julianUnixDay  = 2440587.5
+)
+
+// The range of times a detected value has to fall in, 1980 to 2100
+const (
+	detectMin = 315532800
+	detectMax = 4102444800
+)
+
+// DetectEpoch guesses the epoch of a number from its size, assuming it is a
+// time between 1980 and 2100.  Seconds since 1970 and since 2001 overlap, so
+// values before 2001 as Unix seconds are taken to be Cocoa, which holds until
+// Cocoa times reach 2032.  GPS seconds can not be told apart from these and
+// are never detected.  .NET ticks fall inside the range of Unix nanoseconds,
+// so nanoseconds from October 1989 to December 1990 are taken to be ticks.
+// Julian days are also Cocoa seconds on 29 January 2001 and are taken to be
+// Julian.  Values out of every range are taken to be Cocoa.
+func DetectEpoch(v float64) string {
+	between := func(offset, scale float64) bool {
+		return v >= (offset+detectMin)*scale && v < (offset+detectMax)*scale
+	}
+	switch {
+	case v >= julianUnixDay+detectMin/86400 && v < julianUnixDay+detectMax/86400:
+		return EpochJulian
+	case v >= CocoaEpoch && v < detectMax:
+		return EpochUnix
+	case between(0, 1e3):
+		return EpochUnixMs
+	case between(0, 1e6):
+		return EpochUnixUs
+	case between(-webkitEpoch, 1e6):
+		return EpochWebKit
+	case between(-ticksEpoch, 1e7):
+		return EpochTicks
+	case between(0, 1e9):
+		return EpochUnixNs
+	}
+	return EpochCocoa
+}
+
+// isoLayouts are the text layouts tried for ISO-8601 times, a time without a
+// zone is taken to be UTC
+var isoLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// parseISO parses an ISO-8601 date and time
+func parseISO(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range isoLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// unixScaled converts an integer count of 1/scale seconds since the Unix
+// epoch plus offset seconds, without losing precision to a float
+func unixScaled(v int64, scale int64, offset int64) time.Time {
+	sec, frac := v/scale, v%scale
+	if frac < 0 {
+		sec, frac = sec-1, frac+scale
+	}
+	return time.Unix(sec+offset, frac*(1e9/scale)).UTC()
+}
+
+// unixFloat converts seconds since the Unix epoch into a time
+func unixFloat(v float64) time.Time {
+	sec, frac := math.Modf(v)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
+
+// CocoaTime converts seconds since the Cocoa reference date into a time
+func CocoaTime(v float64) time.Time {
+	return unixFloat(v + CocoaEpoch)
+}
+
+// ParseTime converts a column value into a time using the epoch, with
+// EpochAuto the epoch is detected from the value.  It returns the epoch used
+// and false if the value can not be read as a time.
+func ParseTime(v interface{}, epoch string) (time.Time, string, bool) {
+	switch val := v.(type) {
+	case string:
+		if epoch != EpochAuto && epoch != EpochISO {
+			return time.Time{}, epoch, false
+		}
+		t, ok := parseISO(val)
+		return t, EpochISO, ok
+	case []byte:
+		return ParseTime(string(val), epoch)
+	case int64:
+		if epoch == EpochAuto {
+			epoch = DetectEpoch(float64(val))
+		}
+		switch epoch {
+		case EpochUnixMs:
+			return unixScaled(val, 1e3, 0), epoch, true
+		case EpochUnixUs:
+			return unixScaled(val, 1e6, 0), epoch, true
+		case EpochUnixNs:
+			return unixScaled(val, 1e9, 0), epoch, true
+		case EpochWebKit:
+			return unixScaled(val, 1e6, webkitEpoch), epoch, true
+		case EpochTicks:
+			return unixScaled(val, 1e7, ticksEpoch), epoch, true
+		}
+		return ParseTime(float64(val), epoch)
+	case float64:
+		if epoch == EpochAuto {
+			epoch = DetectEpoch(val)
+		}
+		switch epoch {
+		case EpochUnix:
+			return unixFloat(val), epoch, true
+		case EpochUnixMs:
+			return unixFloat(val / 1e3), epoch, true
+		case EpochUnixUs:
+			return unixFloat(val / 1e6), epoch, true
+		case EpochUnixNs:
+			return unixFloat(val / 1e9), epoch, true
+		case EpochCocoa:
+			return CocoaTime(val), epoch, true
+		case EpochWebKit:
+			return unixFloat(val/1e6 + webkitEpoch), epoch, true
+		case EpochTicks:
+			return unixFloat(val/1e7 + ticksEpoch), epoch, true
+		case EpochGPS:
+			return unixFloat(val + gpsEpoch - gpsLeapSeconds), epoch, true
+		case EpochJulian:
+			// Round to the millisecond, a day count has little precision left
+			return unixFloat(math.Round((val-julianUnixDay)*86400e3) / 1e3), epoch, true
+		}
+	}
+	return time.Time{}, epoch, false
+}
+
+// EpochRules forces the epoch of date columns, keyed by the lower case
+// TABLE.COLUMN or COLUMN, with the empty key for every date column
+type EpochRules map[string]string
+
+// ParseEpochRules parses rules in the form [TABLE.]COLUMN=EPOCH, or just
+// EPOCH for every date column.  A column named in a rule is read as a date
+// even when its name does not look like one.
+func ParseEpochRules(rules []string) (EpochRules, error) {
+	ret := make(EpochRules)
+	for _, rule := range rules {
+		column, epoch := "", rule
+		if i := strings.LastIndex(rule, "="); i >= 0 {
+			column, epoch = strings.TrimSpace(rule[:i]), rule[i+1:]
+		}
+		epoch = strings.ToLower(strings.TrimSpace(epoch))
+		known := false
+		for _, e := range Epochs {
+			known = known || e == epoch
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown epoch %q in %q, expected one of %s", epoch, rule, strings.Join(Epochs, ", "))
+		}
+		ret[strings.ToLower(column)] = epoch
+	}
+	return ret, nil
+}
+
+// lookup returns the epoch for a column of a table, the most specific rule
+// wins.  named is true when the column itself is named in a rule.
+func (r EpochRules) lookup(table, column string) (epoch string, named bool) {
+	if e, ok := r[strings.ToLower(table+"."+column)]; ok {
+		return e, true
+	}
+	if e, ok := r[strings.ToLower(column)]; ok {
+		return e, true
+	}
+	if e, ok := r[""]; ok {
+		return e, false
+	}
+	return EpochAuto, false
+}
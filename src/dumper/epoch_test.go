@@ -0,0 +1,108 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"testing"
+	"time"
+)
+
+// 2022-07-20T08:54:21Z and the first and last second of the detected range
+var (
+	epochWhen  = time.Date(2022, 7, 20, 8, 54, 21, 0, time.UTC)
+	epochFirst = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	epochLast  = time.Date(2099, 12, 31, 23, 59, 59, 0, time.UTC)
+)
+
+// The same time in every epoch, the edges of each range and the values
+// which lie in more than one range
+var epochTests = []struct {
+	name  string
+	v     interface{}
+	epoch string // epoch given to ParseTime, EpochAuto to detect it
+	want  string // epoch used
+	t     time.Time
+}{
+	{"unix", int64(1658307261), EpochAuto, EpochUnix, epochWhen},
+	{"unix float", 1658307261.5, EpochAuto, EpochUnix, epochWhen.Add(500 * time.Millisecond)},
+	{"unix-ms", int64(1658307261123), EpochAuto, EpochUnixMs, epochWhen.Add(123 * time.Millisecond)},
+	{"unix-us", int64(1658307261123456), EpochAuto, EpochUnixUs, epochWhen.Add(123456 * time.Microsecond)},
+	{"unix-ns", int64(1658307261123456789), EpochAuto, EpochUnixNs, epochWhen.Add(123456789)},
+	{"cocoa", 680000061.0, EpochAuto, EpochCocoa, epochWhen},
+	{"webkit", int64(13302780861000000), EpochAuto, EpochWebKit, epochWhen},
+	{"ticks", int64(637939040610000000), EpochAuto, EpochTicks, epochWhen},
+	{"julian", 2459780.5, EpochAuto, EpochJulian, time.Date(2022, 7, 20, 0, 0, 0, 0, time.UTC)},
+	{"gps", int64(1342342479), EpochGPS, EpochGPS, epochWhen},
+	{"iso8601", "2022-07-20T08:54:21Z", EpochAuto, EpochISO, epochWhen},
+	{"iso8601 bytes", []byte("2022-07-20 08:54:21"), EpochAuto, EpochISO, epochWhen},
+
+	// The edges of each range
+	{"julian first", 2444239.5, EpochAuto, EpochJulian, epochFirst},
+	{"julian last", 2488069.0, EpochAuto, EpochJulian, time.Date(2099, 12, 31, 12, 0, 0, 0, time.UTC)},
+	{"after julian", 2488069.5, EpochAuto, EpochCocoa, time.Date(2001, 1, 29, 19, 7, 49, 500000000, time.UTC)},
+	{"unix first", int64(978307200), EpochAuto, EpochUnix, time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{"unix last", int64(4102444799), EpochAuto, EpochUnix, epochLast},
+	{"after unix", int64(4102444800), EpochAuto, EpochCocoa, time.Date(2131, 1, 2, 0, 0, 0, 0, time.UTC)},
+	{"unix-ms first", int64(315532800000), EpochAuto, EpochUnixMs, epochFirst},
+	{"unix-ms last", int64(4102444799999), EpochAuto, EpochUnixMs, epochLast.Add(999 * time.Millisecond)},
+	{"unix-us first", int64(315532800000000), EpochAuto, EpochUnixUs, epochFirst},
+	{"unix-us last", int64(4102444799999999), EpochAuto, EpochUnixUs, epochLast.Add(999999 * time.Microsecond)},
+	{"webkit first", int64(11960006400000000), EpochAuto, EpochWebKit, epochFirst},
+	{"webkit last", int64(15746918399999998), EpochAuto, EpochWebKit, epochLast.Add(999998 * time.Microsecond)},
+	{"unix-ns first", int64(315532800000000000), EpochAuto, EpochUnixNs, epochFirst},
+	{"ticks first", int64(624511296000000000), EpochAuto, EpochTicks, epochFirst},
+	{"unix-ns last", int64(4102444799999999488), EpochAuto, EpochUnixNs, epochLast.Add(999999488)},
+
+	// Cocoa times from 2032 on are also Unix times after 2001
+	{"cocoa as unix", int64(978307200), EpochAuto, EpochUnix, time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{"cocoa forced", int64(978307200), EpochCocoa, EpochCocoa, time.Date(2032, 1, 2, 0, 0, 0, 0, time.UTC)},
+	{"cocoa last", 978307199.0, EpochAuto, EpochCocoa, time.Date(2032, 1, 1, 23, 59, 59, 0, time.UTC)},
+	// GPS seconds are read as Unix seconds
+	{"gps as unix", int64(1342342479), EpochAuto, EpochUnix, time.Date(2012, 7, 15, 8, 54, 39, 0, time.UTC)},
+	// Julian days are also Cocoa seconds on 29 January 2001
+	{"julian as cocoa", 2459780.5, EpochCocoa, EpochCocoa, time.Date(2001, 1, 29, 11, 16, 20, 500000000, time.UTC)},
+	// Unix nanoseconds from October 1989 to December 1990 are .NET ticks
+	{"unix-ns as ticks", int64(631152000000000000), EpochAuto, EpochTicks, time.Date(2001, 1, 16, 0, 0, 0, 0, time.UTC)},
+	{"unix-ns forced", int64(631152000000000000), EpochUnixNs, EpochUnixNs, time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{"unix-ns before ticks", int64(624511295999999872), EpochAuto, EpochUnixNs, time.Date(1989, 10, 16, 3, 21, 35, 999999872, time.UTC)},
+	{"ticks last", int64(662380415999990000), EpochAuto, EpochTicks, epochLast.Add(999 * time.Millisecond)},
+	{"unix-ns after ticks", int64(662380416000000000), EpochAuto, EpochUnixNs, time.Date(1990, 12, 28, 10, 33, 36, 0, time.UTC)},
+}
+
+func TestParseTime(t *testing.T) {
+	for _, tc := range epochTests {
+		got, epoch, ok := ParseTime(tc.v, tc.epoch)
+		if !ok {
+			t.Errorf("%s: %v is not read as a time", tc.name, tc.v)
+			continue
+		}
+		if epoch != tc.want {
+			t.Errorf("%s: %v is read as %s, want %s", tc.name, tc.v, epoch, tc.want)
+		}
+		if !got.Equal(tc.t) {
+			t.Errorf("%s: %v is %s, want %s", tc.name, tc.v, got.Format(time.RFC3339Nano), tc.t.Format(time.RFC3339Nano))
+		}
+	}
+}
+
+func TestParseTimeWrongEpoch(t *testing.T) {
+	if _, _, ok := ParseTime("2022-07-20T08:54:21Z", EpochUnix); ok {
+		t.Error("text is read as Unix seconds")
+	}
+	if _, _, ok := ParseTime("yesterday", EpochAuto); ok {
+		t.Error("text which is not a date is read as a time")
+	}
+	if _, _, ok := ParseTime(nil, EpochAuto); ok {
+		t.Error("NULL is read as a time")
+	}
+}
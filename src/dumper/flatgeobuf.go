@@ -0,0 +1,702 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkb"
+)
+
+// FlatGeobuf reference: https://github.com/flatgeobuf/flatgeobuf, the header
+// and features are FlatBuffers of the tables in header.fbs and feature.fbs
+
+var fgbMagic = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+// fgbNodeSize is the number of children of each node of the index
+const fgbNodeSize = 16
+
+// Geometry and column types of FlatGeobuf
+const (
+	fgbUnknown            = 0
+	fgbPoint              = 1
+	fgbLineString         = 2
+	fgbPolygon            = 3
+	fgbMultiPoint         = 4
+	fgbMultiLineString    = 5
+	fgbMultiPolygon       = 6
+	fgbGeometryCollection = 7
+
+	fgbBool     = 2
+	fgbLong     = 7
+	fgbDouble   = 10
+	fgbString   = 11
+	fgbDateTime = 13
+	fgbBinary   = 14
+)
+
+// fbBuilder lays out a FlatBuffer front to back, each table followed by the
+// strings, vectors and tables it refers to, so every offset points forward
+type fbBuilder struct {
+	buf []byte
+}
+
+// fbField is a field of a table, a scalar of size bytes or, when ref is set,
+// an offset to the object ref writes, which returns where the object starts
+type fbField struct {
+	id    int
+	size  int
+	value uint64
+	ref   func() int
+}
+
+func fbScalar(id, size int, value uint64) fbField {
+	return fbField{id: id, size: size, value: value}
+}
+
+func fbRef(id int, ref func() int) fbField {
+	return fbField{id: id, size: 4, ref: ref}
+}
+
+func (b *fbBuilder) align(n int) {
+	for len(b.buf)%n != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) put(at, size int, v uint64) {
+	switch size {
+	case 1:
+		b.buf[at] = byte(v)
+	case 2:
+		binary.LittleEndian.PutUint16(b.buf[at:], uint16(v))
+	case 4:
+		binary.LittleEndian.PutUint32(b.buf[at:], uint32(v))
+	case 8:
+		binary.LittleEndian.PutUint64(b.buf[at:], v)
+	}
+}
+
+// table writes a table with its vtable in front of it, returning where the
+// table starts
+func (b *fbBuilder) table(fields ...fbField) int {
+	// The largest fields go first so each one is aligned
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].size > fields[j].size })
+	pos := make([]int, len(fields))
+	size, max_id := 4, -1
+	for i, f := range fields {
+		for size%f.size != 0 {
+			size++
+		}
+		pos[i] = size
+		size += f.size
+		if f.id > max_id {
+			max_id = f.id
+		}
+	}
+
+	b.align(2)
+	vtable := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+2*(max_id+1))...)
+	b.put(vtable, 2, uint64(4+2*(max_id+1)))
+	b.put(vtable+2, 2, uint64(size))
+	for i, f := range fields {
+		b.put(vtable+4+2*f.id, 2, uint64(pos[i]))
+	}
+	b.align(8)
+	table := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	b.put(table, 4, uint64(table-vtable))
+	for i, f := range fields {
+		if f.ref == nil {
+			b.put(table+pos[i], f.size, f.value)
+		}
+	}
+	for i, f := range fields {
+		if f.ref != nil {
+			at := table + pos[i]
+			b.put(at, 4, uint64(f.ref()-at))
+		}
+	}
+	return table
+}
+
+// vector writes a vector of n elements of size bytes, returning where it
+// starts and where its elements are
+func (b *fbBuilder) vector(n, size int) (int, int) {
+	b.align(4)
+	for (len(b.buf)+4)%size != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	at := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+n*size)...)
+	b.put(at, 4, uint64(n))
+	return at, at + 4
+}
+
+func (b *fbBuilder) str(s string) int {
+	at, elems := b.vector(len(s), 1)
+	copy(b.buf[elems:], s)
+	b.buf = append(b.buf, 0)
+	return at
+}
+
+func (b *fbBuilder) bytes(v []byte) int {
+	at, elems := b.vector(len(v), 1)
+	copy(b.buf[elems:], v)
+	return at
+}
+
+func (b *fbBuilder) doubles(v []float64) int {
+	at, elems := b.vector(len(v), 8)
+	for i, f := range v {
+		b.put(elems+8*i, 8, math.Float64bits(f))
+	}
+	return at
+}
+
+func (b *fbBuilder) uints(v []uint32) int {
+	at, elems := b.vector(len(v), 4)
+	for i, u := range v {
+		b.put(elems+4*i, 4, uint64(u))
+	}
+	return at
+}
+
+// tables writes a vector of n tables, each written by write
+func (b *fbBuilder) tables(n int, write func(i int) int) int {
+	at, elems := b.vector(n, 4)
+	for i := 0; i < n; i++ {
+		slot := elems + 4*i
+		b.put(slot, 4, uint64(write(i)-slot))
+	}
+	return at
+}
+
+// finish returns the buffer with the table written by root as its root
+func (b *fbBuilder) finish(root func() int) []byte {
+	b.buf = make([]byte, 4)
+	b.put(0, 4, uint64(root()))
+	return b.buf
+}
+
+// fgbType is the FlatGeobuf type of a geometry
+func fgbType(g geom.T) byte {
+	switch g.(type) {
+	case *geom.Point:
+		return fgbPoint
+	case *geom.LineString:
+		return fgbLineString
+	case *geom.Polygon:
+		return fgbPolygon
+	case *geom.MultiPoint:
+		return fgbMultiPoint
+	case *geom.MultiLineString:
+		return fgbMultiLineString
+	case *geom.MultiPolygon:
+		return fgbMultiPolygon
+	}
+	return fgbGeometryCollection
+}
+
+// geometry writes a Geometry table, the x and y of every point, the z when
+// the file has them and the ends of the rings or lines, with the polygons of
+// a multipolygon and the members of a collection as parts
+func (b *fbBuilder) geometry(g geom.T, has_z bool) int {
+	fields := []fbField{fbScalar(6, 1, uint64(fgbType(g)))}
+	var parts []geom.T
+	switch p := g.(type) {
+	case *geom.MultiPolygon:
+		for i := 0; i < p.NumPolygons(); i++ {
+			parts = append(parts, p.Polygon(i))
+		}
+	case *geom.GeometryCollection:
+		parts = p.Geoms()
+	}
+	if parts != nil {
+		fields = append(fields, fbRef(7, func() int {
+			return b.tables(len(parts), func(i int) int { return b.geometry(parts[i], has_z) })
+		}))
+		return b.table(fields...)
+	}
+
+	flat, stride, z := g.FlatCoords(), g.Stride(), g.Layout().ZIndex()
+	var xy, zs []float64
+	for i := 0; i+1 < len(flat); i += stride {
+		xy = append(xy, flat[i], flat[i+1])
+		if z >= 0 {
+			zs = append(zs, flat[i+z])
+		} else {
+			zs = append(zs, 0)
+		}
+	}
+	fields = append(fields, fbRef(1, func() int { return b.doubles(xy) }))
+	if has_z {
+		fields = append(fields, fbRef(2, func() int { return b.doubles(zs) }))
+	}
+	switch g.(type) {
+	case *geom.Polygon, *geom.MultiLineString:
+		if ends := g.Ends(); len(ends) > 1 {
+			points := make([]uint32, len(ends))
+			for i, e := range ends {
+				points[i] = uint32(e / stride)
+			}
+			fields = append(fields, fbRef(0, func() int { return b.uints(points) }))
+		}
+	}
+	return b.table(fields...)
+}
+
+// fgbColumn is a column of the file, typed by the values seen in it
+type fgbColumn struct {
+	kindSet
+	name string // in the file
+	kind byte
+}
+
+// property adds the value of column i to the properties of a feature
+func (c *fgbColumn) property(props []byte, i int, v interface{}) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint16(b[:], uint16(i))
+	props = append(props, b[:2]...)
+	switch c.kind {
+	case fgbBool:
+		if v.(bool) {
+			return append(props, 1)
+		}
+		return append(props, 0)
+	case fgbLong:
+		switch val := v.(type) {
+		case int:
+			binary.LittleEndian.PutUint64(b[:], uint64(val))
+		case int64:
+			binary.LittleEndian.PutUint64(b[:], uint64(val))
+		}
+		return append(props, b[:8]...)
+	case fgbDouble:
+		var f float64
+		switch val := v.(type) {
+		case int:
+			f = float64(val)
+		case int64:
+			f = float64(val)
+		case float64:
+			f = val
+		}
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+		return append(props, b[:8]...)
+	}
+	var s []byte
+	if blob, ok := v.([]byte); ok && c.kind == fgbBinary {
+		s = blob
+	} else {
+		s = []byte(valueText(v))
+	}
+	binary.LittleEndian.PutUint32(b[:], uint32(len(s)))
+	props = append(props, b[:4]...)
+	return append(props, s...)
+}
+
+// fgbItem is a feature as it is placed in the index
+type fgbItem struct {
+	min_x, min_y, max_x, max_y float64
+	offset                     int64 // where the feature is, first in the spool then in the file
+	size                       int64
+	hilbert                    uint32
+}
+
+// hilbert returns the distance along a Hilbert curve of a point in a 65536 by
+// 65536 grid, as computed by the FlatGeobuf libraries from
+// https://github.com/rawrunprotected/hilbert_curves
+func hilbert(x, y uint32) uint32 {
+	a := x ^ y
+	b := 0xFFFF ^ a
+	c := 0xFFFF ^ (x | y)
+	d := x & (y ^ 0xFFFF)
+
+	A := a | (b >> 1)
+	B := (a >> 1) ^ a
+	C := ((c >> 1) ^ (b & (d >> 1))) ^ c
+	D := ((a & (c >> 1)) ^ (d >> 1)) ^ d
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 2)) ^ (b & (b >> 2))
+	B = (a & (b >> 2)) ^ (b & ((a ^ b) >> 2))
+	C ^= (a & (c >> 2)) ^ (b & (d >> 2))
+	D ^= (b & (c >> 2)) ^ ((a ^ b) & (d >> 2))
+
+	a, b, c, d = A, B, C, D
+	A = (a & (a >> 4)) ^ (b & (b >> 4))
+	B = (a & (b >> 4)) ^ (b & ((a ^ b) >> 4))
+	C ^= (a & (c >> 4)) ^ (b & (d >> 4))
+	D ^= (b & (c >> 4)) ^ ((a ^ b) & (d >> 4))
+
+	a, b, c, d = A, B, C, D
+	C ^= (a & (c >> 8)) ^ (b & (d >> 8))
+	D ^= (b & (c >> 8)) ^ ((a ^ b) & (d >> 8))
+
+	a = C ^ (C >> 1)
+	b = D ^ (D >> 1)
+
+	i0 := x ^ y
+	i1 := b | (0xFFFF ^ (i0 | a))
+
+	i0 = (i0 | (i0 << 8)) & 0x00FF00FF
+	i0 = (i0 | (i0 << 4)) & 0x0F0F0F0F
+	i0 = (i0 | (i0 << 2)) & 0x33333333
+	i0 = (i0 | (i0 << 1)) & 0x55555555
+
+	i1 = (i1 | (i1 << 8)) & 0x00FF00FF
+	i1 = (i1 | (i1 << 4)) & 0x0F0F0F0F
+	i1 = (i1 | (i1 << 2)) & 0x33333333
+	i1 = (i1 | (i1 << 1)) & 0x55555555
+
+	return (i1 << 1) | i0
+}
+
+// fgbLevels returns where each level of the packed R-tree of n items starts
+// and ends in its nodes, leaves first and the root last, with the root as the
+// first node.  There is always a root above the leaves, even for one item, as
+// generateLevelBounds and calcTreeSize of the FlatGeobuf libraries have it.
+func fgbLevels(n int) [][2]int {
+	counts := []int{n}
+	nodes := n
+	for {
+		n = (n + fgbNodeSize - 1) / fgbNodeSize
+		nodes += n
+		counts = append(counts, n)
+		if n == 1 {
+			break
+		}
+	}
+	levels := make([][2]int, len(counts))
+	for i, c := range counts {
+		levels[i] = [2]int{nodes - c, nodes}
+		nodes -= c
+	}
+	return levels
+}
+
+// fgbSink writes the records to a FlatGeobuf file, with the shape of every
+// row and, when lines is set, a LineString of every event as the features.
+// The features are spooled until the end, when they are sorted along a
+// Hilbert curve and written after the packed R-tree index of their bounds,
+// so a viewer can read only the features of the area it shows.  Rows without
+// a position are left out.
+type fgbSink struct {
+	NopSink
+	w       io.Writer
+	name    string
+	lines   bool
+	rows    *spool
+	buf     *bufio.Writer
+	enc     *gob.Encoder
+	count   int
+	names   columnSet
+	columns map[string]*fgbColumn // by the source column
+	types   map[byte]bool
+	has_z   bool
+}
+
+// NewFlatGeobufSink returns a sink writing FlatGeobuf to w, name is the name
+// of the layer and lines adds the event paths
+func NewFlatGeobufSink(w io.Writer, name string, lines bool) (Sink, error) {
+	f, err := newSpool()
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(f)
+	return &fgbSink{w: w, name: name, lines: lines, rows: f, buf: buf, enc: gob.NewEncoder(buf),
+		columns: make(map[string]*fgbColumn), types: make(map[byte]bool)}, nil
+}
+
+// add spools a feature of the shape
+func (f *fgbSink) add(shape geom.T, t time.Time, data map[string]interface{}, columns []string) error {
+	b, err := wkb.Marshal(shape, wkb.NDR)
+	if err != nil {
+		return err
+	}
+	row := geoRow{Data: data, Geometry: b}
+	if !t.IsZero() {
+		row.Time = t.Unix()*1000000 + int64(t.Nanosecond()/1000)
+		row.HasTime = true
+	}
+	f.types[fgbType(shape)] = true
+	if shape.Layout().ZIndex() >= 0 {
+		f.has_z = true
+	}
+	f.names.add(columns...)
+	for _, c := range columns {
+		col, ok := f.columns[c]
+		if !ok {
+			col = &fgbColumn{}
+			f.columns[c] = col
+		}
+		col.see(data[c])
+	}
+	f.count++
+	return f.enc.Encode(row)
+}
+
+func (f *fgbSink) Record(e *Record) error {
+	shape := recordGeometry(e)
+	if shape == nil || shape.Empty() {
+		return nil
+	}
+	return f.add(shape, e.Time, e.Data, e.Columns)
+}
+
+func (f *fgbSink) Event(ev *Event) error {
+	if !f.lines || ev.Waypoints {
+		return nil
+	}
+	var flat []float64
+	for _, e := range ev.Records {
+		if e.Coords != nil {
+			flat = append(flat, e.Coords.Lon, e.Coords.Lat)
+		}
+	}
+	if len(flat) < 4 {
+		return nil
+	}
+	s_time := ev.Records[0].Time
+	e_time := ev.Records[len(ev.Records)-1].Time
+	data := map[string]interface{}{
+		"SOURCE_FILE_PATH": ev.File,
+		"SOURCE_TABLE":     ev.Table,
+		"POINTS":           len(ev.Records),
+		"START_TIME":       s_time.UTC().Format(utcLayout),
+		"END_TIME":         e_time.UTC().Format(utcLayout),
+		"DISTANCE_M":       math.Round(ev.Dist*100) / 100,
+	}
+	columns := []string{"SOURCE_FILE_PATH", "SOURCE_TABLE", "POINTS", "START_TIME", "END_TIME", "DISTANCE_M"}
+	return f.add(geom.NewLineStringFlat(geom.XY, flat), s_time, data, columns)
+}
+
+// schema returns the columns of the file, the time followed by those of the
+// tables, named so they differ even where case is ignored
+func (f *fgbSink) schema() []*fgbColumn {
+	columns := []*fgbColumn{{name: "time", kind: fgbDateTime}}
+	used := map[string]bool{"time": true}
+	for _, c := range f.names.names {
+		col := f.columns[c]
+		col.name = c
+		for i := 1; used[strings.ToLower(col.name)]; i++ {
+			col.name = fmt.Sprintf("%s_%d", c, i)
+		}
+		used[strings.ToLower(col.name)] = true
+		switch col.kindSet.kind() {
+		case kindInt:
+			col.kind = fgbLong
+		case kindFloat:
+			col.kind = fgbDouble
+		case kindBool:
+			col.kind = fgbBool
+		case kindBlob:
+			col.kind = fgbBinary
+		default:
+			col.kind = fgbString
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// features turns the spooled rows into FlatGeobuf features in a second spool,
+// returning where each one is with its bounds
+func (f *fgbSink) features(columns []*fgbColumn, out *spool) ([]fgbItem, error) {
+	if err := f.buf.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := f.rows.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(f.rows))
+	w := bufio.NewWriter(out)
+	items := make([]fgbItem, 0, f.count)
+	var offset int64
+	for i := 0; i < f.count; i++ {
+		var row geoRow
+		if err := dec.Decode(&row); err != nil {
+			return nil, err
+		}
+		shape, err := wkb.Unmarshal(row.Geometry)
+		if err != nil {
+			return nil, err
+		}
+		var props []byte
+		if row.HasTime {
+			t := time.Unix(row.Time/1000000, row.Time%1000000*1000).UTC()
+			props = columns[0].property(props, 0, t.Format(utcLayout))
+		}
+		for j, c := range f.names.names {
+			if v := row.Data[c]; v != nil {
+				props = columns[j+1].property(props, j+1, v)
+			}
+		}
+
+		var b fbBuilder
+		feature := b.finish(func() int {
+			fields := []fbField{fbRef(0, func() int { return b.geometry(shape, f.has_z) })}
+			if len(props) > 0 {
+				fields = append(fields, fbRef(1, func() int { return b.bytes(props) }))
+			}
+			return b.table(fields...)
+		})
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(feature)))
+		w.Write(size[:])
+		w.Write(feature)
+
+		bounds := shape.Bounds()
+		items = append(items, fgbItem{min_x: bounds.Min(0), min_y: bounds.Min(1), max_x: bounds.Max(0),
+			max_y: bounds.Max(1), offset: offset, size: int64(4 + len(feature))})
+		offset += int64(4 + len(feature))
+	}
+	return items, w.Flush()
+}
+
+// header returns the Header table of the file
+func (f *fgbSink) header(columns []*fgbColumn, extent []float64) []byte {
+	var kind byte = fgbUnknown
+	if len(f.types) == 1 {
+		for t := range f.types {
+			kind = t
+		}
+	}
+	node_size := fgbNodeSize
+	if f.count == 0 {
+		// There is no index of an empty file
+		node_size = 0
+	}
+	var b fbBuilder
+	return b.finish(func() int {
+		fields := []fbField{
+			fbScalar(2, 1, uint64(kind)),
+			fbScalar(8, 8, uint64(f.count)),
+			fbScalar(9, 2, uint64(node_size)),
+			fbRef(7, func() int {
+				return b.tables(len(columns), func(i int) int {
+					c := columns[i]
+					return b.table(fbRef(0, func() int { return b.str(c.name) }), fbScalar(1, 1, uint64(c.kind)))
+				})
+			}),
+			fbRef(10, func() int {
+				return b.table(fbRef(0, func() int { return b.str("EPSG") }), fbScalar(1, 4, 4326))
+			}),
+		}
+		if f.name != "" {
+			fields = append(fields, fbRef(0, func() int { return b.str(f.name) }))
+		}
+		if extent != nil {
+			fields = append(fields, fbRef(1, func() int { return b.doubles(extent) }))
+		}
+		if f.has_z {
+			fields = append(fields, fbScalar(3, 1, 1))
+		}
+		return b.table(fields...)
+	})
+}
+
+// Close writes the header, the index and the features sorted along the
+// Hilbert curve
+func (f *fgbSink) Close() error {
+	defer f.rows.close()
+	out, err := newSpool()
+	if err != nil {
+		return err
+	}
+	defer out.close()
+
+	columns := f.schema()
+	items, err := f.features(columns, out)
+	if err != nil {
+		return err
+	}
+
+	var extent []float64
+	if len(items) > 0 {
+		extent = []float64{items[0].min_x, items[0].min_y, items[0].max_x, items[0].max_y}
+		for _, it := range items {
+			extent[0], extent[1] = math.Min(extent[0], it.min_x), math.Min(extent[1], it.min_y)
+			extent[2], extent[3] = math.Max(extent[2], it.max_x), math.Max(extent[3], it.max_y)
+		}
+		width, height := extent[2]-extent[0], extent[3]-extent[1]
+		for i := range items {
+			it := &items[i]
+			var x, y uint32
+			if width != 0 {
+				x = uint32(math.Floor(0xFFFF * ((it.min_x+it.max_x)/2 - extent[0]) / width))
+			}
+			if height != 0 {
+				y = uint32(math.Floor(0xFFFF * ((it.min_y+it.max_y)/2 - extent[1]) / height))
+			}
+			it.hilbert = hilbert(x, y)
+		}
+		sort.SliceStable(items, func(i, j int) bool { return items[i].hilbert > items[j].hilbert })
+	}
+
+	w := bufio.NewWriter(f.w)
+	w.Write(fgbMagic)
+	header := f.header(columns, extent)
+	binary.Write(w, binary.LittleEndian, uint32(len(header)))
+	w.Write(header)
+
+	if len(items) > 0 {
+		// The leaves are the features in the order they are written, each
+		// node above covers the bounds of the next level's nodes
+		levels := fgbLevels(len(items))
+		nodes := make([]fgbItem, levels[0][1])
+		var offset int64
+		for i, it := range items {
+			leaf := it
+			leaf.offset = offset
+			nodes[levels[0][0]+i] = leaf
+			offset += it.size
+		}
+		for l := 0; l < len(levels)-1; l++ {
+			pos, end, parent := levels[l][0], levels[l][1], levels[l+1][0]
+			for pos < end {
+				node := fgbItem{min_x: math.Inf(1), min_y: math.Inf(1), max_x: math.Inf(-1), max_y: math.Inf(-1),
+					offset: int64(pos)}
+				for j := 0; j < fgbNodeSize && pos < end; j, pos = j+1, pos+1 {
+					n := nodes[pos]
+					node.min_x, node.min_y = math.Min(node.min_x, n.min_x), math.Min(node.min_y, n.min_y)
+					node.max_x, node.max_y = math.Max(node.max_x, n.max_x), math.Max(node.max_y, n.max_y)
+				}
+				nodes[parent] = node
+				parent++
+			}
+		}
+		for _, n := range nodes {
+			binary.Write(w, binary.LittleEndian, []float64{n.min_x, n.min_y, n.max_x, n.max_y})
+			binary.Write(w, binary.LittleEndian, uint64(n.offset))
+		}
+	}
+
+	for _, it := range items {
+		if _, err = io.Copy(w, io.NewSectionReader(out, it.offset, it.size)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,244 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-kml"
+)
+
+// fbTable reads the fields of a FlatBuffers table
+type fbTable struct {
+	buf []byte
+	pos int
+}
+
+func fbRoot(buf []byte) fbTable {
+	return fbTable{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns where a field is, 0 when it is not set
+func (t fbTable) field(id int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*id >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	if o := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*id:])); o != 0 {
+		return t.pos + o
+	}
+	return 0
+}
+
+func (t fbTable) scalar(id, size int, def uint64) uint64 {
+	at := t.field(id)
+	switch {
+	case at == 0:
+		return def
+	case size == 1:
+		return uint64(t.buf[at])
+	case size == 2:
+		return uint64(binary.LittleEndian.Uint16(t.buf[at:]))
+	case size == 4:
+		return uint64(binary.LittleEndian.Uint32(t.buf[at:]))
+	}
+	return binary.LittleEndian.Uint64(t.buf[at:])
+}
+
+// ref follows the offset in a field
+func (t fbTable) ref(id int) int {
+	at := t.field(id)
+	if at == 0 {
+		return 0
+	}
+	return at + int(binary.LittleEndian.Uint32(t.buf[at:]))
+}
+
+func (t fbTable) table(id int) fbTable {
+	return fbTable{t.buf, t.ref(id)}
+}
+
+// vector returns where the elements of a vector are and how many there are
+func (t fbTable) vector(id int) (int, int) {
+	at := t.ref(id)
+	if at == 0 {
+		return 0, 0
+	}
+	return at + 4, int(binary.LittleEndian.Uint32(t.buf[at:]))
+}
+
+func (t fbTable) str(id int) string {
+	at, n := t.vector(id)
+	return string(t.buf[at : at+n])
+}
+
+func (t fbTable) doubles(id int) []float64 {
+	at, n := t.vector(id)
+	v := make([]float64, n)
+	for i := range v {
+		v[i] = math.Float64frombits(binary.LittleEndian.Uint64(t.buf[at+8*i:]))
+	}
+	return v
+}
+
+func (t fbTable) tables(id int) []fbTable {
+	at, n := t.vector(id)
+	v := make([]fbTable, n)
+	for i := range v {
+		slot := at + 4*i
+		v[i] = fbTable{t.buf, slot + int(binary.LittleEndian.Uint32(t.buf[slot:]))}
+	}
+	return v
+}
+
+// calcTreeSize is the size of the index of n features, as the FlatGeobuf
+// libraries work it out
+func calcTreeSize(n int) int {
+	nodes := n
+	for {
+		n = (n + fgbNodeSize - 1) / fgbNodeSize
+		nodes += n
+		if n == 1 {
+			break
+		}
+	}
+	return nodes * 40
+}
+
+func TestFGBLevels(t *testing.T) {
+	for _, tc := range []struct{ items, size int }{{1, 80}, {16, 680}, {17, 800}, {257, (257 + 17 + 2 + 1) * 40}} {
+		levels := fgbLevels(tc.items)
+		if got := levels[0][1] * 40; got != tc.size || got != calcTreeSize(tc.items) {
+			t.Errorf("index of %d items is %d bytes, want %d", tc.items, got, tc.size)
+		}
+		if levels[0][1]-levels[0][0] != tc.items {
+			t.Errorf("%d items have %d leaves", tc.items, levels[0][1]-levels[0][0])
+		}
+		if root := levels[len(levels)-1]; root != [2]int{0, 1} {
+			t.Errorf("root of %d items is %v", tc.items, root)
+		}
+	}
+}
+
+// writeFGB writes n points along a diagonal to a FlatGeobuf file
+func writeFGB(t *testing.T, n int) []byte {
+	var buf bytes.Buffer
+	s, err := NewFlatGeobufSink(&buf, "test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 7, 20, 8, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		r := &Record{
+			Coords: &kml.Coordinate{Lon: -77 + float64(i)/100, Lat: 38.9 + float64(i)/100},
+			Time:   start.Add(time.Duration(i) * time.Minute),
+			Data: map[string]interface{}{"SOURCE_FILE_PATH": "test.sqlite", "SOURCE_TABLE": "t",
+				"id": int64(i), "name": "p", "speed": 1.5},
+			Columns: []string{"SOURCE_FILE_PATH", "SOURCE_TABLE", "id", "name", "speed"},
+		}
+		if err = s.Record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFlatGeobufFile(t *testing.T) {
+	for _, n := range []int{1, 16, 17} {
+		b := writeFGB(t, n)
+		if !bytes.Equal(b[:8], fgbMagic) {
+			t.Fatalf("magic is %q", b[:8])
+		}
+		size := int(binary.LittleEndian.Uint32(b[8:]))
+		h := fbRoot(b[12 : 12+size])
+
+		if name := h.str(0); name != "test" {
+			t.Errorf("name is %q", name)
+		}
+		want_env := []float64{-77, 38.9, -77 + float64(n-1)/100, 38.9 + float64(n-1)/100}
+		env := h.doubles(1)
+		for i := range want_env {
+			if len(env) != 4 || math.Abs(env[i]-want_env[i]) > 1e-9 {
+				t.Fatalf("envelope is %v, want %v", env, want_env)
+			}
+		}
+		if kind := h.scalar(2, 1, 0); kind != fgbPoint {
+			t.Errorf("geometry type is %d", kind)
+		}
+		if has_z := h.scalar(3, 1, 0); has_z != 0 {
+			t.Errorf("has_z is set")
+		}
+		want_cols := []struct {
+			name string
+			kind uint64
+		}{{"time", fgbDateTime}, {"SOURCE_FILE_PATH", fgbString}, {"SOURCE_TABLE", fgbString},
+			{"id", fgbLong}, {"name", fgbString}, {"speed", fgbDouble}}
+		cols := h.tables(7)
+		if len(cols) != len(want_cols) {
+			t.Fatalf("%d columns, want %d", len(cols), len(want_cols))
+		}
+		for i, c := range cols {
+			if c.str(0) != want_cols[i].name || c.scalar(1, 1, 0) != want_cols[i].kind {
+				t.Errorf("column %d is %s of %d, want %v", i, c.str(0), c.scalar(1, 1, 0), want_cols[i])
+			}
+		}
+		if count := h.scalar(8, 8, 0); count != uint64(n) {
+			t.Errorf("features count is %d, want %d", count, n)
+		}
+		if node_size := h.scalar(9, 2, 16); node_size != fgbNodeSize {
+			t.Errorf("index node size is %d", node_size)
+		}
+		if crs := h.table(10); crs.str(0) != "EPSG" || crs.scalar(1, 4, 0) != 4326 {
+			t.Errorf("crs is %s:%d", crs.str(0), crs.scalar(1, 4, 0))
+		}
+
+		// The leaves are the last nodes of the index, each pointing at a
+		// feature with its size in front of it
+		index := 12 + size
+		features := index + calcTreeSize(n)
+		seen := make(map[int]bool)
+		total := 0
+		for i := calcTreeSize(n)/40 - n; i < calcTreeSize(n)/40; i++ {
+			node := b[index+40*i:]
+			min_x := math.Float64frombits(binary.LittleEndian.Uint64(node))
+			min_y := math.Float64frombits(binary.LittleEndian.Uint64(node[8:]))
+			offset := int(binary.LittleEndian.Uint64(node[32:]))
+			at := features + offset
+			if at+4 > len(b) {
+				t.Fatalf("leaf %d points at %d past the end of the file", i, at)
+			}
+			length := int(binary.LittleEndian.Uint32(b[at:]))
+			f := fbRoot(b[at+4 : at+4+length])
+			xy := f.table(0).doubles(1)
+			if len(xy) != 2 || xy[0] != min_x || xy[1] != min_y {
+				t.Errorf("leaf %d at %v, %v points at a feature at %v", i, min_x, min_y, xy)
+			}
+			props, _ := f.vector(1)
+			if column := binary.LittleEndian.Uint16(f.buf[props:]); column != 0 {
+				t.Errorf("first property of leaf %d is column %d, want the time", i, column)
+			}
+			seen[offset] = true
+			total += 4 + length
+		}
+		if len(seen) != n || features+total != len(b) {
+			t.Errorf("%d features take %d of the %d bytes after the index", len(seen), total, len(b)-features)
+		}
+	}
+}
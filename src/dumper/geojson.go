@@ -0,0 +1,200 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+type geojsonGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geojsonFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   interface{}            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geojsonPosition returns the GeoJSON position for an entry, altitude is only
+// included when one is set
+func geojsonPosition(e *Record) []float64 {
+	if e.Coords.Alt != 0 {
+		return []float64{e.Coords.Lon, e.Coords.Lat, e.Coords.Alt}
+	}
+	return []float64{e.Coords.Lon, e.Coords.Lat}
+}
+
+// geojsonPointProperties returns the entry data with the simplestyle marker
+// properties added when a style rule applies
+func geojsonPointProperties(e *Record, rules StyleRules) map[string]interface{} {
+	c, scale := rules.pointStyle(e)
+	if c == nil && scale == nil {
+		return e.Data
+	}
+	props := make(map[string]interface{}, len(e.Data)+2)
+	for k, v := range e.Data {
+		props[k] = v
+	}
+	if c != nil {
+		props["marker-color"] = hexColor(*c)
+	}
+	if scale != nil {
+		switch {
+		case *scale < 1:
+			props["marker-size"] = "small"
+		case *scale < 1.5:
+			props["marker-size"] = "medium"
+		default:
+			props["marker-size"] = "large"
+		}
+	}
+	return props
+}
+
+// geojsonSink writes out a FeatureCollection with one Point, or the shape of
+// its geometry, per entry and, when lines is set, one LineString per event.
+// When line style rules are given each event is split into segments so they
+// can be styled on their own.  Features are written as they are read so the
+// whole collection is never held in memory.
+type geojsonSink struct {
+	NopSink
+	w     *bufio.Writer
+	lines bool
+	rules StyleRules
+	first bool
+}
+
+// NewGeoJSONSink returns a sink writing GeoJSON to w, with a LineString for
+// every event when lines is set
+func NewGeoJSONSink(w io.Writer, lines bool, rules StyleRules) Sink {
+	g := &geojsonSink{
+		w:     bufio.NewWriter(w),
+		lines: lines,
+		rules: rules,
+		first: true,
+	}
+	g.w.WriteString("{\"type\":\"FeatureCollection\",\"features\":[")
+	return g
+}
+
+func (g *geojsonSink) write(f *geojsonFeature) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if !g.first {
+		g.w.WriteByte(',')
+	}
+	g.first = false
+	g.w.WriteString("\n")
+	_, err = g.w.Write(b)
+	return err
+}
+
+func (g *geojsonSink) Record(e *Record) error {
+	f := &geojsonFeature{
+		Type:       "Feature",
+		Properties: geojsonPointProperties(e, g.rules),
+	}
+	if e.Geometry != nil {
+		g, err := geojson.Encode(e.Geometry)
+		if err != nil {
+			return err
+		}
+		f.Geometry = g
+	} else if e.Coords != nil {
+		f.Geometry = &geojsonGeometry{
+			Type:        "Point",
+			Coordinates: geojsonPosition(e),
+		}
+	}
+	return g.write(f)
+}
+
+func (g *geojsonSink) Event(ev *Event) error {
+	if !g.lines || ev.Waypoints {
+		return nil
+	}
+	var pts []*Record
+	for _, e := range ev.Records {
+		if e.Coords != nil {
+			pts = append(pts, e)
+		}
+	}
+	if len(pts) < 2 {
+		return nil
+	}
+	s_time := ev.Records[0].Time
+	e_time := ev.Records[len(ev.Records)-1].Time
+	props := map[string]interface{}{
+		"SOURCE_FILE_PATH": ev.File,
+		"SOURCE_TABLE":     ev.Table,
+		"POINTS":           len(ev.Records),
+		"START_TIME":       s_time.Format(time.RFC3339Nano),
+		"END_TIME":         e_time.Format(time.RFC3339Nano),
+	}
+
+	if !g.rules.hasLine() {
+		var line [][]float64
+		for _, e := range pts {
+			line = append(line, geojsonPosition(e))
+		}
+		return g.write(&geojsonFeature{
+			Type: "Feature",
+			Geometry: &geojsonGeometry{
+				Type:        "LineString",
+				Coordinates: line,
+			},
+			Properties: props,
+		})
+	}
+
+	for i := 1; i < len(pts); i++ {
+		seg_props := make(map[string]interface{}, len(props)+3)
+		for k, v := range props {
+			seg_props[k] = v
+		}
+		seg_props["SEGMENT"] = i
+		c, width := g.rules.segmentStyle(pts[i-1], pts[i])
+		if c != nil {
+			seg_props["stroke"] = hexColor(*c)
+		}
+		if width != nil {
+			seg_props["stroke-width"] = *width
+		}
+		f := &geojsonFeature{
+			Type: "Feature",
+			Geometry: &geojsonGeometry{
+				Type:        "LineString",
+				Coordinates: [][]float64{geojsonPosition(pts[i-1]), geojsonPosition(pts[i])},
+			},
+			Properties: seg_props,
+		}
+		if err := g.write(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *geojsonSink) Close() error {
+	g.w.WriteString("\n]}\n")
+	return g.w.Flush()
+}
@@ -0,0 +1,486 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/ewkb"
+	"github.com/twpayne/go-geom/encoding/geojson"
+	"github.com/twpayne/go-geom/encoding/wkb"
+	"github.com/twpayne/go-geom/encoding/wkt"
+	"github.com/twpayne/go-kml"
+)
+
+// geometryNames are the column names used for geometry by the GIS tools
+var geometryNames = []string{"geom", "geometry", "the_geom", "wkb_geometry",
+	"shape", "wkt", "geojson", "geom_wkt", "wkt_geometry"}
+
+// geometryTypes are the declared types of geometry columns in GeoPackage and
+// SpatiaLite tables
+var geometryTypes = []string{"geometry", "point", "linestring", "polygon",
+	"multipoint", "multilinestring", "multipolygon", "geometrycollection",
+	"curve", "surface", "multicurve", "multisurface"}
+
+// IsGeometryColumn returns true if the column name or its declared type is
+// one used for geometry
+func IsGeometryColumn(name, decl_type string) bool {
+	if contains(geometryNames, strings.ToLower(name)) {
+		return true
+	}
+	t := strings.ToLower(strings.TrimSpace(decl_type))
+	t = strings.TrimSuffix(strings.TrimSuffix(t, "m"), "z")
+	t = strings.TrimSpace(t)
+	return contains(geometryTypes, t)
+}
+
+// geometryColumn returns the first geometry column, decl_types may be nil
+// when the declared types are not known, or -1
+func geometryColumn(clm_names, decl_types []string) int {
+	for i, c := range clm_names {
+		decl := ""
+		if i < len(decl_types) {
+			decl = decl_types[i]
+		}
+		if IsGeometryColumn(c, decl) {
+			return i
+		}
+	}
+	return -1
+}
+
+// geometrySamples is how many values of a column are tried before it is
+// taken not to hold geometries
+const geometrySamples = 200
+
+// decodesGeometry returns true once one of the first values of the column,
+// selected from a table or a query in parentheses, decodes as a geometry
+func decodesGeometry(conn *sqlite3.Conn, from, column string) (bool, error) {
+	stmt, err := conn.Prepare(`SELECT `+quoteName(column)+` FROM `+from+
+		` WHERE `+quoteName(column)+` IS NOT NULL LIMIT ?`, geometrySamples)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil || !hasRow {
+			return false, err
+		}
+		var v interface{}
+		if err = stmt.Scan(&v); err != nil {
+			return false, err
+		}
+		if g, _, err := DecodeGeometry(v); err == nil && g != nil {
+			return true, nil
+		}
+	}
+}
+
+// DecodeGeometry reads the geometry out of a column value, which may be a
+// GeoPackage, SpatiaLite, WKB or EWKB blob, or WKT or GeoJSON text.  The SRID
+// is returned when the value has one, otherwise 0.  An empty geometry is
+// returned as nil.
+func DecodeGeometry(v interface{}) (geom.T, int, error) {
+	switch b := v.(type) {
+	case string:
+		return decodeGeometryText(b)
+	case []byte:
+		if bytes.HasPrefix(b, []byte("GP")) {
+			return decodeGeometryBlob(b)
+		}
+		if t := bytes.TrimSpace(b); len(t) > 0 && (t[0] == '{' || t[0] >= 'A' && t[0] <= 'z') {
+			// Text stored as a blob
+			return decodeGeometryText(string(b))
+		}
+		return decodeGeometryBlob(b)
+	}
+	return nil, 0, fmt.Errorf("no geometry in a %T value", v)
+}
+
+// decodeGeometryBlob reads a binary geometry by its header
+func decodeGeometryBlob(b []byte) (geom.T, int, error) {
+	switch {
+	case len(b) >= 8 && b[0] == 'G' && b[1] == 'P':
+		return decodeGeoPackage(b)
+	case len(b) >= 44 && b[0] == 0x00 && b[1] <= 1 && b[38] == 0x7C && b[len(b)-1] == 0xFE:
+		if g, srid, err := decodeSpatiaLite(b); err == nil {
+			return g, srid, nil
+		}
+	case len(b) < 5:
+		return nil, 0, errors.New("geometry blob too short")
+	}
+	g, err := wkb.Unmarshal(b)
+	if err != nil {
+		// PostGIS extended WKB carries the SRID and the Z and M in flags
+		var e error
+		if g, e = ewkb.Unmarshal(b); e != nil {
+			return nil, 0, err
+		}
+	}
+	return emptyGeometry(g), g.SRID(), nil
+}
+
+// decodeGeometryText reads WKT, with an optional SRID=n; in front, or a
+// GeoJSON geometry or feature
+func decodeGeometryText(s string) (geom.T, int, error) {
+	s = strings.TrimSpace(s)
+	srid := 0
+	if strings.HasPrefix(strings.ToUpper(s), "SRID=") {
+		i := strings.IndexByte(s, ';')
+		if i < 0 {
+			return nil, 0, errors.New("missing ; after the SRID")
+		}
+		n, err := strconv.Atoi(s[5:i])
+		if err != nil {
+			return nil, 0, fmt.Errorf("bad SRID %q", s[5:i])
+		}
+		srid, s = n, strings.TrimSpace(s[i+1:])
+	}
+	if strings.HasPrefix(s, "{") {
+		var f struct {
+			Type     string          `json:"type"`
+			Geometry json.RawMessage `json:"geometry"`
+		}
+		if err := json.Unmarshal([]byte(s), &f); err != nil {
+			return nil, 0, err
+		}
+		raw := []byte(s)
+		if f.Type == "Feature" {
+			if len(f.Geometry) == 0 || string(f.Geometry) == "null" {
+				return nil, 0, nil
+			}
+			raw = f.Geometry
+		}
+		var g geom.T
+		if err := geojson.Unmarshal(raw, &g); err != nil {
+			return nil, 0, err
+		}
+		return emptyGeometry(g), srid, nil
+	}
+	g, err := wkt.Unmarshal(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	return emptyGeometry(g), srid, nil
+}
+
+// emptyGeometry returns nil for a geometry without any coordinates
+func emptyGeometry(g geom.T) geom.T {
+	if g == nil || g.Empty() {
+		return nil
+	}
+	if p, ok := g.(*geom.Point); ok && (math.IsNaN(p.X()) || math.IsNaN(p.Y())) {
+		// WKB writes an empty point as NaN coordinates
+		return nil
+	}
+	return g
+}
+
+// gpkgEnvelopes is the size of the envelope by the indicator in the flags
+var gpkgEnvelopes = []int{0, 32, 48, 48, 64}
+
+// decodeGeoPackage reads a GeoPackage binary geometry, a header with the SRS
+// and an envelope followed by WKB
+func decodeGeoPackage(b []byte) (geom.T, int, error) {
+	flags := b[3]
+	var order binary.ByteOrder = binary.BigEndian
+	if flags&0x01 != 0 {
+		order = binary.LittleEndian
+	}
+	srid := int(int32(order.Uint32(b[4:8])))
+	if flags&0x20 != 0 {
+		return nil, srid, errors.New("extended GeoPackage geometry not supported")
+	}
+	env := int(flags>>1) & 0x07
+	if env >= len(gpkgEnvelopes) {
+		return nil, srid, fmt.Errorf("bad GeoPackage envelope %d", env)
+	}
+	if flags&0x10 != 0 {
+		return nil, srid, nil
+	}
+	start := 8 + gpkgEnvelopes[env]
+	if len(b) <= start {
+		return nil, srid, errors.New("GeoPackage geometry too short")
+	}
+	g, err := wkb.Unmarshal(b[start:])
+	if err != nil {
+		return nil, srid, err
+	}
+	return emptyGeometry(g), srid, nil
+}
+
+// spatialiteReader walks the body of a SpatiaLite geometry blob
+type spatialiteReader struct {
+	b     []byte
+	pos   int
+	order binary.ByteOrder
+}
+
+var errSpatiaLiteShort = errors.New("SpatiaLite geometry too short")
+
+func (r *spatialiteReader) u32() (uint32, error) {
+	if r.pos+4 > len(r.b) {
+		return 0, errSpatiaLiteShort
+	}
+	v := r.order.Uint32(r.b[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *spatialiteReader) f64() (float64, error) {
+	if r.pos+8 > len(r.b) {
+		return 0, errSpatiaLiteShort
+	}
+	v := math.Float64frombits(r.order.Uint64(r.b[r.pos:]))
+	r.pos += 8
+	return v, nil
+}
+
+func (r *spatialiteReader) f32() (float64, error) {
+	if r.pos+4 > len(r.b) {
+		return 0, errSpatiaLiteShort
+	}
+	v := math.Float32frombits(r.order.Uint32(r.b[r.pos:]))
+	r.pos += 4
+	return float64(v), nil
+}
+
+// decodeSpatiaLite reads a SpatiaLite geometry blob, a header with the SRID
+// and MBR followed by the class type and the coordinates, see
+// https://www.gaia-gis.it/gaia-sins/BLOB-Geometry.html
+func decodeSpatiaLite(b []byte) (geom.T, int, error) {
+	r := &spatialiteReader{b: b[:len(b)-1], pos: 39, order: binary.BigEndian}
+	if b[1] == 0x01 {
+		r.order = binary.LittleEndian
+	}
+	srid := int(int32(r.order.Uint32(b[2:6])))
+	class, err := r.u32()
+	if err != nil {
+		return nil, srid, err
+	}
+	g, err := r.geometry(class)
+	if err != nil {
+		return nil, srid, err
+	}
+	if r.pos != len(r.b) {
+		return nil, srid, errors.New("trailing bytes in SpatiaLite geometry")
+	}
+	return emptyGeometry(g), srid, nil
+}
+
+// spatialiteLayout returns the layout of a class type and whether it is compressed
+func spatialiteLayout(class uint32) (geom.Layout, bool, error) {
+	var layout geom.Layout
+	switch (class % 1000000) / 1000 {
+	case 0:
+		layout = geom.XY
+	case 1:
+		layout = geom.XYZ
+	case 2:
+		layout = geom.XYM
+	case 3:
+		layout = geom.XYZM
+	default:
+		return geom.NoLayout, false, fmt.Errorf("unknown SpatiaLite class %d", class)
+	}
+	return layout, class >= 1000000, nil
+}
+
+// geometry reads the body of one geometry of the class
+func (r *spatialiteReader) geometry(class uint32) (geom.T, error) {
+	layout, compressed, err := spatialiteLayout(class)
+	if err != nil {
+		return nil, err
+	}
+	switch class % 1000 {
+	case 1:
+		flat, err := r.coords(layout, 1, false)
+		if err != nil {
+			return nil, err
+		}
+		return geom.NewPointFlat(layout, flat), nil
+	case 2:
+		n, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		flat, err := r.coords(layout, int(n), compressed)
+		if err != nil {
+			return nil, err
+		}
+		return geom.NewLineStringFlat(layout, flat), nil
+	case 3:
+		rings, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		var flat []float64
+		var ends []int
+		for i := uint32(0); i < rings; i++ {
+			n, err := r.u32()
+			if err != nil {
+				return nil, err
+			}
+			ring, err := r.coords(layout, int(n), compressed)
+			if err != nil {
+				return nil, err
+			}
+			flat = append(flat, ring...)
+			ends = append(ends, len(flat))
+		}
+		return geom.NewPolygonFlat(layout, flat, ends), nil
+	case 4, 5, 6, 7:
+		n, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		var parts []geom.T
+		for i := uint32(0); i < n; i++ {
+			if r.pos >= len(r.b) || r.b[r.pos] != 0x69 {
+				return nil, errors.New("missing SpatiaLite entity marker")
+			}
+			r.pos++
+			sub, err := r.u32()
+			if err != nil {
+				return nil, err
+			}
+			part, err := r.geometry(sub)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		return spatialiteCollection(class%1000, layout, parts)
+	}
+	return nil, fmt.Errorf("unknown SpatiaLite class %d", class)
+}
+
+// spatialiteCollection puts the parts of a multi geometry together
+func spatialiteCollection(kind uint32, layout geom.Layout, parts []geom.T) (geom.T, error) {
+	var err error
+	switch kind {
+	case 4:
+		mp := geom.NewMultiPoint(layout)
+		for _, p := range parts {
+			if pt, ok := p.(*geom.Point); ok {
+				err = mp.Push(pt)
+			} else {
+				err = fmt.Errorf("%T in a multipoint", p)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return mp, nil
+	case 5:
+		mls := geom.NewMultiLineString(layout)
+		for _, p := range parts {
+			if ls, ok := p.(*geom.LineString); ok {
+				err = mls.Push(ls)
+			} else {
+				err = fmt.Errorf("%T in a multilinestring", p)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return mls, nil
+	case 6:
+		mp := geom.NewMultiPolygon(layout)
+		for _, p := range parts {
+			if pg, ok := p.(*geom.Polygon); ok {
+				err = mp.Push(pg)
+			} else {
+				err = fmt.Errorf("%T in a multipolygon", p)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return mp, nil
+	}
+	gc := geom.NewGeometryCollection()
+	if err = gc.Push(parts...); err != nil {
+		return nil, err
+	}
+	return gc, nil
+}
+
+// coords reads n coordinates, a compressed line has the first and last
+// coordinates in full and the ones between as float offsets from the one
+// before, with the M always in full
+func (r *spatialiteReader) coords(layout geom.Layout, n int, compressed bool) ([]float64, error) {
+	stride := layout.Stride()
+	if n < 0 || n*stride*4 > len(r.b)-r.pos {
+		return nil, errSpatiaLiteShort
+	}
+	flat := make([]float64, 0, n*stride)
+	m := layout.MIndex()
+	for i := 0; i < n; i++ {
+		full := !compressed || i == 0 || i == n-1
+		for j := 0; j < stride; j++ {
+			var v float64
+			var err error
+			if full || j == m {
+				v, err = r.f64()
+			} else {
+				v, err = r.f32()
+				v += flat[len(flat)-stride]
+			}
+			if err != nil {
+				return nil, err
+			}
+			flat = append(flat, v)
+		}
+	}
+	return flat, nil
+}
+
+// geometryCoordinate returns the point to place a geometry at, the point
+// itself or the center of the bounds of a line or shape
+func geometryCoordinate(g geom.T) *kml.Coordinate {
+	if p, ok := g.(*geom.Point); ok {
+		c := &kml.Coordinate{Lon: p.X(), Lat: p.Y()}
+		if z := p.Layout().ZIndex(); z >= 0 {
+			c.Alt = p.FlatCoords()[z]
+		}
+		return c
+	}
+	b := g.Bounds()
+	if b.IsEmpty() {
+		return nil
+	}
+	return &kml.Coordinate{
+		Lon: (b.Min(0) + b.Max(0)) / 2,
+		Lat: (b.Min(1) + b.Max(1)) / 2,
+	}
+}
+
+// isPointGeometry returns true for a point or a set of points
+func isPointGeometry(g geom.T) bool {
+	switch g.(type) {
+	case *geom.Point, *geom.MultiPoint:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/twpayne/go-geom"
+)
+
+// geomBytes joins hex strings and numbers into a blob, the numbers in the
+// byte order given by the last binary.ByteOrder in the list
+func geomBytes(parts ...interface{}) []byte {
+	var buf bytes.Buffer
+	var order binary.ByteOrder = binary.LittleEndian
+	for _, p := range parts {
+		switch v := p.(type) {
+		case binary.ByteOrder:
+			order = v
+		case string:
+			b, err := hex.DecodeString(v)
+			if err != nil {
+				panic(err)
+			}
+			buf.Write(b)
+		default:
+			binary.Write(&buf, order, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+var (
+	// WKB of POINT(-77.0365 38.8977), little and big endian
+	wkbPointLE = geomBytes("01", uint32(1), -77.0365, 38.8977)
+	wkbPointBE = geomBytes(binary.BigEndian, "00", uint32(1), -77.0365, 38.8977)
+	// WKB of POINT EMPTY, written as NaN coordinates
+	wkbEmptyPoint = geomBytes("01", uint32(1), "000000000000f87f", "000000000000f87f")
+	// WKB of LINESTRING Z(0 0 10, 1 1 20)
+	wkbLineZ = geomBytes("01", uint32(1002), uint32(2), 0.0, 0.0, 10.0, 1.0, 1.0, 20.0)
+	// spatialiteHeader starts a little endian SpatiaLite blob in SRID 4326
+	// with an MBR of 0 0 1 1
+	spatialiteHeader = geomBytes("0001", int32(4326), 0.0, 0.0, 1.0, 1.0, "7c")
+)
+
+var decodeGeometryTests = []struct {
+	name   string
+	v      interface{}
+	layout geom.Layout
+	flat   []float64 // nil for an empty geometry
+	srid   int
+}{
+	{"WKB point", wkbPointLE, geom.XY, []float64{-77.0365, 38.8977}, 0},
+	{"WKB point big endian", wkbPointBE, geom.XY, []float64{-77.0365, 38.8977}, 0},
+	{"WKB line Z", wkbLineZ, geom.XYZ, []float64{0, 0, 10, 1, 1, 20}, 0},
+	{"WKB empty point", wkbEmptyPoint, geom.NoLayout, nil, 0},
+	{"EWKB point with SRID",
+		geomBytes("01", uint32(0x20000001), uint32(4326), -77.0365, 38.8977),
+		geom.XY, []float64{-77.0365, 38.8977}, 4326},
+	{"EWKB point Z with SRID",
+		geomBytes("01", uint32(0xa0000001), uint32(3857), 1.0, 2.0, 3.0),
+		geom.XYZ, []float64{1, 2, 3}, 3857},
+	{"GeoPackage point with envelope",
+		append(geomBytes("47500003", int32(4326), -77.0365, -77.0365, 38.8977, 38.8977), wkbPointLE...),
+		geom.XY, []float64{-77.0365, 38.8977}, 4326},
+	{"GeoPackage big endian without envelope",
+		append(geomBytes(binary.BigEndian, "47500000", int32(27700)), wkbPointBE...),
+		geom.XY, []float64{-77.0365, 38.8977}, 27700},
+	{"GeoPackage XYZ envelope",
+		append(geomBytes("47500005", int32(4326), 0.0, 1.0, 0.0, 1.0, 10.0, 20.0), wkbLineZ...),
+		geom.XYZ, []float64{0, 0, 10, 1, 1, 20}, 4326},
+	{"GeoPackage empty flag",
+		append(geomBytes("47500011", int32(4326)), wkbEmptyPoint...),
+		geom.NoLayout, nil, 4326},
+	{"SpatiaLite point",
+		geomBytes(spatialiteHeader, uint32(1), -77.0365, 38.8977, "fe"),
+		geom.XY, []float64{-77.0365, 38.8977}, 4326},
+	{"SpatiaLite point Z",
+		geomBytes(spatialiteHeader, uint32(1001), 1.0, 2.0, 3.0, "fe"),
+		geom.XYZ, []float64{1, 2, 3}, 4326},
+	{"SpatiaLite compressed line",
+		geomBytes(spatialiteHeader, uint32(1000002), uint32(3), 0.0, 0.0, float32(0.5), float32(0.25), 1.0, 1.0, "fe"),
+		geom.XY, []float64{0, 0, 0.5, 0.25, 1, 1}, 4326},
+	{"SpatiaLite polygon",
+		geomBytes(spatialiteHeader, uint32(3), uint32(1), uint32(4), 0.0, 0.0, 1.0, 0.0, 1.0, 1.0, 0.0, 0.0, "fe"),
+		geom.XY, []float64{0, 0, 1, 0, 1, 1, 0, 0}, 4326},
+	{"SpatiaLite multipoint",
+		geomBytes(spatialiteHeader, uint32(4), uint32(2), "69", uint32(1), 0.0, 0.0, "69", uint32(1), 1.0, 1.0, "fe"),
+		geom.XY, []float64{0, 0, 1, 1}, 4326},
+	{"WKT point", "POINT (-77.0365 38.8977)", geom.XY, []float64{-77.0365, 38.8977}, 0},
+	{"WKT with SRID", "SRID=4326;LINESTRING(0 0,1 1)", geom.XY, []float64{0, 0, 1, 1}, 4326},
+	{"WKT in a blob", []byte("POINT Z (1 2 3)"), geom.XYZ, []float64{1, 2, 3}, 0},
+	{"WKT empty", "POINT EMPTY", geom.NoLayout, nil, 0},
+	{"GeoJSON geometry", `{"type": "Point", "coordinates": [-77.0365, 38.8977]}`,
+		geom.XY, []float64{-77.0365, 38.8977}, 0},
+	{"GeoJSON feature in a blob",
+		[]byte(`{"type": "Feature", "properties": {}, "geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 1]]}}`),
+		geom.XY, []float64{0, 0, 1, 1}, 0},
+	{"GeoJSON feature without a geometry", `{"type": "Feature", "properties": {}, "geometry": null}`,
+		geom.NoLayout, nil, 0},
+}
+
+func TestDecodeGeometry(t *testing.T) {
+	for _, tc := range decodeGeometryTests {
+		g, srid, err := DecodeGeometry(tc.v)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if srid != tc.srid {
+			t.Errorf("%s: SRID %d, want %d", tc.name, srid, tc.srid)
+		}
+		if tc.flat == nil {
+			if g != nil {
+				t.Errorf("%s: empty geometry decoded as %v", tc.name, g.FlatCoords())
+			}
+			continue
+		}
+		if g == nil {
+			t.Errorf("%s: decoded as empty", tc.name)
+			continue
+		}
+		if g.Layout() != tc.layout || !reflect.DeepEqual(g.FlatCoords(), tc.flat) {
+			t.Errorf("%s: %v %v, want %v %v", tc.name, g.Layout(), g.FlatCoords(), tc.layout, tc.flat)
+		}
+	}
+}
+
+func TestDecodeGeometryErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"number", int64(4326)},
+		{"short blob", []byte{0x01, 0x01}},
+		{"truncated WKB", wkbPointLE[:12]},
+		{"extended GeoPackage", append(geomBytes("47500021", int32(4326)), wkbPointLE...)},
+		{"bad GeoPackage envelope", append(geomBytes("4750000b", int32(4326)), wkbPointLE...)},
+		{"missing SRID separator", "SRID=4326 POINT(1 2)"},
+		{"bad SRID", "SRID=WGS84;POINT(1 2)"},
+		{"bad WKT", "POINT (1)"},
+		{"bad GeoJSON", `{"type": "Point"`},
+	} {
+		if g, _, err := DecodeGeometry(tc.v); err == nil {
+			t.Errorf("%s: decoded as %v", tc.name, g)
+		}
+	}
+}
+
+func TestDecodesGeometrySamples(t *testing.T) {
+	conn, err := sqlite3.Open(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = conn.Exec(`CREATE TABLE "my places" (shape TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	// Only a geometry after the sampled values, the column is not read as one
+	for i := 0; i < geometrySamples; i++ {
+		if err = conn.Exec(`INSERT INTO "my places" VALUES ('round')`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = conn.Exec(`INSERT INTO "my places" VALUES ('POINT (1 2)')`); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := decodesGeometry(conn, quoteName("my places"), "shape"); err != nil || ok {
+		t.Errorf("geometry after %d values of text is found: %v %v", geometrySamples, ok, err)
+	}
+	if err = conn.Exec(`UPDATE "my places" SET shape = 'POINT (3 4)' WHERE rowid = 5`); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := decodesGeometry(conn, quoteName("my places"), "shape"); err != nil || !ok {
+		t.Errorf("geometry among the sampled values is not found: %v %v", ok, err)
+	}
+}
@@ -0,0 +1,359 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkb"
+)
+
+// GeoPackage 1.2 reference: https://www.geopackage.org/spec120/
+
+const gpkgSchema = `
+CREATE TABLE gpkg_spatial_ref_sys (
+  srs_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL PRIMARY KEY,
+  organization TEXT NOT NULL,
+  organization_coordsys_id INTEGER NOT NULL,
+  definition TEXT NOT NULL,
+  description TEXT
+);
+CREATE TABLE gpkg_contents (
+  table_name TEXT NOT NULL PRIMARY KEY,
+  data_type TEXT NOT NULL,
+  identifier TEXT UNIQUE,
+  description TEXT DEFAULT '',
+  last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  min_x DOUBLE,
+  min_y DOUBLE,
+  max_x DOUBLE,
+  max_y DOUBLE,
+  srs_id INTEGER,
+  CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+);
+CREATE TABLE gpkg_geometry_columns (
+  table_name TEXT NOT NULL,
+  column_name TEXT NOT NULL,
+  geometry_type_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL,
+  z TINYINT NOT NULL,
+  m TINYINT NOT NULL,
+  CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+  CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+  CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+  CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id)
+);
+CREATE TABLE gpkg_extensions (
+  table_name TEXT,
+  column_name TEXT,
+  extension_name TEXT NOT NULL,
+  definition TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name)
+);
+INSERT INTO gpkg_spatial_ref_sys VALUES
+  ('WGS 84 geodetic', 4326, 'EPSG', 4326, 'GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]', 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid'),
+  ('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+  ('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system');
+PRAGMA application_id = 1196444487;
+PRAGMA user_version = 10200;
+`
+
+// gpkgRtreeTriggers keep the rtree index up to date when the features are
+// changed by a GIS tool, %t is the table, %c the geometry column and %i the
+// key, with any double quotes in them doubled.  They use the ST_ functions
+// the GIS tools provide, so they are only added once the features are
+// written.
+const gpkgRtreeTriggers = `
+CREATE TRIGGER "rtree_%t_%c_insert" AFTER INSERT ON "%t" WHEN (new."%c" NOT NULL AND NOT ST_IsEmpty(NEW."%c"))
+BEGIN
+  INSERT OR REPLACE INTO "rtree_%t_%c" VALUES (NEW."%i", ST_MinX(NEW."%c"), ST_MaxX(NEW."%c"), ST_MinY(NEW."%c"), ST_MaxY(NEW."%c"));
+END;
+CREATE TRIGGER "rtree_%t_%c_update1" AFTER UPDATE OF "%c" ON "%t" WHEN OLD."%i" = NEW."%i" AND (NEW."%c" NOTNULL AND NOT ST_IsEmpty(NEW."%c"))
+BEGIN
+  INSERT OR REPLACE INTO "rtree_%t_%c" VALUES (NEW."%i", ST_MinX(NEW."%c"), ST_MaxX(NEW."%c"), ST_MinY(NEW."%c"), ST_MaxY(NEW."%c"));
+END;
+CREATE TRIGGER "rtree_%t_%c_update2" AFTER UPDATE OF "%c" ON "%t" WHEN OLD."%i" = NEW."%i" AND (NEW."%c" ISNULL OR ST_IsEmpty(NEW."%c"))
+BEGIN
+  DELETE FROM "rtree_%t_%c" WHERE id = OLD."%i";
+END;
+CREATE TRIGGER "rtree_%t_%c_update3" AFTER UPDATE ON "%t" WHEN OLD."%i" != NEW."%i" AND (NEW."%c" NOTNULL AND NOT ST_IsEmpty(NEW."%c"))
+BEGIN
+  DELETE FROM "rtree_%t_%c" WHERE id = OLD."%i";
+  INSERT OR REPLACE INTO "rtree_%t_%c" VALUES (NEW."%i", ST_MinX(NEW."%c"), ST_MaxX(NEW."%c"), ST_MinY(NEW."%c"), ST_MaxY(NEW."%c"));
+END;
+CREATE TRIGGER "rtree_%t_%c_update4" AFTER UPDATE ON "%t" WHEN OLD."%i" != NEW."%i" AND (NEW."%c" ISNULL OR ST_IsEmpty(NEW."%c"))
+BEGIN
+  DELETE FROM "rtree_%t_%c" WHERE id IN (OLD."%i", NEW."%i");
+END;
+CREATE TRIGGER "rtree_%t_%c_delete" AFTER DELETE ON "%t" WHEN old."%c" NOT NULL
+BEGIN
+  DELETE FROM "rtree_%t_%c" WHERE id = OLD."%i";
+END;
+`
+
+// gpkgGeometry encodes a geometry as a GeoPackage binary, WKB after a header
+// with the SRS and, for all but points, the envelope
+func gpkgGeometry(g geom.T) ([]byte, error) {
+	var buf bytes.Buffer
+	flags := byte(0x01) // little endian header
+	_, point := g.(*geom.Point)
+	if !point {
+		flags |= 0x01 << 1 // xy envelope
+	}
+	buf.Write([]byte{'G', 'P', 0, flags})
+	binary.Write(&buf, binary.LittleEndian, int32(4326))
+	if !point {
+		b := g.Bounds()
+		binary.Write(&buf, binary.LittleEndian, []float64{b.Min(0), b.Max(0), b.Min(1), b.Max(1)})
+	}
+	if err := wkb.Write(&buf, wkb.NDR, g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// recordGeometry is the shape of a record, its geometry or else its point
+func recordGeometry(e *Record) geom.T {
+	if e.Geometry != nil {
+		return e.Geometry
+	}
+	if e.Coords == nil {
+		return nil
+	}
+	if e.Coords.Alt != 0 {
+		return geom.NewPointFlat(geom.XYZ, []float64{e.Coords.Lon, e.Coords.Lat, e.Coords.Alt})
+	}
+	return geom.NewPointFlat(geom.XY, []float64{e.Coords.Lon, e.Coords.Lat})
+}
+
+// gpkgLayer is a feature table with its rtree index and extent
+type gpkgLayer struct {
+	*sqlTable
+	rtree  *sqlite3.Stmt
+	bounds *geom.Bounds
+}
+
+// gpkgSink writes the records to an OGC GeoPackage, with one feature table
+// for each source table, holding the rows of that table from every file, and,
+// when lines is set, an events table with a LineString for every event.
+// Every column is kept with the type of its values and an rtree index is
+// built for each table.
+type gpkgSink struct {
+	NopSink
+	conn   *sqlite3.Conn
+	lines  bool
+	layers map[string]*gpkgLayer // by the source table
+	order  []*gpkgLayer
+	events *gpkgLayer
+	used   map[string]bool
+}
+
+// NewGPKGSink returns a sink writing a GeoPackage to path, any file there is
+// replaced, with an events table of the event paths when lines is set
+func NewGPKGSink(path string, lines bool) (Sink, error) {
+	conn, err := createDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Exec(gpkgSchema); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	g := &gpkgSink{conn: conn, lines: lines, layers: make(map[string]*gpkgLayer), used: make(map[string]bool)}
+	if lines {
+		// Keep the name for the events, whichever table comes first
+		g.used["events"] = true
+	}
+	return g, nil
+}
+
+// layer returns the feature table of a source table, creating it when the
+// table is first seen
+func (g *gpkgSink) layer(table string) (*gpkgLayer, error) {
+	if l, ok := g.layers[table]; ok {
+		return l, nil
+	}
+	name := table
+	switch lname := strings.ToLower(name); {
+	case table == eventsLayer:
+		name = "events"
+		delete(g.used, name)
+	case name == "":
+		name = "query"
+	case strings.HasPrefix(lname, "gpkg_") || strings.HasPrefix(lname, "rtree_") || strings.HasPrefix(lname, "sqlite_"):
+		// Names the GeoPackage and SQLite keep for themselves
+		name = "t_" + name
+	}
+	base := name
+	for i := 1; g.used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	g.used[strings.ToLower(name)] = true
+
+	desc := "Table " + table
+	switch table {
+	case "":
+		desc = "Custom query"
+	case eventsLayer:
+		desc = "Event paths"
+	}
+	// The SOURCE_FILE_PATH and SOURCE_TABLE columns come first in the data
+	t, err := newSQLTable(g.conn, name, "fid INTEGER PRIMARY KEY AUTOINCREMENT", "geom GEOMETRY", "TIME_UTC DATETIME")
+	if err != nil {
+		return nil, err
+	}
+	if err = g.conn.Exec(`INSERT INTO gpkg_contents (table_name, data_type, identifier, description, srs_id)
+		VALUES (?, 'features', ?, ?, 4326)`, name, name, desc); err != nil {
+		return nil, err
+	}
+	if err = g.conn.Exec(`INSERT INTO gpkg_geometry_columns VALUES (?, 'geom', 'GEOMETRY', 4326, 2, 0)`, name); err != nil {
+		return nil, err
+	}
+	if err = g.conn.Exec(`INSERT INTO gpkg_extensions VALUES (?, 'geom', 'gpkg_rtree_index',
+		'http://www.geopackage.org/spec120/#extension_rtree', 'write-only')`, name); err != nil {
+		return nil, err
+	}
+	rtree := quoteName("rtree_" + name + "_geom")
+	if err = g.conn.Exec(`CREATE VIRTUAL TABLE ` + rtree + ` USING rtree(id, minx, maxx, miny, maxy)`); err != nil {
+		return nil, err
+	}
+	stmt, err := g.conn.Prepare(`INSERT INTO ` + rtree + ` VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, err
+	}
+	l := &gpkgLayer{sqlTable: t, rtree: stmt}
+	g.layers[table] = l
+	g.order = append(g.order, l)
+	return l, nil
+}
+
+// eventsLayer is the key of the events table, which no source table can have
+const eventsLayer = "\x00events"
+
+// feature writes a row with its geometry into the layer and its index
+func (g *gpkgSink) feature(l *gpkgLayer, shape geom.T, t time.Time, data map[string]interface{}, columns []string) error {
+	var blob interface{}
+	if shape != nil {
+		b, err := gpkgGeometry(shape)
+		if err != nil {
+			return err
+		}
+		blob = b
+	}
+	var when interface{}
+	if !t.IsZero() {
+		when = t.UTC().Format(utcLayout)
+	}
+	if err := l.write(data, columns, nil, blob, when); err != nil {
+		return err
+	}
+	if shape == nil {
+		return nil
+	}
+	b := shape.Bounds()
+	if l.bounds == nil {
+		l.bounds = geom.NewBounds(geom.XY)
+	}
+	l.bounds.Extend(shape)
+	return l.rtree.Exec(g.conn.LastInsertRowID(), b.Min(0), b.Max(0), b.Min(1), b.Max(1))
+}
+
+func (g *gpkgSink) Record(e *Record) error {
+	l, err := g.layer(e.Table)
+	if err != nil {
+		return err
+	}
+	return g.feature(l, recordGeometry(e), e.Time, e.Data, e.Columns)
+}
+
+func (g *gpkgSink) Event(ev *Event) error {
+	if !g.lines || ev.Waypoints {
+		return nil
+	}
+	var flat []float64
+	for _, e := range ev.Records {
+		if e.Coords != nil {
+			flat = append(flat, e.Coords.Lon, e.Coords.Lat)
+		}
+	}
+	if len(flat) < 4 {
+		return nil
+	}
+	if g.events == nil {
+		var err error
+		if g.events, err = g.layer(eventsLayer); err != nil {
+			return err
+		}
+	}
+	s_time := ev.Records[0].Time
+	e_time := ev.Records[len(ev.Records)-1].Time
+	data := map[string]interface{}{
+		"SOURCE_FILE_PATH": ev.File,
+		"SOURCE_TABLE":     ev.Table,
+		"POINTS":           len(ev.Records),
+		"START_TIME":       s_time.UTC().Format(utcLayout),
+		"END_TIME":         e_time.UTC().Format(utcLayout),
+		"DISTANCE_M":       math.Round(ev.Dist*100) / 100,
+	}
+	columns := []string{"SOURCE_FILE_PATH", "SOURCE_TABLE", "POINTS", "START_TIME", "END_TIME", "DISTANCE_M"}
+	return g.feature(g.events, geom.NewLineStringFlat(geom.XY, flat), s_time, data, columns)
+}
+
+// Close sets the extent of every table, adds the triggers keeping the rtree
+// indexes up to date and commits the file
+func (g *gpkgSink) Close() error {
+	err := g.finish()
+	if err != nil {
+		g.conn.Rollback()
+	} else {
+		err = g.conn.Commit()
+	}
+	if e := g.conn.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+func (g *gpkgSink) finish() error {
+	// The names go inside the double quotes of the triggers
+	ident := func(name string) string { return strings.ReplaceAll(name, `"`, `""`) }
+	for _, l := range g.order {
+		if err := l.close(); err != nil {
+			return err
+		}
+		if err := l.rtree.Close(); err != nil {
+			return err
+		}
+		if l.bounds != nil {
+			b := l.bounds
+			if err := g.conn.Exec(`UPDATE gpkg_contents SET min_x = ?, min_y = ?, max_x = ?, max_y = ? WHERE table_name = ?`,
+				b.Min(0), b.Min(1), b.Max(0), b.Max(1), l.name); err != nil {
+				return err
+			}
+		}
+		triggers := strings.NewReplacer("%t", ident(l.name), "%c", ident("geom"), "%i", ident("fid")).Replace(gpkgRtreeTriggers)
+		if err := g.conn.Exec(triggers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/twpayne/go-kml"
+)
+
+func TestGPKGSinkQuotedTableName(t *testing.T) {
+	table := `o'k "x"`
+	path := filepath.Join(t.TempDir(), "o.gpkg")
+	writeTestGPKG(t, path, table,
+		kml.Coordinate{Lon: -77.0365, Lat: 38.8977},
+		kml.Coordinate{Lon: -77.0091, Lat: 38.8899})
+
+	conn, err := sqlite3.Open(path, sqlite3.OPEN_READONLY)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The table is in the contents with its extent and has its features
+	stmt, err := conn.Prepare(`SELECT min_x, max_y FROM gpkg_contents WHERE table_name = ?`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if ok, err := stmt.Step(); err != nil || !ok {
+		t.Fatalf("%q is not in gpkg_contents: %v", table, err)
+	}
+	var minX, maxY float64
+	if err = stmt.Scan(&minX, &maxY); err != nil {
+		t.Fatal(err)
+	}
+	if minX != -77.0365 || maxY != 38.8977 {
+		t.Errorf("extent starts %v and ends %v", minX, maxY)
+	}
+
+	count := func(query string, args ...interface{}) int {
+		t.Helper()
+		stmt, err := conn.Prepare(query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stmt.Close()
+		if _, err = stmt.Step(); err != nil {
+			t.Fatal(err)
+		}
+		n, _, err := stmt.ColumnInt(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(`SELECT COUNT(*) FROM ` + quoteName(table)); n != 2 {
+		t.Errorf("%d features, want 2", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM ` + quoteName("rtree_"+table+"_geom")); n != 2 {
+		t.Errorf("%d entries in the rtree, want 2", n)
+	}
+	// Each of the six triggers is on the feature table
+	if n := count(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND tbl_name = ?`, table); n != 6 {
+		t.Errorf("%d rtree triggers on the table, want 6", n)
+	}
+}
@@ -0,0 +1,124 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// geoPackage is what the contents of an OGC GeoPackage say about its tables,
+// see https://www.geopackage.org/spec/
+type geoPackage struct {
+	tables  []string          // feature and attribute tables, in the order of the file
+	columns map[string]string // geometry column of each feature table
+	srids   map[string]int    // SRS of each feature table
+	defs    map[int]string    // WKT definition of each SRS
+}
+
+// readGeoPackage reads the contents of a GeoPackage, nil when the file does
+// not have the GeoPackage tables
+func readGeoPackage(conn *sqlite3.Conn, tbl_names []string) (*geoPackage, error) {
+	if !contains(tbl_names, "gpkg_contents") || !contains(tbl_names, "gpkg_geometry_columns") {
+		return nil, nil
+	}
+	gp := &geoPackage{
+		columns: make(map[string]string),
+		srids:   make(map[string]int),
+		defs:    make(map[int]string),
+	}
+
+	listed := make(map[string]bool)
+	stmt, err := conn.Prepare(`SELECT c.table_name, c.data_type, g.column_name, g.srs_id
+		FROM gpkg_contents AS c LEFT JOIN gpkg_geometry_columns AS g ON c.table_name = g.table_name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select GeoPackage contents: %v", err)
+	}
+	defer stmt.Close()
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, fmt.Errorf("failed stepping through GeoPackage contents: %v", err)
+		}
+		if !hasRow {
+			break
+		}
+		var table, data_type, column string
+		var srid int
+		if err = stmt.Scan(&table, &data_type, &column, &srid); err != nil {
+			return nil, fmt.Errorf("failed scanning through GeoPackage contents: %v", err)
+		}
+		switch data_type {
+		case "features":
+			if column == "" {
+				continue
+			}
+			gp.columns[table] = column
+			gp.srids[table] = srid
+		case "attributes":
+		default:
+			// Tiles and other kinds of data have no rows to read
+			continue
+		}
+		listed[table] = true
+	}
+	for _, t := range tbl_names {
+		if listed[t] {
+			gp.tables = append(gp.tables, t)
+		}
+	}
+
+	if !contains(tbl_names, "gpkg_spatial_ref_sys") {
+		return gp, nil
+	}
+	srs, err := conn.Prepare(`SELECT srs_id, definition FROM gpkg_spatial_ref_sys`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select GeoPackage spatial reference systems: %v", err)
+	}
+	defer srs.Close()
+	for {
+		hasRow, err := srs.Step()
+		if err != nil {
+			return nil, fmt.Errorf("failed stepping through GeoPackage spatial reference systems: %v", err)
+		}
+		if !hasRow {
+			break
+		}
+		var srid int
+		var def string
+		if err = srs.Scan(&srid, &def); err != nil {
+			return nil, fmt.Errorf("failed scanning through GeoPackage spatial reference systems: %v", err)
+		}
+		gp.defs[srid] = def
+	}
+	return gp, nil
+}
+
+// projector returns the projector for the SRS of the file, with only the
+// common EPSG codes known when the file is not a GeoPackage
+func (gp *geoPackage) projector() *projector {
+	if gp == nil {
+		return newProjector(nil)
+	}
+	return newProjector(gp.defs)
+}
+
+// column returns the geometry column and SRS of a feature table, an empty
+// column when the table has none or the file is not a GeoPackage
+func (gp *geoPackage) column(table string) (string, int) {
+	if gp == nil {
+		return "", 0
+	}
+	return gp.columns[table], gp.srids[table]
+}
@@ -0,0 +1,94 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-kml"
+)
+
+// recordSink keeps the records handed to it
+type recordSink struct {
+	NopSink
+	records []*Record
+}
+
+func (r *recordSink) Record(e *Record) error {
+	r.records = append(r.records, e)
+	return nil
+}
+
+// writeTestGPKG writes a GeoPackage with a point a minute for each of the
+// positions into a feature table named after the source table
+func writeTestGPKG(t *testing.T, path, table string, positions ...kml.Coordinate) {
+	t.Helper()
+	out, err := NewGPKGSink(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2022, 7, 20, 8, 54, 21, 0, time.UTC)
+	for i := range positions {
+		e := &Record{
+			Coords: &positions[i],
+			Time:   start.Add(time.Duration(i) * time.Minute),
+			Data: map[string]interface{}{
+				"SOURCE_FILE_PATH": "in.db",
+				"SOURCE_TABLE":     table,
+				"NAME":             "place",
+			},
+			Columns: []string{"SOURCE_FILE_PATH", "SOURCE_TABLE", "NAME"},
+			File:    "in.db",
+			Table:   table,
+			Row:     i,
+		}
+		if err = out.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = out.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadGeoPackageLayerNames(t *testing.T) {
+	for _, table := range []string{"my-layer", "my layer", "select"} {
+		path := filepath.Join(t.TempDir(), "in.gpkg")
+		writeTestGPKG(t, path, table,
+			kml.Coordinate{Lon: -77.0365, Lat: 38.8977},
+			kml.Coordinate{Lon: -77.0091, Lat: 38.8899})
+
+		out := &recordSink{}
+		if err := (&Source{}).ReadFile(path, out); err != nil {
+			t.Errorf("layer %q: %v", table, err)
+			continue
+		}
+		if len(out.records) != 2 {
+			t.Errorf("layer %q: %d records read, want 2", table, len(out.records))
+			continue
+		}
+		for i, e := range out.records {
+			if e.Table != table {
+				t.Errorf("layer %q: record %d is from %q", table, i, e.Table)
+			}
+			if e.Geometry == nil || e.Coords == nil {
+				t.Errorf("layer %q: record %d has no position", table, i)
+			}
+		}
+		if e := out.records[1]; e.Coords != nil && e.Coords.Lon != -77.0091 {
+			t.Errorf("layer %q: records out of time order", table)
+		}
+	}
+}
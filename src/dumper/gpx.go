@@ -0,0 +1,247 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"encoding/gob"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/twpayne/go-geom"
+)
+
+// GPX 1.1 schema reference: https://www.topografix.com/GPX/1/1/
+
+type gpxPoint struct {
+	Lat  float64  `xml:"lat,attr"`
+	Lon  float64  `xml:"lon,attr"`
+	Ele  *float64 `xml:"ele,omitempty"`
+	Time string   `xml:"time,omitempty"`
+	Name string   `xml:"name,omitempty"`
+	Desc string   `xml:"desc,omitempty"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name,omitempty"`
+	Desc     string       `xml:"desc,omitempty"`
+	Src      string       `xml:"src,omitempty"`
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name,omitempty"`
+	Desc string `xml:"desc,omitempty"`
+	Time string `xml:"time,omitempty"`
+}
+
+// gpxPointFromEntry converts an entry into a GPX point, time and elevation are
+// only set when they are known
+func gpxPointFromEntry(e *Record) gpxPoint {
+	p := gpxPoint{
+		Lat: e.Coords.Lat,
+		Lon: e.Coords.Lon,
+	}
+	if e.Coords.Alt != 0 {
+		alt := e.Coords.Alt
+		p.Ele = &alt
+	}
+	if !e.Time.IsZero() {
+		p.Time = e.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return p
+}
+
+// gpxSegments returns a segment for each line of a geometry, none when it has
+// no lines
+func gpxSegments(g geom.T) []gpxSegment {
+	var lines []*geom.LineString
+	switch l := g.(type) {
+	case *geom.LineString:
+		lines = append(lines, l)
+	case *geom.MultiLineString:
+		for i := 0; i < l.NumLineStrings(); i++ {
+			lines = append(lines, l.LineString(i))
+		}
+	}
+	var segs []gpxSegment
+	for _, l := range lines {
+		var seg gpxSegment
+		z := l.Layout().ZIndex()
+		for _, c := range l.Coords() {
+			p := gpxPoint{Lat: c.Y(), Lon: c.X()}
+			if z >= 0 {
+				alt := c[z]
+				p.Ele = &alt
+			}
+			seg.Points = append(seg.Points, p)
+		}
+		if len(seg.Points) > 0 {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// gpxTrackFromEvent converts an event into a track with one segment, false if
+// none of the entries have a position
+func gpxTrackFromEvent(ev *Event) (gpxTrack, bool) {
+	var seg gpxSegment
+	for _, e := range ev.Records {
+		if e.Coords != nil {
+			seg.Points = append(seg.Points, gpxPointFromEntry(e))
+		}
+	}
+	if len(seg.Points) == 0 {
+		return gpxTrack{}, false
+	}
+	s_time := ev.Records[0].Time
+	e_time := ev.Records[len(ev.Records)-1].Time
+	trk := gpxTrack{
+		Name:     fmt.Sprintf("Event (%d) %s", len(ev.Records), s_time.Format(time.RFC3339Nano)),
+		Src:      ev.File,
+		Desc:     "Table " + ev.Table,
+		Segments: []gpxSegment{seg},
+	}
+	if e_time.Sub(s_time) > 0 {
+		trk.Name = fmt.Sprintf("Event (%d) %s - %s", len(ev.Records), s_time.Format(time.RFC3339Nano), e_time.Format(time.RFC3339Nano))
+	}
+	return trk, true
+}
+
+// gpxSink writes out a GPX 1.1 file with one track per event and a waypoint
+// for each entry of a waypoint table, such as the locations of interest, or a
+// track for each entry with a line geometry.  GPX puts the waypoints before
+// the tracks, so the tracks are spooled to a temporary file until the end.
+type gpxSink struct {
+	NopSink
+	w         io.Writer
+	name      string
+	version   string
+	waypoints []gpxPoint
+	tracks    *spool
+	tw        *bufio.Writer
+	enc       *gob.Encoder
+}
+
+// NewGPXSink returns a sink writing GPX to w, version is the version of the
+// program put in the creator
+func NewGPXSink(w io.Writer, name, version string) (Sink, error) {
+	tracks, err := newSpool()
+	if err != nil {
+		return nil, err
+	}
+	tw := bufio.NewWriter(tracks)
+	return &gpxSink{w: w, name: name, version: version, tracks: tracks, tw: tw, enc: gob.NewEncoder(tw)}, nil
+}
+
+func (g *gpxSink) Record(e *Record) error {
+	if e.Coords == nil {
+		return nil
+	}
+	if !e.Waypoint {
+		return nil
+	}
+	name := e.Time.Format(time.RFC3339Nano)
+	if v, ok := e.Data["Z_PK"]; ok {
+		name = fmt.Sprintf("%v", v)
+	}
+	if e.Label != "" {
+		name = e.Label
+	}
+	desc := fmt.Sprintf("%s: %s", e.Data["SOURCE_FILE_PATH"], e.Data["SOURCE_TABLE"])
+	if segs := gpxSegments(e.Geometry); len(segs) > 0 {
+		return g.enc.Encode(gpxTrack{Name: name, Desc: desc, Src: e.File, Segments: segs})
+	}
+	p := gpxPointFromEntry(e)
+	p.Name = name
+	p.Desc = desc
+	g.waypoints = append(g.waypoints, p)
+	return nil
+}
+
+func (g *gpxSink) Event(ev *Event) error {
+	if ev.Waypoints {
+		return nil
+	}
+	if trk, ok := gpxTrackFromEvent(ev); ok {
+		return g.enc.Encode(trk)
+	}
+	return nil
+}
+
+func (g *gpxSink) Close() error {
+	defer g.tracks.close()
+	if err := g.tw.Flush(); err != nil {
+		return err
+	}
+	if _, err := g.tracks.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(g.w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(g.w)
+	enc.Indent("", "  ")
+	start := xml.StartElement{
+		Name: xml.Name{Local: "gpx"},
+		Attr: []xml.Attr{
+			{Name: xml.Name{Local: "xmlns"}, Value: "http://www.topografix.com/GPX/1/1"},
+			{Name: xml.Name{Local: "version"}, Value: "1.1"},
+			{Name: xml.Name{Local: "creator"}, Value: "geo-sqlite-dumper " + g.version},
+		},
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	meta := gpxMetadata{
+		Name: g.name,
+		Desc: "Built using geo-sqlite-dumper, https://github.com/pschou/geo-sqlite-dumper",
+		Time: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := enc.EncodeElement(meta, xml.StartElement{Name: xml.Name{Local: "metadata"}}); err != nil {
+		return err
+	}
+	for _, p := range g.waypoints {
+		if err := enc.EncodeElement(p, xml.StartElement{Name: xml.Name{Local: "wpt"}}); err != nil {
+			return err
+		}
+	}
+	dec := gob.NewDecoder(bufio.NewReader(g.tracks))
+	for {
+		var trk gpxTrack
+		if err := dec.Decode(&trk); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if err := enc.EncodeElement(trk, xml.StartElement{Name: xml.Name{Local: "trk"}}); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeToken(start.End()); err != nil {
+		return err
+	}
+	if err := enc.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(g.w, "\n")
+	return err
+}
@@ -0,0 +1,551 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// FileReport is what inspecting a file found, the tables and which of their
+// columns will be read
+type FileReport struct {
+	File     string         `json:"file"`
+	Profiles []string       `json:"profiles,omitempty"` // profiles recognized by fingerprint
+	Tables   []*TableReport `json:"tables"`
+}
+
+// TableReport is one table of a file, with the roles picked for its columns
+// and, when it is read, the extent of the points found
+type TableReport struct {
+	Name    string        `json:"name"`
+	Columns []string      `json:"columns"`
+	Rows    int           `json:"rows"`
+	Read    bool          `json:"read"`           // the table will be read
+	Note    string        `json:"note,omitempty"` // why the table is not read
+	Profile string        `json:"profile,omitempty"`
+	Joined  string        `json:"joined,omitempty"`
+	Roles   []*RoleReport `json:"roles,omitempty"`
+
+	Points  int        `json:"points"` // rows with a position
+	Events  int        `json:"events"`
+	MinTime *time.Time `json:"min_time,omitempty"`
+	MaxTime *time.Time `json:"max_time,omitempty"`
+	Bounds  *Bounds    `json:"bounds,omitempty"`
+}
+
+// RoleReport is a column which holds, or may hold, a role.  The confidence
+// is how well the name fits the role times the share of sampled values which
+// are sensible for it.
+type RoleReport struct {
+	Role       string  `json:"role"`
+	Column     string  `json:"column"`
+	Chosen     bool    `json:"chosen"` // the column is read for the role
+	Confidence float64 `json:"confidence"`
+	Epoch      string  `json:"epoch,omitempty"`
+	Note       string  `json:"note,omitempty"`
+}
+
+// Bounds is the box around a set of points
+type Bounds struct {
+	MinLat float64 `json:"min_lat"`
+	MinLon float64 `json:"min_lon"`
+	MaxLat float64 `json:"max_lat"`
+	MaxLon float64 `json:"max_lon"`
+}
+
+// inspectSamples is how many values of a column are checked
+const inspectSamples = 200
+
+// Inspect reports the tables of a file, the columns which will be read for
+// the position and time, and the extent of the points found when reading it
+// the same way as ReadFile
+func (s *Source) Inspect(f string) (*FileReport, error) {
+	if err := checkHeader(f); err != nil {
+		return nil, err
+	}
+	conn, err := s.open(f)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	rep := &FileReport{File: f}
+	byName := make(map[string]*TableReport)
+	if s.Query == "" {
+		tbl_names, err := getTables(conn)
+		if err != nil {
+			return nil, err
+		}
+		schema, err := getSchema(conn, tbl_names)
+		if err != nil {
+			return nil, err
+		}
+		gp, err := readGeoPackage(conn, tbl_names)
+		if err != nil {
+			return nil, err
+		}
+		profiles := s.Profiles.ForSchema(schema)
+		for _, p := range profiles {
+			if len(p.Fingerprint) > 0 {
+				rep.Profiles = append(rep.Profiles, p.Name)
+			}
+		}
+		for _, tbl_name := range tbl_names {
+			t, err := s.inspectTable(conn, tbl_name, schema, profiles, gp)
+			if err != nil {
+				return nil, err
+			}
+			rep.Tables = append(rep.Tables, t)
+			byName[tbl_name] = t
+		}
+	} else {
+		t := &TableReport{Name: "", Read: true}
+		rep.Tables = append(rep.Tables, t)
+		byName[""] = t
+	}
+
+	// Read the file to find what is picked up from each table
+	if err = s.ReadFile(f, &inspectSink{tables: byName}); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// inspectTable finds the roles of the columns of one table
+func (s *Source) inspectTable(conn *sqlite3.Conn, tbl_name string, schema map[string][]string, profiles Profiles, gp *geoPackage) (*TableReport, error) {
+	t := &TableReport{Name: tbl_name, Columns: schema[tbl_name]}
+	stmt, err := conn.Prepare(`SELECT COUNT(*) FROM ` + quoteName(tbl_name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to count rows of %s: %v", tbl_name, err)
+	}
+	if _, err = stmt.Step(); err == nil {
+		err = stmt.Scan(&t.Rows)
+	}
+	stmt.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count rows of %s: %v", tbl_name, err)
+	}
+
+	if gp != nil && !contains(gp.tables, tbl_name) {
+		t.Note = "not in the GeoPackage contents"
+		return t, nil
+	}
+
+	pr, tp := profiles.Match(tbl_name, schema)
+	if tp != nil {
+		t.Profile = pr.Name
+		if tp.Skip {
+			t.Note = "skipped by the profile"
+			return t, nil
+		}
+		t.Joined = tp.joined(schema)
+	}
+
+	// The columns as they are read, with the joined table after the table
+	clm_names := append([]string{}, schema[tbl_name]...)
+	from := make([]string, len(clm_names))
+	for i := range from {
+		from[i] = tbl_name
+	}
+	for _, c := range schema[t.Joined] {
+		clm_names = append(clm_names, c)
+		from = append(from, t.Joined)
+	}
+
+	rl := s.findRoles(tbl_name, clm_names, tp)
+	decl_geom := false
+	if column, _ := gp.column(tbl_name); tp == nil && column != "" {
+		rl.geom = findColumn(clm_names, column)
+		decl_geom = rl.geom >= 0
+	} else if rl.geom < 0 && tp == nil {
+		if rl.geom, err = declaredGeometry(conn, tbl_name); err != nil {
+			return nil, err
+		}
+		decl_geom = rl.geom >= 0
+	}
+	if tp == nil && !decl_geom && rl.geom >= 0 {
+		// A column only named like a geometry is one once a value decodes
+		if ok, err := decodesGeometry(conn, quoteName(from[rl.geom]), clm_names[rl.geom]); err == nil && !ok {
+			rl.geom = -1
+		}
+	}
+	chosen := map[string]int{"lat": rl.lat, "lon": rl.lon, "alt": rl.alt, "time": rl.date,
+		"accuracy": rl.accuracy, "speed": rl.speed, "course": rl.course, "label": rl.label,
+		"geometry": rl.geom}
+	for _, role := range []string{"lat", "lon", "alt", "time", "accuracy", "speed", "course", "label", "geometry"} {
+		i := chosen[role]
+		if i < 0 {
+			continue
+		}
+		name := nameScore(role, clm_names[i])
+		if role == "geometry" && decl_geom {
+			name = 0.9
+		}
+		if tp != nil && profileColumn(tp, role).find(clm_names) == i {
+			name = 1
+		}
+		r, err := s.scoreColumn(conn, from[i], tbl_name, clm_names[i], role, name, tp)
+		if err != nil {
+			return nil, err
+		}
+		r.Chosen = true
+		t.Roles = append(t.Roles, r)
+	}
+
+	// Other columns which look like a position or time
+	for i, c := range clm_names {
+		for _, role := range []string{"lat", "lon", "alt", "time", "geometry"} {
+			if chosen[role] == i {
+				continue
+			}
+			if name := nameScore(role, c); name > 0 {
+				r, err := s.scoreColumn(conn, from[i], tbl_name, c, role, name, nil)
+				if err != nil {
+					return nil, err
+				}
+				t.Roles = append(t.Roles, r)
+			}
+		}
+	}
+
+	t.Read = (rl.lat >= 0 && rl.lon >= 0) || rl.geom >= 0 || rl.proto >= 0
+	if !t.Read {
+		t.Note = "no latitude and longitude or geometry columns"
+	}
+	return t, nil
+}
+
+// declaredGeometry returns the first column of the table declared with a
+// geometry type, or -1
+func declaredGeometry(conn *sqlite3.Conn, tbl_name string) (int, error) {
+	stmt, err := conn.Prepare(`SELECT * FROM ` + quoteName(tbl_name))
+	if err != nil {
+		return -1, fmt.Errorf("failed to select data from table: %v", err)
+	}
+	defer stmt.Close()
+	return geometryColumn(stmt.ColumnNames(), stmt.DeclTypes()), nil
+}
+
+// profileColumn returns the columns a profile gives for a role
+func profileColumn(tp *TableProfile, role string) ColumnRef {
+	switch role {
+	case "lat":
+		return tp.Lat
+	case "lon":
+		return tp.Lon
+	case "alt":
+		return tp.Alt
+	case "time":
+		return tp.Time
+	case "accuracy":
+		return tp.Accuracy
+	case "speed":
+		return tp.Speed
+	case "course":
+		return tp.Course
+	case "geometry":
+		return tp.Geometry
+	}
+	return tp.Label
+}
+
+// nameScore is how well a column name fits a role, 0 when it does not
+func nameScore(role, name string) float64 {
+	lcol := strings.ToLower(name)
+	token := func(tokens ...string) bool {
+		for _, t := range tokens {
+			if lcol == t || strings.HasSuffix(lcol, "_"+t) || strings.HasPrefix(lcol, t+"_") {
+				return true
+			}
+		}
+		return false
+	}
+	switch role {
+	case "lat":
+		switch {
+		case strings.HasSuffix(lcol, "latitude"):
+			return 0.9
+		case strings.Contains(lcol, "latitude"):
+			return 0.7
+		case token("lat") || lcol == "late7":
+			return 0.6
+		case lcol == "y":
+			return 0.3
+		}
+	case "lon":
+		switch {
+		case strings.HasSuffix(lcol, "longitude"):
+			return 0.9
+		case strings.Contains(lcol, "longitude"):
+			return 0.7
+		case token("lon", "lng", "long") || lcol == "lnge7" || lcol == "lone7":
+			return 0.6
+		case lcol == "x":
+			return 0.3
+		}
+	case "alt":
+		switch {
+		case strings.HasSuffix(lcol, "altitude"):
+			return 0.9
+		case strings.Contains(lcol, "altitude") || strings.Contains(lcol, "elevation"):
+			return 0.7
+		case token("alt", "ele", "elev"):
+			return 0.5
+		case lcol == "z":
+			return 0.3
+		}
+	case "time":
+		switch {
+		case IsDateColumn(name):
+			return 0.8
+		case strings.Contains(lcol, "time") || strings.Contains(lcol, "date"):
+			return 0.6
+		case token("ts"):
+			return 0.5
+		}
+	case "geometry":
+		if contains(geometryNames, lcol) {
+			return 0.8
+		}
+	case "accuracy", "speed", "course", "label":
+		return 0.5
+	}
+	return 0
+}
+
+// scoreColumn samples the values of a column to see how well they fit the
+// role, with the units of the profile applied
+func (s *Source) scoreColumn(conn *sqlite3.Conn, table, tbl_name, column, role string, name float64, tp *TableProfile) (*RoleReport, error) {
+	r := &RoleReport{Role: role, Column: column, Confidence: name}
+	if table != tbl_name {
+		r.Column = table + "." + column
+	}
+	if role == "label" {
+		return r, nil
+	}
+	stmt, err := conn.Prepare(`SELECT `+quoteName(column)+` FROM `+quoteName(table)+
+		` WHERE `+quoteName(column)+` IS NOT NULL LIMIT ?`, inspectSamples)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sample %s.%s: %v", table, column, err)
+	}
+	defer stmt.Close()
+
+	epoch, named := s.Epochs.lookup(tbl_name, column)
+	if tp != nil && !named && tp.Epoch != "" && role == "time" {
+		epoch = tp.Epoch
+	}
+	scale := 1.0
+	if tp != nil {
+		scale = tp.scale(role)
+	}
+
+	var n, good, e7, e6 int
+	epochs := make(map[string]int)
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, fmt.Errorf("failed to sample %s.%s: %v", table, column, err)
+		}
+		if !hasRow {
+			break
+		}
+		var v interface{}
+		if err = stmt.Scan(&v); err != nil {
+			return nil, fmt.Errorf("failed to sample %s.%s: %v", table, column, err)
+		}
+		n++
+		if role == "geometry" {
+			g, _, err := DecodeGeometry(v)
+			if err == nil && g == nil {
+				// Empty geometries are not counted
+				n--
+			} else if err == nil {
+				good++
+			}
+			continue
+		}
+		if role == "time" {
+			if t, e, ok := ParseTime(v, epoch); ok && t.Unix() >= detectMin && t.Unix() < detectMax {
+				good++
+				epochs[e]++
+			}
+			continue
+		}
+		f, ok := toFloat(v)
+		if !ok {
+			continue
+		}
+		if tp != nil && (role == "lat" || role == "lon") && tp.noPosition(f, f) {
+			// Stand ins for a missing position are not counted
+			n--
+			continue
+		}
+		if fitsRole(role, f*scale) {
+			good++
+		} else if role == "lat" || role == "lon" {
+			if fitsRole(role, f*1e-7) {
+				e7++
+			} else if fitsRole(role, f*1e-6) {
+				e6++
+			}
+		}
+	}
+
+	if n == 0 {
+		r.Note = "no values"
+		return r, nil
+	}
+	r.Confidence = math.Round(name*float64(good)/float64(n)*100) / 100
+	if good < n {
+		r.Note = fmt.Sprintf("%d of %d values fit", good, n)
+	}
+	switch {
+	case e7 > n/2:
+		r.Note = "values fit e7 units"
+	case e6 > n/2:
+		r.Note = "values fit e6 units"
+	}
+	most := 0
+	for e, c := range epochs {
+		if c > most || (c == most && e < r.Epoch) {
+			r.Epoch, most = e, c
+		}
+	}
+	return r, nil
+}
+
+// fitsRole returns true if the value is in the range of the role
+func fitsRole(role string, v float64) bool {
+	switch role {
+	case "lat":
+		return v >= -90 && v <= 90
+	case "lon":
+		return v >= -180 && v <= 180
+	case "alt":
+		return v > -1000 && v < 100000
+	case "accuracy":
+		return v >= 0 && v < 1e6
+	case "speed":
+		return v >= 0 && v < 1000
+	case "course":
+		return v >= 0 && v <= 360
+	}
+	return true
+}
+
+// inspectSink gathers the extent of the records read from each table
+type inspectSink struct {
+	NopSink
+	tables map[string]*TableReport
+}
+
+// add adds a record to the extent of its table
+func (s *inspectSink) add(e *Record) {
+	t, ok := s.tables[e.Table]
+	if !ok || e.Coords == nil {
+		return
+	}
+	t.Points++
+	c := e.Coords
+	if t.Bounds == nil {
+		t.Bounds = &Bounds{c.Lat, c.Lon, c.Lat, c.Lon}
+	}
+	t.Bounds.MinLat = math.Min(t.Bounds.MinLat, c.Lat)
+	t.Bounds.MinLon = math.Min(t.Bounds.MinLon, c.Lon)
+	t.Bounds.MaxLat = math.Max(t.Bounds.MaxLat, c.Lat)
+	t.Bounds.MaxLon = math.Max(t.Bounds.MaxLon, c.Lon)
+	if e.Time.IsZero() {
+		return
+	}
+	if t.MinTime == nil || e.Time.Before(*t.MinTime) {
+		tm := e.Time
+		t.MinTime = &tm
+	}
+	if t.MaxTime == nil || e.Time.After(*t.MaxTime) {
+		tm := e.Time
+		t.MaxTime = &tm
+	}
+}
+
+// Event adds the records of the event, every row read is in one event
+func (s *inspectSink) Event(ev *Event) error {
+	if t, ok := s.tables[ev.Table]; ok {
+		t.Events++
+		for _, e := range ev.Records {
+			s.add(e)
+		}
+	}
+	return nil
+}
+
+// WriteReports writes the reports as a table for the terminal
+func WriteReports(w io.Writer, reports []*FileReport) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	for _, rep := range reports {
+		fmt.Fprintf(tw, "%s\n", rep.File)
+		if len(rep.Profiles) > 0 {
+			fmt.Fprintf(tw, "  recognized as %s\n", strings.Join(rep.Profiles, ", "))
+		}
+		for _, t := range rep.Tables {
+			name := t.Name
+			if name == "" {
+				name = "(query)"
+			}
+			fmt.Fprintf(tw, "\n  %s, %d rows", name, t.Rows)
+			if t.Profile != "" {
+				fmt.Fprintf(tw, ", profile %s", t.Profile)
+			}
+			if t.Joined != "" {
+				fmt.Fprintf(tw, ", joined with %s", t.Joined)
+			}
+			if !t.Read {
+				fmt.Fprintf(tw, ", not read: %s\n", t.Note)
+			} else {
+				fmt.Fprintf(tw, ", %d points in %d events\n", t.Points, t.Events)
+			}
+			if t.MinTime != nil {
+				fmt.Fprintf(tw, "    time\t%s to %s\n", t.MinTime.Format(time.RFC3339), t.MaxTime.Format(time.RFC3339))
+			}
+			if t.Bounds != nil {
+				fmt.Fprintf(tw, "    bounds\t%f,%f to %f,%f\n", t.Bounds.MinLat, t.Bounds.MinLon, t.Bounds.MaxLat, t.Bounds.MaxLon)
+			}
+			roles := append([]*RoleReport{}, t.Roles...)
+			sort.SliceStable(roles, func(i, j int) bool { return roles[i].Chosen && !roles[j].Chosen })
+			for _, r := range roles {
+				use := "read"
+				if !r.Chosen {
+					use = "candidate"
+				}
+				note := r.Note
+				if r.Epoch != "" {
+					note = strings.TrimSpace(r.Epoch + " " + note)
+				}
+				if note == "" {
+					fmt.Fprintf(tw, "    %s\t%s\t%s\t%.2f\n", r.Role, r.Column, use, r.Confidence)
+				} else {
+					fmt.Fprintf(tw, "    %s\t%s\t%s\t%.2f\t%s\n", r.Role, r.Column, use, r.Confidence, note)
+				}
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
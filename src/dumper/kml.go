@@ -0,0 +1,463 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kmlgeom "github.com/twpayne/go-geom/encoding/kml"
+	"github.com/twpayne/go-kml"
+)
+
+// KMLBuilder turns records and events into KML elements, one builder is
+// shared by all the KML outputs so their styles and schemas agree
+type KMLBuilder struct {
+	styles  kmlStyles
+	schemas kmlSchemas
+	rules   StyleRules
+	lines   bool // draw a path for every event
+	gx      bool // draw every event as a gx:Track
+	balloon bool // add an HTML table of the columns to every placemark
+}
+
+// NewKMLBuilder returns a builder which applies the style rules, lines draws
+// a path for every event, gx draws every event as a gx:Track and balloon adds
+// an HTML table of the columns to every placemark
+func NewKMLBuilder(rules StyleRules, lines, gx, balloon bool) *KMLBuilder {
+	return &KMLBuilder{rules: rules, lines: lines, gx: gx, balloon: balloon}
+}
+
+// entryTitle is the placemark name for an entry, the profile label or Z_PK
+// when there is one, with the local time when it was looked up, or else the
+// time
+func entryTitle(e *Record) string {
+	name := e.Label
+	if v, ok := e.Data["Z_PK"]; ok && name == "" {
+		name = fmt.Sprintf("%v", v)
+	}
+	switch {
+	case !e.Local.IsZero() && name != "":
+		return name + " " + e.Local.Format("2006-01-02 15:04:05 MST")
+	case !e.Local.IsZero():
+		return e.Local.Format("2006-01-02 15:04:05 MST")
+	case name != "":
+		return name
+	}
+	return e.Time.Format(time.RFC3339Nano)
+}
+
+// entrySource returns the file and table an entry was read from
+func entrySource(e *Record) (file, table string) {
+	file, _ = e.Data["SOURCE_FILE_PATH"].(string)
+	table, _ = e.Data["SOURCE_TABLE"].(string)
+	return
+}
+
+// observe adds the columns of the entry to the schema of its table, the
+// source is already known from the schema
+func (b *KMLBuilder) observe(e *Record) *kmlSchema {
+	file, table := entrySource(e)
+	schema := b.schemas.get(file, table)
+	for _, c := range e.Columns {
+		if c == "SOURCE_FILE_PATH" || c == "SOURCE_TABLE" {
+			continue
+		}
+		schema.observe(c, e.Data[c])
+	}
+	return schema
+}
+
+// balloonHTML builds the HTML table of the columns of an entry, note is shown
+// above the table
+func (b *KMLBuilder) balloonHTML(e *Record, note string) string {
+	desc := fmt.Sprintf("<table>\n<tr><th>i</th><td>%d</td></tr>\n", e.Row)
+	if note != "" {
+		desc = "<p>" + html.EscapeString(note) + "</p>\n" + desc
+	}
+	for _, c := range e.Columns {
+		if c == "SOURCE_FILE_PATH" || c == "SOURCE_TABLE" {
+			continue
+		}
+		desc += "<tr><th>" + html.EscapeString(c) + "</th><td>" +
+			html.EscapeString(fmt.Sprintf("%v", e.Data[c])) + "</td></tr>\n"
+	}
+	return desc + "</table>"
+}
+
+// placemark builds the point, or the shape of a geometry, for an entry, when
+// is false for points which should be left out of the time slider
+func (b *KMLBuilder) placemark(e *Record, title, note string, when bool) kml.Element {
+	file, table := entrySource(e)
+	schema := b.observe(e)
+	p := kml.Placemark(
+		kml.Name(title),
+	)
+	if b.balloon {
+		p.Add(kml.Description(b.balloonHTML(e, note)))
+	}
+	if when && !e.Time.IsZero() {
+		p.Add(kml.TimeStamp(kml.When(e.Time)))
+	}
+	p.Add(kml.StyleURL(b.styles.url(file, table)))
+	if style := b.rules.kmlPointStyle(e); style != nil {
+		p.Add(style)
+	}
+	p.Add(schema.extendedData(e.Data))
+	if e.Geometry != nil {
+		if g, err := kmlgeom.Encode(e.Geometry); err == nil {
+			return p.Add(g)
+		}
+	}
+	return p.Add(kml.Point(kml.Coordinates(*e.Coords)))
+}
+
+// eventNode builds the folder for an event with its path or track and its
+// points
+func (b *KMLBuilder) eventNode(ev *Event) *kmlNode {
+	entries := ev.Records
+	// Every row is in the schema, even the ones without a point
+	for _, e := range entries {
+		b.observe(e)
+	}
+	total_pts := float64(len(entries))
+	s_time := entries[0].Time
+	e_time := entries[len(entries)-1].Time
+	poi := ev.Waypoints
+	note := ""
+	if ev.Joined != "" {
+		note = "Table " + ev.Table + " left joined with " + ev.Joined + "\n"
+	}
+
+	var elements []kml.Element
+	altMode := kml.AltitudeModeAbsolute
+	if ev.Alt == 0 {
+		altMode = kml.AltitudeModeClampToGround
+	}
+	switch {
+	// Create a gx:Track in place of the points and path when requested
+	case b.gx && !poi:
+		var whens, gxcoords []kml.Element
+		for _, entry := range entries {
+			if entry.Coords != nil && !entry.Time.IsZero() {
+				whens = append(whens, kml.When(entry.Time))
+				gxcoords = append(gxcoords, kml.GxCoord(*entry.Coords))
+			}
+		}
+		if len(whens) > 0 {
+			elements = append(elements,
+				kml.Placemark(
+					kml.Name("Track"),
+					kml.StyleURL(b.styles.url(ev.File, ev.Table)),
+					kml.GxTrack(
+						append(append([]kml.Element{
+							kml.AltitudeMode(altMode),
+						},
+							whens...),
+							gxcoords...,
+						)...,
+					),
+				),
+			)
+		}
+	// Create a path of individually styled segments
+	case b.lines && len(entries) > 1 && !poi && b.rules.hasLine():
+		var segments []kml.Element
+		var prev *Record
+		for _, entry := range entries {
+			if entry.Coords == nil {
+				continue
+			}
+			if prev != nil {
+				segment := kml.Placemark(
+					kml.StyleURL(b.styles.url(ev.File, ev.Table)),
+				)
+				if style := b.rules.kmlSegmentStyle(prev, entry); style != nil {
+					segment.Add(style)
+				}
+				segment.Add(kml.LineString(
+					kml.Extrude(true),
+					kml.Tessellate(true),
+					kml.AltitudeMode(altMode),
+					kml.Coordinates(*prev.Coords, *entry.Coords)))
+				segments = append(segments, segment)
+			}
+			prev = entry
+		}
+		elements = append(elements, kml.Folder(
+			append([]kml.Element{
+				kml.Name("Path"),
+			},
+				segments...,
+			)...,
+		))
+	// Create a path if more than one point is specified
+	case b.lines && len(entries) > 1 && !poi:
+		if path := coords(entries); len(path) > 1 {
+			elements = append(elements,
+				kml.Placemark(
+					kml.Name("Path"),
+					kml.StyleURL(b.styles.url(ev.File, ev.Table)),
+					kml.LineString(
+						kml.Extrude(true),
+						kml.Tessellate(true),
+						kml.AltitudeMode(altMode),
+						kml.Coordinates(path...)),
+				),
+			)
+		}
+	}
+
+	var pointElements []kml.Element
+	points := 0
+	for _, entry := range entries {
+		if entry.Coords != nil {
+			points++
+		}
+	}
+	// With a track the points are already represented in it
+	if !(b.gx && len(elements) > 0) {
+		for _, entry := range entries {
+			if entry.Coords != nil {
+				pointElements = append(pointElements, b.placemark(entry, entryTitle(entry), note, true))
+			}
+		}
+	}
+	if len(pointElements) > 0 {
+		elements = append(elements, kml.Folder(
+			append([]kml.Element{
+				kml.Name("Points"),
+			},
+				pointElements...,
+			)...,
+		))
+	}
+
+	details := []kml.Element{}
+
+	if e_time.Sub(s_time) > 0 {
+		details = append(details,
+			kml.Name(fmt.Sprintf("Event (%d) %s - %s", len(entries),
+				entries[0].localTime().Format(time.RFC3339Nano), entries[len(entries)-1].localTime().Format(time.RFC3339Nano))),
+		)
+	} else {
+		details = append(details,
+			kml.Name(fmt.Sprintf("Event (%d) %s", len(entries), entries[0].localTime().Format(time.RFC3339Nano))))
+	}
+
+	if len(entries) > 1 {
+		details = append(details,
+			kml.Description(fmt.Sprintf("{time: %s, dist: %fm, mean altitude: %fm}", e_time.Sub(s_time), ev.Dist, ev.Alt/total_pts)),
+		)
+	}
+
+	if !s_time.IsZero() {
+		details = append(details,
+			kml.TimeSpan(kml.Begin(s_time), kml.End(e_time)),
+		)
+	}
+
+	return &kmlNode{
+		details:  details,
+		features: elements,
+		points:   points,
+	}
+}
+
+// tableDetails is the folder details for a table
+func tableDetails(table string, rows int) []kml.Element {
+	return []kml.Element{
+		kml.Name(fmt.Sprintf("%s (%d)", table, rows)),
+		kml.Open(false),
+	}
+}
+
+// fileDetails is the folder details for a file
+func fileDetails(file string) []kml.Element {
+	return []kml.Element{
+		kml.Name(file),
+		kml.Open(false),
+	}
+}
+
+// kmlSink writes a KML file as the events come in.  The folders are spooled
+// to temporary files, the events of a table until its row count is known for
+// the folder name, and the document body until the styles and schemas, which
+// have to come first, are all known.
+type kmlSink struct {
+	NopSink
+	w      io.Writer
+	file   string
+	name   string
+	b      *KMLBuilder
+	legend bool
+	query  bool // no file and table folders, only the events
+	body   *spool
+	bw     *bufio.Writer
+	table  *spool
+	tw     *bufio.Writer
+}
+
+// NewKMLSink returns a sink writing a KML document named name to w, file is
+// the path of the document which the legend image is written next to.  With
+// query set there are no file and table folders, only the events.
+func NewKMLSink(w io.Writer, file, name string, b *KMLBuilder, legend, query bool) (Sink, error) {
+	body, err := newSpool()
+	if err != nil {
+		return nil, err
+	}
+	table, err := newSpool()
+	if err != nil {
+		body.close()
+		return nil, err
+	}
+	return &kmlSink{w: w, file: file, name: name, b: b, legend: legend, query: query,
+		body: body, bw: bufio.NewWriter(body), table: table, tw: bufio.NewWriter(table)}, nil
+}
+
+// kmlIndent is the indent for elements at depth levels below the root
+func kmlIndent(depth int) string {
+	return strings.Repeat("  ", depth)
+}
+
+// writeKMLElement writes an element at depth, in the same layout as a whole
+// document written with WriteIndent
+func writeKMLElement(w io.Writer, depth int, el kml.Element) error {
+	enc := xml.NewEncoder(w)
+	enc.Indent(kmlIndent(depth), "  ")
+	if err := enc.Encode(el); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// openFolder starts a folder at depth, the caller writes the closing tag
+func openFolder(w io.Writer, depth int, details []kml.Element) error {
+	if _, err := io.WriteString(w, kmlIndent(depth)+"<Folder>\n"); err != nil {
+		return err
+	}
+	for _, d := range details {
+		if err := writeKMLElement(w, depth+1, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func closeFolder(w io.Writer, depth int) error {
+	_, err := io.WriteString(w, kmlIndent(depth)+"</Folder>\n")
+	return err
+}
+
+// tableDepth is the depth of the table folders below the root
+func (k *kmlSink) tableDepth() int {
+	if k.query {
+		return 2
+	}
+	return 3
+}
+
+func (k *kmlSink) BeginFile(file string) error {
+	if k.query {
+		return nil
+	}
+	return openFolder(k.bw, 2, fileDetails(file))
+}
+
+func (k *kmlSink) BeginTable(file, table string) error {
+	if err := k.table.reset(); err != nil {
+		return err
+	}
+	k.tw.Reset(k.table)
+	return nil
+}
+
+func (k *kmlSink) Event(ev *Event) error {
+	return writeKMLElement(k.tw, k.tableDepth()+1, k.b.eventNode(ev).element())
+}
+
+func (k *kmlSink) EndTable(file, table string, rows int) error {
+	if err := k.tw.Flush(); err != nil {
+		return err
+	}
+	if err := openFolder(k.bw, k.tableDepth(), tableDetails(table, rows)); err != nil {
+		return err
+	}
+	if err := k.table.copyTo(k.bw); err != nil {
+		return err
+	}
+	return closeFolder(k.bw, k.tableDepth())
+}
+
+func (k *kmlSink) EndFile(file string) error {
+	if k.query {
+		return nil
+	}
+	return closeFolder(k.bw, 2)
+}
+
+// close writes the document header, now the styles and schemas are known,
+// followed by the spooled folders
+func (k *kmlSink) Close() error {
+	defer k.body.close()
+	defer k.table.close()
+
+	header := append([]kml.Element{
+		kml.Name(k.name),
+		kml.Description("Built using geo-sqlite-dumper, https://github.com/pschou/geo-sqlite-dumper"),
+		kml.Open(true),
+	}, k.b.styles.elements(kmlPointIcon)...)
+	header = append(header, k.b.schemas.elements()...)
+	if k.legend {
+		legend_file := strings.TrimSuffix(k.file, filepath.Ext(k.file)) + "_legend.png"
+		lf, err := os.Create(legend_file)
+		if err != nil {
+			return fmt.Errorf("creating legend file %q, %s", legend_file, err)
+		}
+		err = k.b.styles.writeLegend(lf)
+		lf.Close()
+		if err != nil {
+			return fmt.Errorf("writing legend file %q, %s", legend_file, err)
+		}
+		header = append(header, k.b.styles.legendOverlay(filepath.Base(legend_file)))
+	}
+
+	w := bufio.NewWriter(k.w)
+	w.WriteString(xml.Header)
+	w.WriteString(`<kml xmlns="` + kml.Namespace + `"`)
+	if k.b.gx {
+		w.WriteString(` xmlns:gx="` + kml.GxNamespace + `"`)
+	}
+	w.WriteString(">\n" + kmlIndent(1) + "<Document>\n")
+	for _, h := range header {
+		if err := writeKMLElement(w, 2, h); err != nil {
+			return err
+		}
+	}
+	if err := k.bw.Flush(); err != nil {
+		return err
+	}
+	if err := k.body.copyTo(w); err != nil {
+		return err
+	}
+	w.WriteString(kmlIndent(1) + "</Document>\n</kml>")
+	return w.Flush()
+}
@@ -0,0 +1,76 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-kml"
+)
+
+// kmlEvent writes an event of records at the positions, nil for a row
+// without one, and returns the KML of its folder
+func kmlEvent(t *testing.T, b *KMLBuilder, positions ...*kml.Coordinate) string {
+	t.Helper()
+	start := time.Date(2022, 8, 1, 12, 0, 0, 0, time.UTC)
+	ev := &Event{File: "t1.db", Table: "ZLOCATION"}
+	for i, c := range positions {
+		ev.Records = append(ev.Records, &Record{
+			Coords: c,
+			Time:   start.Add(time.Duration(i) * time.Minute),
+			Data: map[string]interface{}{
+				"SOURCE_FILE_PATH": "t1.db",
+				"SOURCE_TABLE":     "ZLOCATION",
+			},
+			Columns: []string{"SOURCE_FILE_PATH", "SOURCE_TABLE"},
+			File:    "t1.db",
+			Table:   "ZLOCATION",
+			Row:     i,
+		})
+	}
+	var buf bytes.Buffer
+	if err := writeKMLElement(&buf, 0, b.eventNode(ev).element()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestKMLPathSkipsMissingPositions(t *testing.T) {
+	b := NewKMLBuilder(nil, true, false, false)
+	a := &kml.Coordinate{Lon: -77.0365, Lat: 38.8977}
+	c := &kml.Coordinate{Lon: -77.0091, Lat: 38.8899}
+
+	// A row with no position between two with one leaves a path of two
+	out := kmlEvent(t, b, a, nil, c)
+	if strings.Count(out, "<LineString>") != 1 {
+		t.Fatalf("no path for an event with a missing position:\n%s", out)
+	}
+	if !strings.Contains(out, "<coordinates>-77.0365,38.8977 -77.0091,38.8899</coordinates>") {
+		t.Errorf("path is not of the two positions:\n%s", out)
+	}
+	if got := strings.Count(out, "<Point>"); got != 2 {
+		t.Errorf("%d points written, want 2", got)
+	}
+
+	// With a single position left there is nothing to draw a path between
+	out = kmlEvent(t, b, nil, a, nil)
+	if strings.Contains(out, "<LineString>") {
+		t.Errorf("path drawn for an event with one position:\n%s", out)
+	}
+	if got := strings.Count(out, "<Point>"); got != 1 {
+		t.Errorf("%d points written, want 1", got)
+	}
+}
@@ -0,0 +1,133 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/twpayne/go-kml"
+)
+
+// kmlSchema is the KML Schema for the columns of one table, the field types
+// are widened as values are seen so they are only final once all the rows
+// have been read
+type kmlSchema struct {
+	id     string
+	name   string
+	fields []string
+	types  map[string]string
+}
+
+// kmlSchemas hands out a schema for every source file and table combination
+type kmlSchemas struct {
+	list  []*kmlSchema
+	index map[kmlStyleKey]*kmlSchema
+}
+
+// get returns the schema for a file and table, creating it if needed
+func (s *kmlSchemas) get(file, table string) *kmlSchema {
+	if s.index == nil {
+		s.index = make(map[kmlStyleKey]*kmlSchema)
+	}
+	k := kmlStyleKey{file: file, table: table}
+	sc, ok := s.index[k]
+	if !ok {
+		name := table
+		if name == "" {
+			name = "query"
+		}
+		sc = &kmlSchema{
+			id:    fmt.Sprintf("schema%d", len(s.list)),
+			name:  name,
+			types: make(map[string]string),
+		}
+		s.index[k] = sc
+		s.list = append(s.list, sc)
+	}
+	return sc
+}
+
+// elements builds the Schema elements for every schema handed out
+func (s *kmlSchemas) elements() (ret []kml.Element) {
+	for _, sc := range s.list {
+		var fields []kml.Element
+		for _, f := range sc.fields {
+			fields = append(fields, kml.SimpleField(f, sc.types[f]))
+		}
+		ret = append(ret, kml.Schema(sc.id, sc.name, fields...))
+	}
+	return
+}
+
+// kmlType returns the KML SimpleField type for a value
+func kmlType(v interface{}) string {
+	switch v.(type) {
+	case int, int64:
+		return "int"
+	case float64:
+		return "double"
+	case bool:
+		return "bool"
+	}
+	return "string"
+}
+
+// observe adds the column to the schema, widening the type when the value
+// does not fit the type seen so far
+func (sc *kmlSchema) observe(name string, v interface{}) {
+	t := kmlType(v)
+	cur, ok := sc.types[name]
+	if !ok {
+		sc.fields = append(sc.fields, name)
+		sc.types[name] = t
+		return
+	}
+	switch {
+	case cur == t, cur == "string":
+	case cur == "int" && t == "double", cur == "double" && t == "int":
+		sc.types[name] = "double"
+	default:
+		sc.types[name] = "string"
+	}
+}
+
+// kmlValue formats a value for a SimpleData element
+func kmlValue(v interface{}) string {
+	switch val := v.(type) {
+	case int:
+		return strconv.Itoa(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case string:
+		return val
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// extendedData builds the typed ExtendedData for a row of data, only columns
+// which are in the schema and set in the row are included
+func (sc *kmlSchema) extendedData(data map[string]interface{}) kml.Element {
+	var values []kml.Element
+	for _, f := range sc.fields {
+		if v, ok := data[f]; ok {
+			values = append(values, kml.SimpleData(f, kmlValue(v)))
+		}
+	}
+	return kml.ExtendedData(kml.SchemaData("#"+sc.id, values...))
+}
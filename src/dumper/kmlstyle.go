@@ -0,0 +1,208 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/twpayne/go-kml"
+)
+
+// kmlPalette is a set of colors which are easy to tell apart on a map
+var kmlPalette = []color.RGBA{
+	{R: 0xe6, G: 0x19, B: 0x4b, A: 0xff}, // red
+	{R: 0x3c, G: 0xb4, B: 0x4b, A: 0xff}, // green
+	{R: 0xff, G: 0xe1, B: 0x19, A: 0xff}, // yellow
+	{R: 0x43, G: 0x63, B: 0xd8, A: 0xff}, // blue
+	{R: 0xf5, G: 0x82, B: 0x31, A: 0xff}, // orange
+	{R: 0x91, G: 0x1e, B: 0xb4, A: 0xff}, // purple
+	{R: 0x42, G: 0xd4, B: 0xf4, A: 0xff}, // cyan
+	{R: 0xf0, G: 0x32, B: 0xe6, A: 0xff}, // magenta
+	{R: 0xbf, G: 0xef, B: 0x45, A: 0xff}, // lime
+	{R: 0xfa, G: 0xbe, B: 0xd4, A: 0xff}, // pink
+	{R: 0x46, G: 0x99, B: 0x90, A: 0xff}, // teal
+	{R: 0xdc, G: 0xbe, B: 0xff, A: 0xff}, // lavender
+	{R: 0x9a, G: 0x63, B: 0x24, A: 0xff}, // brown
+	{R: 0x80, G: 0x00, B: 0x00, A: 0xff}, // maroon
+	{R: 0x80, G: 0x80, B: 0x00, A: 0xff}, // olive
+	{R: 0x00, G: 0x00, B: 0x75, A: 0xff}, // navy
+}
+
+const kmlPointIcon = "http://maps.google.com/mapfiles/kml/shapes/shaded_dot.png"
+
+type kmlStyleKey struct {
+	file, table string
+}
+
+// kmlStyles hands out a color for every source file and table combination in
+// the order they are first seen, and builds the matching KML style elements
+type kmlStyles struct {
+	keys  []kmlStyleKey
+	index map[kmlStyleKey]int
+}
+
+// id returns the style id for a file and table, assigning a new one if needed
+func (s *kmlStyles) id(file, table string) string {
+	if s.index == nil {
+		s.index = make(map[kmlStyleKey]int)
+	}
+	k := kmlStyleKey{file: file, table: table}
+	i, ok := s.index[k]
+	if !ok {
+		i = len(s.keys)
+		s.index[k] = i
+		s.keys = append(s.keys, k)
+	}
+	return fmt.Sprintf("style%d", i)
+}
+
+// url returns the styleUrl to use for a file and table
+func (s *kmlStyles) url(file, table string) string {
+	return "#" + s.id(file, table)
+}
+
+// color returns the palette color for the i-th style
+func (s *kmlStyles) color(i int) color.RGBA {
+	return kmlPalette[i%len(kmlPalette)]
+}
+
+// elements builds the Style and StyleMap elements for every style handed out,
+// using the given icon for the points
+func (s *kmlStyles) elements(icon string) (ret []kml.Element) {
+	for i := range s.keys {
+		id := fmt.Sprintf("style%d", i)
+		c := s.color(i)
+		poly := c
+		poly.A = 0x40
+		for _, state := range []struct {
+			suffix string
+			scale  float64
+			width  float64
+		}{{"-normal", 0.8, 2}, {"-highlight", 1.2, 4}} {
+			ret = append(ret, kml.SharedStyle(id+state.suffix,
+				kml.IconStyle(
+					kml.Color(c),
+					kml.Scale(state.scale),
+					kml.Icon(kml.Href(icon)),
+				),
+				kml.LineStyle(
+					kml.Color(c),
+					kml.Width(state.width),
+				),
+				kml.PolyStyle(
+					kml.Color(poly),
+				),
+			))
+		}
+		ret = append(ret, kml.SharedStyleMap(id,
+			kml.Pair(kml.Key(kml.StyleStateNormal), kml.StyleURL("#"+id+"-normal")),
+			kml.Pair(kml.Key(kml.StyleStateHighlight), kml.StyleURL("#"+id+"-highlight")),
+		))
+	}
+	return
+}
+
+// label is the text shown in the legend for a style
+func (k kmlStyleKey) label() string {
+	if k.table == "" {
+		return filepath.Base(k.file)
+	}
+	return filepath.Base(k.file) + ": " + k.table
+}
+
+// legendOverlay returns a ScreenOverlay placing the legend image in the upper
+// left corner of the view
+func (s *kmlStyles) legendOverlay(href string) kml.Element {
+	return kml.ScreenOverlay(
+		kml.Name("Legend"),
+		kml.Icon(kml.Href(href)),
+		kml.OverlayXY(kml.Vec2{X: 0, Y: 1, XUnits: kml.UnitsFraction, YUnits: kml.UnitsFraction}),
+		kml.ScreenXY(kml.Vec2{X: 0.01, Y: 0.99, XUnits: kml.UnitsFraction, YUnits: kml.UnitsFraction}),
+		kml.Size(kml.Vec2{X: 0, Y: 0, XUnits: kml.UnitsPixels, YUnits: kml.UnitsPixels}),
+	)
+}
+
+// Legend layout, in pixels
+const (
+	legendScale  = 2
+	legendRow    = 8 * legendScale
+	legendMargin = 3 * legendScale
+	legendSwatch = 5 * legendScale
+)
+
+// writeLegend renders a PNG with a color swatch and label for every style
+func (s *kmlStyles) writeLegend(w io.Writer) error {
+	width := 0
+	for _, k := range s.keys {
+		if l := len(k.label()); l > width {
+			width = l
+		}
+	}
+	width = 2*legendMargin + legendSwatch + legendMargin + width*4*legendScale
+	height := 2*legendMargin + len(s.keys)*legendRow
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xd0}}, image.Point{}, draw.Src)
+	for i, k := range s.keys {
+		y := legendMargin + i*legendRow
+		swatch := image.Rect(legendMargin, y, legendMargin+legendSwatch, y+legendSwatch)
+		draw.Draw(img, swatch, &image.Uniform{s.color(i)}, image.Point{}, draw.Src)
+		legendText(img, 2*legendMargin+legendSwatch, y, k.label())
+	}
+	return png.Encode(w, img)
+}
+
+// legendText draws the string using the small built in font
+func legendText(img *image.RGBA, x, y int, str string) {
+	for _, r := range strings.ToUpper(str) {
+		glyph, ok := legendFont[r]
+		if !ok {
+			glyph = legendFont['?']
+		}
+		for i, b := range glyph {
+			if b != '#' {
+				continue
+			}
+			px := x + (i%3)*legendScale
+			py := y + (i/3)*legendScale
+			draw.Draw(img, image.Rect(px, py, px+legendScale, py+legendScale), image.Black, image.Point{}, draw.Src)
+		}
+		x += 4 * legendScale
+	}
+}
+
+// legendFont is a 3x5 pixel font, enough to label file and table names
+var legendFont = map[rune]string{
+	'A': ".#.#.#####.##.#", 'B': "##.#.###.#.###.", 'C': ".###..#..#...##",
+	'D': "##.#.##.##.###.", 'E': "####..##.#..###", 'F': "####..##.#..#..",
+	'G': ".###..#.##.#.##", 'H': "#.##.#####.##.#", 'I': "###.#..#..#.###",
+	'J': "..#..#..##.#.#.", 'K': "#.##.###.#.##.#", 'L': "#..#..#..#..###",
+	'M': "#.########.##.#", 'N': "##.#.##.##.##.#", 'O': ".#.#.##.##.#.#.",
+	'P': "##.#.###.#..#..", 'Q': ".#.#.##.###..##", 'R': "##.#.###.#.##.#",
+	'S': ".###...#...###.", 'T': "###.#..#..#..#.", 'U': "#.##.##.##.####",
+	'V': "#.##.##.##.#.#.", 'W': "#.##.########.#", 'X': "#.##.#.#.#.##.#",
+	'Y': "#.##.#.#..#..#.", 'Z': "###..#.#.#..###", '0': "####.##.##.####",
+	'1': ".#.##..#..#.###", '2': "##...#.#.#..###", '3': "##...#.#...###.",
+	'4': "#.##.####..#..#", '5': "####..##...###.", '6': ".###..####.####",
+	'7': "###..#.#..#..#.", '8': "####.#####.####", '9': "####.####..###.",
+	' ': "...............", '.': ".............#.", '_': "............###",
+	'-': "......###......", '/': "..#..#.#.#..#..", ':': "....#.....#....",
+	'(': ".#.#..#..#...#.", ')': ".#...#..#..#.#.", '?': "##...#.#.....#.",
+}
@@ -0,0 +1,237 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"archive/zip"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"github.com/twpayne/go-kml"
+)
+
+// kmlNode is a folder in the file / table / event hierarchy, kept apart from
+// the KML elements so the tree can be split up into several documents
+type kmlNode struct {
+	details  []kml.Element // name, open, description and time span
+	features []kml.Element // placemarks and folders directly in this node
+	children []*kmlNode
+	points   int // points in features
+}
+
+// total returns the number of points in the node and all of its children
+func (n *kmlNode) total() int {
+	t := n.points
+	for _, c := range n.children {
+		t += c.total()
+	}
+	return t
+}
+
+// element builds the KML folder for the node and everything below it
+func (n *kmlNode) element() kml.Element {
+	elements := append([]kml.Element{}, n.details...)
+	elements = append(elements, n.features...)
+	elements = append(elements, kmlNodeElements(n.children)...)
+	return kml.Folder(elements...)
+}
+
+// kmlNodeElements builds the KML folders for a slice of nodes
+func kmlNodeElements(nodes []*kmlNode) (ret []kml.Element) {
+	for _, n := range nodes {
+		ret = append(ret, n.element())
+	}
+	return
+}
+
+// Paths of the images embedded in the KMZ file
+const (
+	kmzPointIcon = "files/dot.png"
+	kmzLegend    = "files/legend.png"
+)
+
+// kmzWriter splits a node tree into a root document and child documents which
+// are loaded through NetworkLinks
+type kmzWriter struct {
+	split int
+	parts []*kmlNode
+}
+
+// place returns the elements for the nodes to put in a document with budget
+// points left.  Nodes which do not fit are moved into a child document, or if
+// they are too large for one, turned into a folder with their children placed
+// the same way.
+func (k *kmzWriter) place(nodes []*kmlNode, budget *int) []kml.Element {
+	var ret []kml.Element
+	for _, n := range nodes {
+		total := n.total()
+		switch {
+		case total <= *budget:
+			*budget -= total
+			ret = append(ret, n.element())
+		case total <= k.split || len(n.children) == 0:
+			k.parts = append(k.parts, n)
+			link := append([]kml.Element{}, n.details...)
+			link = append(link, kml.Link(kml.Href(k.partName(len(k.parts)))))
+			ret = append(ret, kml.NetworkLink(link...))
+		default:
+			*budget -= n.points
+			elements := append([]kml.Element{}, n.details...)
+			elements = append(elements, n.features...)
+			elements = append(elements, k.place(n.children, budget)...)
+			ret = append(ret, kml.Folder(elements...))
+		}
+	}
+	return ret
+}
+
+// partName is the file name of the i-th child document
+func (k *kmzWriter) partName(i int) string {
+	return fmt.Sprintf("part_%d.kml", i)
+}
+
+// writeKMZ writes the node tree to a KMZ archive with the point icon and
+// legend embedded.  When there are more than split points, folders are moved
+// into child documents referenced by NetworkLinks so a viewer only has to
+// load them when they are opened.
+func writeKMZ(w io.Writer, name string, gx bool, styles *kmlStyles, schemas *kmlSchemas, legend bool, nodes []*kmlNode, split int) error {
+	root := kml.KML
+	if gx {
+		root = kml.GxKML
+	}
+	k := &kmzWriter{split: split}
+	budget := split
+	elements := append([]kml.Element{
+		kml.Name(name),
+		kml.Description("Built using geo-sqlite-dumper, https://github.com/pschou/geo-sqlite-dumper"),
+		kml.Open(true),
+	}, styles.elements(kmzPointIcon)...)
+	elements = append(elements, schemas.elements()...)
+	if legend {
+		elements = append(elements, styles.legendOverlay(kmzLegend))
+	}
+	elements = append(elements, k.place(nodes, &budget)...)
+
+	zw := zip.NewWriter(w)
+	// The first KML file in the archive is the one a viewer opens
+	f, err := zw.Create("doc.kml")
+	if err != nil {
+		return err
+	}
+	if err = root(kml.Document(elements...)).WriteIndent(f, "", "  "); err != nil {
+		return err
+	}
+
+	for i, n := range k.parts {
+		f, err = zw.Create(k.partName(i + 1))
+		if err != nil {
+			return err
+		}
+		part := append([]kml.Element{kml.Name(name)}, styles.elements(kmzPointIcon)...)
+		part = append(part, schemas.elements()...)
+		if err = root(kml.Document(append(part, n.element())...)).WriteIndent(f, "", "  "); err != nil {
+			return err
+		}
+	}
+
+	if f, err = zw.Create(kmzPointIcon); err != nil {
+		return err
+	}
+	if err = writePointIcon(f); err != nil {
+		return err
+	}
+	if legend {
+		if f, err = zw.Create(kmzLegend); err != nil {
+			return err
+		}
+		if err = styles.writeLegend(f); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// kmzSink keeps the folder tree in memory, as it is split up into documents
+// by point count, and writes the KMZ file once all the files are read
+type kmzSink struct {
+	NopSink
+	w      io.Writer
+	name   string
+	b      *KMLBuilder
+	legend bool
+	query  bool // no file folders, only the tables
+	split  int
+	nodes  []*kmlNode
+	tables []*kmlNode
+	events []*kmlNode
+}
+
+// NewKMZSink returns a sink writing a KMZ archive to w, with folders moved
+// into child documents once there are more than split points
+func NewKMZSink(w io.Writer, name string, b *KMLBuilder, legend, query bool, split int) Sink {
+	return &kmzSink{w: w, name: name, b: b, legend: legend, query: query, split: split}
+}
+
+func (k *kmzSink) Event(ev *Event) error {
+	k.events = append(k.events, k.b.eventNode(ev))
+	return nil
+}
+
+func (k *kmzSink) EndTable(file, table string, rows int) error {
+	k.tables = append(k.tables, &kmlNode{
+		details:  tableDetails(table, rows),
+		children: k.events,
+	})
+	k.events = nil
+	return nil
+}
+
+func (k *kmzSink) EndFile(file string) error {
+	if k.query {
+		k.nodes = append(k.nodes, k.tables...)
+	} else {
+		k.nodes = append(k.nodes, &kmlNode{
+			details:  fileDetails(file),
+			children: k.tables,
+		})
+	}
+	k.tables = nil
+	return nil
+}
+
+func (k *kmzSink) Close() error {
+	return writeKMZ(k.w, k.name, k.b.gx, &k.b.styles, &k.b.schemas, k.legend, k.nodes, k.split)
+}
+
+// writePointIcon renders a white dot with a dark outline, white so the
+// IconStyle color tints it to the style color
+func writePointIcon(w io.Writer) error {
+	const size = 32
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	c := float64(size-1) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := Sq(float64(x)-c) + Sq(float64(y)-c)
+			switch {
+			case d <= Sq(c-3):
+				img.Set(x, y, color.White)
+			case d <= Sq(c):
+				img.Set(x, y, color.RGBA{R: 0x20, G: 0x20, B: 0x20, A: 0xff})
+			}
+		}
+	}
+	return png.Encode(w, img)
+}
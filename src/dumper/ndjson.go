@@ -0,0 +1,145 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/twpayne/go-geom/encoding/geojson"
+)
+
+// isoTime rewrites a time the source renders in its columns, such as the
+// _PARSED and LOCAL_TIME ones, as ISO-8601
+func isoTime(s string) (string, bool) {
+	if t, err := time.Parse(LocalLayout, s); err == nil {
+		return t.Format(time.RFC3339), true
+	}
+	// Without an offset the time is in UTC
+	if t, err := time.Parse("2006-01-02 15:04:05", s); err == nil {
+		return t.Format(time.RFC3339), true
+	}
+	return "", false
+}
+
+// ndjsonSink writes a JSON object on its own line for every record as soon
+// as it is read, so the rows can be piped into jq or a log shipper while the
+// files are still being read, though with more than one job each file is
+// read in full before its records are handed on.  The object has the source
+// file, table, rowid and row number, the time in ISO-8601, the position and
+// any shape as a GeoJSON geometry, with the columns of the row in DATA
+// keeping their types, BLOBs in base64 and the parsed times in ISO-8601.
+type ndjsonSink struct {
+	NopSink
+	w     *bufio.Writer
+	buf   bytes.Buffer
+	flush bool
+}
+
+// NewNDJSONSink returns a sink writing newline delimited JSON to w, passing
+// on each line as it is written when flush is set, as for a pipe
+func NewNDJSONSink(w io.Writer, flush bool) Sink {
+	return &ndjsonSink{w: bufio.NewWriter(w), flush: flush}
+}
+
+// key starts a member of the object being written
+func (n *ndjsonSink) key(k string) {
+	if b := n.buf.Bytes(); b[len(b)-1] != '{' {
+		n.buf.WriteByte(',')
+	}
+	b, _ := json.Marshal(k)
+	n.buf.Write(b)
+	n.buf.WriteByte(':')
+}
+
+// value writes a value, those JSON can not hold, such as NaN, as null
+func (n *ndjsonSink) value(v interface{}) {
+	if b, err := json.Marshal(v); err == nil {
+		n.buf.Write(b)
+	} else {
+		n.buf.WriteString("null")
+	}
+}
+
+func (n *ndjsonSink) Record(e *Record) error {
+	n.buf.Reset()
+	n.buf.WriteByte('{')
+	n.key("SOURCE_FILE_PATH")
+	n.value(e.File)
+	n.key("SOURCE_TABLE")
+	n.value(e.Table)
+	if e.Rowid != 0 {
+		n.key("SOURCE_ROWID")
+		n.value(e.Rowid)
+	}
+	n.key("SOURCE_ROW")
+	n.value(e.Row)
+	if !e.Time.IsZero() {
+		n.key("TIME")
+		n.value(e.Time.Format(time.RFC3339Nano))
+	}
+	if e.Coords != nil {
+		n.key("LATITUDE")
+		n.value(e.Coords.Lat)
+		n.key("LONGITUDE")
+		n.value(e.Coords.Lon)
+		if e.Coords.Alt != 0 {
+			n.key("ALTITUDE")
+			n.value(e.Coords.Alt)
+		}
+	}
+	if e.Label != "" {
+		n.key("LABEL")
+		n.value(e.Label)
+	}
+	if e.Geometry != nil {
+		if g, err := geojson.Encode(e.Geometry); err == nil {
+			n.key("GEOMETRY")
+			n.value(g)
+		}
+	}
+
+	n.key("DATA")
+	n.buf.WriteByte('{')
+	for _, c := range e.Columns {
+		if c == "SOURCE_FILE_PATH" || c == "SOURCE_TABLE" {
+			continue
+		}
+		v := e.Data[c]
+		if s, ok := v.(string); ok && (strings.HasSuffix(c, "_PARSED") || c == "LOCAL_TIME") {
+			if iso, ok := isoTime(s); ok {
+				v = iso
+			}
+		}
+		n.key(c)
+		n.value(v)
+	}
+	n.buf.WriteString("}}\n")
+
+	if _, err := n.w.Write(n.buf.Bytes()); err != nil {
+		return err
+	}
+	if n.flush {
+		// Each line is passed on whole as soon as it is written
+		return n.w.Flush()
+	}
+	return nil
+}
+
+func (n *ndjsonSink) Close() error {
+	return n.w.Flush()
+}
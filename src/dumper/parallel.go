@@ -0,0 +1,149 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+// Kinds of sink call kept by a recorder
+const (
+	callBeginFile = iota
+	callBeginTable
+	callRecord
+	callEvent
+	callEndTable
+	callEndFile
+)
+
+type sinkCall struct {
+	kind        int
+	file, table string
+	rows        int
+	r           *Record
+	ev          *Event
+}
+
+// recorder is a sink which keeps the calls for one file, so a file read in
+// the background can be handed on to the real sinks later
+type recorder struct {
+	calls []sinkCall
+}
+
+func (c *recorder) BeginFile(file string) error {
+	c.calls = append(c.calls, sinkCall{kind: callBeginFile, file: file})
+	return nil
+}
+
+func (c *recorder) BeginTable(file, table string) error {
+	c.calls = append(c.calls, sinkCall{kind: callBeginTable, file: file, table: table})
+	return nil
+}
+
+func (c *recorder) Record(r *Record) error {
+	c.calls = append(c.calls, sinkCall{kind: callRecord, r: r})
+	return nil
+}
+
+func (c *recorder) Event(ev *Event) error {
+	c.calls = append(c.calls, sinkCall{kind: callEvent, ev: ev})
+	return nil
+}
+
+func (c *recorder) EndTable(file, table string, rows int) error {
+	c.calls = append(c.calls, sinkCall{kind: callEndTable, file: file, table: table, rows: rows})
+	return nil
+}
+
+func (c *recorder) EndFile(file string) error {
+	c.calls = append(c.calls, sinkCall{kind: callEndFile, file: file})
+	return nil
+}
+
+func (c *recorder) Close() error { return nil }
+
+// replay makes the kept calls on out in the order they were made
+func (c *recorder) replay(out Sink) (err error) {
+	for _, call := range c.calls {
+		switch call.kind {
+		case callBeginFile:
+			err = out.BeginFile(call.file)
+		case callBeginTable:
+			err = out.BeginTable(call.file, call.table)
+		case callRecord:
+			err = out.Record(call.r)
+		case callEvent:
+			err = out.Event(call.ev)
+		case callEndTable:
+			err = out.EndTable(call.file, call.table, call.rows)
+		case callEndFile:
+			err = out.EndFile(call.file)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type fileResult struct {
+	rec *recorder
+	err error
+}
+
+// ReadFiles reads the files with up to jobs of them being read at once.  The
+// sinks are handed the records of one file at a time, in the order of the
+// files, so the output is the same as reading them one after the other.  At
+// most jobs files are held in memory while they wait their turn.
+func (s *Source) ReadFiles(files []string, jobs int, out Sink) error {
+	if jobs <= 1 {
+		for _, f := range files {
+			if err := s.ReadFile(f, out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	results := make([]chan fileResult, len(files))
+	for i := range results {
+		results[i] = make(chan fileResult, 1)
+	}
+	// A token is taken when a file is started and given back once it has
+	// been handed to the sinks
+	tokens := make(chan struct{}, jobs)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for i, f := range files {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			go func(i int, f string) {
+				rec := &recorder{}
+				err := s.ReadFile(f, rec)
+				results[i] <- fileResult{rec: rec, err: err}
+			}(i, f)
+		}
+	}()
+
+	for i := range files {
+		res := <-results[i]
+		if res.err != nil {
+			return res.err
+		}
+		if err := res.rec.replay(out); err != nil {
+			return err
+		}
+		<-tokens
+	}
+	return nil
+}
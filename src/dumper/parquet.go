@@ -0,0 +1,533 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkb"
+)
+
+// Apache Parquet reference: https://github.com/apache/parquet-format
+// GeoParquet reference: https://geoparquet.org/releases/v1.0.0/
+
+// parquetRowGroup is the most rows put in a row group
+const parquetRowGroup = 65536
+
+// Physical types, encodings and the other enums of the Parquet metadata
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+
+	parquetUTF8            = 0 // converted types
+	parquetTimestampMicros = 10
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// Thrift compact protocol types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Parquet metadata with the Thrift compact protocol,
+// the fields of a struct have to be written in the order of their ids
+type thriftWriter struct {
+	bytes.Buffer
+	last []int16 // id of the last field of each open struct
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	t.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.WriteByte(typ)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) str(s string) {
+	t.varint(uint64(len(s)))
+	t.WriteString(s)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.str(s)
+}
+
+// list starts a list field of n elements, which follow it
+func (t *thriftWriter) list(id int16, typ byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.WriteByte(byte(n)<<4 | typ)
+	} else {
+		t.WriteByte(0xF0 | typ)
+		t.varint(uint64(n))
+	}
+}
+
+// open starts a struct, an element of a list, closed by end
+func (t *thriftWriter) open() {
+	t.last = append(t.last, 0)
+}
+
+// begin starts a struct field, closed by end
+func (t *thriftWriter) begin(id int16) {
+	t.field(id, thriftStruct)
+	t.open()
+}
+
+func (t *thriftWriter) end() {
+	t.WriteByte(0)
+	t.last = t.last[:len(t.last)-1]
+}
+
+// parquetColumn is a column of the file, typed by the values seen in it, and
+// the values of the row group being written
+type parquetColumn struct {
+	kindSet
+	name  string // in the file
+	kind  int32  // physical type
+	text  bool   // a UTF-8 string
+	stamp bool   // a timestamp in microseconds
+
+	defs   []bool // whether each row has a value
+	values bytes.Buffer
+	flags  []bool // values of a boolean column
+}
+
+// settle picks the type of the column from the kinds of values seen
+func (c *parquetColumn) settle() {
+	switch c.kindSet.kind() {
+	case kindFloat:
+		c.kind = parquetDouble
+	case kindInt:
+		c.kind = parquetInt64
+	case kindBool:
+		c.kind = parquetBoolean
+	case kindBlob:
+		c.kind = parquetByteArray
+	default:
+		c.kind, c.text = parquetByteArray, true
+	}
+}
+
+// add puts a value of the row being written in the column
+func (c *parquetColumn) add(v interface{}) {
+	if v == nil {
+		c.defs = append(c.defs, false)
+		return
+	}
+	c.defs = append(c.defs, true)
+	switch {
+	case c.kind == parquetBoolean:
+		c.flags = append(c.flags, v.(bool))
+	case c.kind == parquetInt64:
+		switch val := v.(type) {
+		case int:
+			binary.Write(&c.values, binary.LittleEndian, int64(val))
+		case int64:
+			binary.Write(&c.values, binary.LittleEndian, val)
+		}
+	case c.kind == parquetDouble:
+		var f float64
+		switch val := v.(type) {
+		case int:
+			f = float64(val)
+		case int64:
+			f = float64(val)
+		case float64:
+			f = val
+		}
+		binary.Write(&c.values, binary.LittleEndian, math.Float64bits(f))
+	default:
+		var b []byte
+		if blob, ok := v.([]byte); ok && !c.text {
+			b = blob
+		} else {
+			b = []byte(valueText(v))
+		}
+		binary.Write(&c.values, binary.LittleEndian, uint32(len(b)))
+		c.values.Write(b)
+	}
+}
+
+// bitPack packs the flags into bytes, the first in the lowest bit
+func bitPack(flags []bool) []byte {
+	b := make([]byte, (len(flags)+7)/8)
+	for i, f := range flags {
+		if f {
+			b[i/8] |= 1 << (i % 8)
+		}
+	}
+	return b
+}
+
+// page returns the data page of the row group, the definition levels in one
+// bit packed run followed by the plain values
+func (c *parquetColumn) page() []byte {
+	var page bytes.Buffer
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64((len(c.defs)+7)/8)<<1|1)
+	levels := bitPack(c.defs)
+	binary.Write(&page, binary.LittleEndian, uint32(n+len(levels)))
+	page.Write(header[:n])
+	page.Write(levels)
+	if c.kind == parquetBoolean {
+		page.Write(bitPack(c.flags))
+	} else {
+		page.Write(c.values.Bytes())
+	}
+	return page.Bytes()
+}
+
+func (c *parquetColumn) reset() {
+	c.defs = c.defs[:0]
+	c.flags = c.flags[:0]
+	c.values.Reset()
+}
+
+// parquetChunk is where a column chunk was written
+type parquetChunk struct {
+	offset, size int64
+}
+
+// geoParquetColumn is the GeoParquet metadata of a geometry column
+type geoParquetColumn struct {
+	Encoding      string    `json:"encoding"`
+	GeometryTypes []string  `json:"geometry_types"`
+	Bbox          []float64 `json:"bbox,omitempty"`
+}
+
+// parquetSink writes the records to a GeoParquet file, with the shape of
+// every row as WKB in a geometry column, its time as a UTC timestamp and a
+// column for each column of the source tables, typed by the values in it.
+// The rows are spooled until the full set of columns is known.
+type parquetSink struct {
+	NopSink
+	w       io.Writer
+	version string
+	rows    *spool
+	buf     *bufio.Writer
+	enc     *gob.Encoder
+	count   int
+	names   columnSet
+	columns map[string]*parquetColumn // by the source column
+	shapes  columnSet                 // GeoParquet geometry types seen
+	bounds  *geom.Bounds
+}
+
+// NewParquetSink returns a sink writing GeoParquet to w, version is the
+// version of the program put in the created_by of the file
+func NewParquetSink(w io.Writer, version string) (Sink, error) {
+	f, err := newSpool()
+	if err != nil {
+		return nil, err
+	}
+	buf := bufio.NewWriter(f)
+	return &parquetSink{w: w, version: version, rows: f, buf: buf, enc: gob.NewEncoder(buf),
+		columns: make(map[string]*parquetColumn)}, nil
+}
+
+// geoParquetType is the GeoParquet name of the type of a geometry
+func geoParquetType(g geom.T) string {
+	var name string
+	switch g.(type) {
+	case *geom.Point:
+		name = "Point"
+	case *geom.LineString:
+		name = "LineString"
+	case *geom.Polygon:
+		name = "Polygon"
+	case *geom.MultiPoint:
+		name = "MultiPoint"
+	case *geom.MultiLineString:
+		name = "MultiLineString"
+	case *geom.MultiPolygon:
+		name = "MultiPolygon"
+	default:
+		name = "GeometryCollection"
+	}
+	if g.Layout().ZIndex() >= 0 {
+		name += " Z"
+	}
+	return name
+}
+
+func (p *parquetSink) Record(e *Record) error {
+	row := geoRow{Data: e.Data}
+	if shape := recordGeometry(e); shape != nil {
+		b, err := wkb.Marshal(shape, wkb.NDR)
+		if err != nil {
+			return err
+		}
+		row.Geometry = b
+		p.shapes.add(geoParquetType(shape))
+		if p.bounds == nil {
+			p.bounds = geom.NewBounds(geom.XY)
+		}
+		p.bounds.Extend(shape)
+	}
+	if !e.Time.IsZero() {
+		row.Time = e.Time.Unix()*1000000 + int64(e.Time.Nanosecond()/1000)
+		row.HasTime = true
+	}
+	p.names.add(e.Columns...)
+	for _, c := range e.Columns {
+		col, ok := p.columns[c]
+		if !ok {
+			col = &parquetColumn{}
+			p.columns[c] = col
+		}
+		col.see(e.Data[c])
+	}
+	p.count++
+	return p.enc.Encode(row)
+}
+
+// schema returns the columns of the file, the geometry and time followed by
+// those of the tables, named so they differ even where case is ignored
+func (p *parquetSink) schema() []*parquetColumn {
+	columns := []*parquetColumn{
+		{name: "geometry", kind: parquetByteArray},
+		{name: "time", kind: parquetInt64, stamp: true},
+	}
+	used := map[string]bool{"geometry": true, "time": true}
+	for _, c := range p.names.names {
+		col := p.columns[c]
+		col.name = c
+		for i := 1; used[strings.ToLower(col.name)]; i++ {
+			col.name = fmt.Sprintf("%s_%d", c, i)
+		}
+		used[strings.ToLower(col.name)] = true
+		col.settle()
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+// Close writes the file from the spooled rows, a row group at a time
+func (p *parquetSink) Close() error {
+	defer p.rows.close()
+	if err := p.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := p.rows.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(p.rows))
+
+	columns := p.schema()
+	w := bufio.NewWriter(p.w)
+	w.WriteString("PAR1")
+	offset := int64(4)
+	var groups [][]parquetChunk
+	var sizes []int
+
+	for done := 0; done < p.count; {
+		n := 0
+		for ; n < parquetRowGroup && done+n < p.count; n++ {
+			var row geoRow
+			if err := dec.Decode(&row); err != nil {
+				return err
+			}
+			if row.Geometry != nil {
+				columns[0].add(row.Geometry)
+			} else {
+				columns[0].add(nil)
+			}
+			if row.HasTime {
+				columns[1].add(row.Time)
+			} else {
+				columns[1].add(nil)
+			}
+			for i, c := range p.names.names {
+				columns[i+2].add(row.Data[c])
+			}
+		}
+		done += n
+
+		chunks := make([]parquetChunk, len(columns))
+		for i, c := range columns {
+			page := c.page()
+			var header thriftWriter
+			header.open()
+			header.i32(1, 0) // data page
+			header.i32(2, int32(len(page)))
+			header.i32(3, int32(len(page)))
+			header.begin(5)
+			header.i32(1, int32(n))
+			header.i32(2, parquetPlain)
+			header.i32(3, parquetRLE)
+			header.i32(4, parquetRLE)
+			header.end()
+			header.end()
+			w.Write(header.Bytes())
+			w.Write(page)
+			chunks[i] = parquetChunk{offset: offset, size: int64(header.Len() + len(page))}
+			offset += chunks[i].size
+			c.reset()
+		}
+		groups = append(groups, chunks)
+		sizes = append(sizes, n)
+	}
+
+	meta := p.metadata(columns, groups, sizes)
+	w.Write(meta)
+	binary.Write(w, binary.LittleEndian, uint32(len(meta)))
+	w.WriteString("PAR1")
+	return w.Flush()
+}
+
+// metadata returns the footer of the file, the schema, where each column chunk
+// is and the GeoParquet metadata
+func (p *parquetSink) metadata(columns []*parquetColumn, groups [][]parquetChunk, sizes []int) []byte {
+	var t thriftWriter
+	t.open()
+	t.i32(1, 1)
+	t.list(2, thriftStruct, len(columns)+1)
+	t.open()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(columns)))
+	t.end()
+	for _, c := range columns {
+		t.open()
+		t.i32(1, c.kind)
+		t.i32(3, parquetOptional)
+		t.binary(4, c.name)
+		switch {
+		case c.text:
+			t.i32(6, parquetUTF8)
+			t.begin(10)
+			t.begin(1) // string
+			t.end()
+			t.end()
+		case c.stamp:
+			t.i32(6, parquetTimestampMicros)
+			t.begin(10)
+			t.begin(8) // timestamp
+			t.boolean(1, true)
+			t.begin(2)
+			t.begin(2) // microseconds
+			t.end()
+			t.end()
+			t.end()
+			t.end()
+		}
+		t.end()
+	}
+	t.i64(3, int64(p.count))
+
+	t.list(4, thriftStruct, len(groups))
+	for g, chunks := range groups {
+		t.open()
+		t.list(1, thriftStruct, len(chunks))
+		var total int64
+		for i, c := range columns {
+			chunk := chunks[i]
+			total += chunk.size
+			t.open()
+			t.i64(2, chunk.offset)
+			t.begin(3)
+			t.i32(1, c.kind)
+			t.list(2, thriftI32, 2)
+			t.zigzag(parquetPlain)
+			t.zigzag(parquetRLE)
+			t.list(3, thriftBinary, 1)
+			t.str(c.name)
+			t.i32(4, 0) // uncompressed
+			t.i64(5, int64(sizes[g]))
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.offset)
+			t.end()
+			t.end()
+		}
+		t.i64(2, total)
+		t.i64(3, int64(sizes[g]))
+		t.i64(5, chunks[0].offset)
+		t.i64(6, total)
+		t.end()
+	}
+
+	geo := geoParquetColumn{Encoding: "WKB", GeometryTypes: p.shapes.names}
+	if geo.GeometryTypes == nil {
+		geo.GeometryTypes = []string{}
+	}
+	if p.bounds != nil {
+		geo.Bbox = []float64{p.bounds.Min(0), p.bounds.Min(1), p.bounds.Max(0), p.bounds.Max(1)}
+	}
+	meta, _ := json.Marshal(struct {
+		Version       string                      `json:"version"`
+		PrimaryColumn string                      `json:"primary_column"`
+		Columns       map[string]geoParquetColumn `json:"columns"`
+	}{"1.0.0", "geometry", map[string]geoParquetColumn{"geometry": geo}})
+	t.list(5, thriftStruct, 1)
+	t.open()
+	t.binary(1, "geo")
+	t.binary(2, string(meta))
+	t.end()
+	t.binary(6, "geo-sqlite-dumper version "+p.version)
+	t.end()
+	return t.Bytes()
+}
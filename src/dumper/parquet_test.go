@@ -0,0 +1,280 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkb"
+	"github.com/twpayne/go-kml"
+)
+
+// thriftReader decodes the Thrift compact protocol, a struct into a map of
+// its fields by id, lists into slices and the integers into int64
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case thriftTrue:
+		return true
+	case thriftFalse:
+		return false
+	case 4, thriftI32, thriftI64:
+		return r.zigzag()
+	case thriftBinary:
+		n := int(r.varint())
+		r.pos += n
+		return string(r.b[r.pos-n : r.pos])
+	case thriftList:
+		h := r.b[r.pos]
+		r.pos++
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i] = r.value(h & 0x0F)
+		}
+		return list
+	case thriftStruct:
+		return r.record()
+	}
+	panic("unknown thrift type")
+}
+
+func (r *thriftReader) record() map[int16]interface{} {
+	m := make(map[int16]interface{})
+	var id int16
+	for {
+		h := r.b[r.pos]
+		r.pos++
+		if h == 0 {
+			return m
+		}
+		if delta := int16(h >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(r.zigzag())
+		}
+		m[id] = r.value(h & 0x0F)
+	}
+}
+
+// thriftFields is a decoded struct
+type thriftFields = map[int16]interface{}
+
+// parquetValues reads the definition levels and the plain values of the data
+// page of a column chunk
+func parquetValues(t *testing.T, b []byte, chunk thriftFields) ([]bool, []byte) {
+	meta := chunk[3].(thriftFields)
+	r := &thriftReader{b: b, pos: int(meta[9].(int64))}
+	header := r.record()
+	if header[1].(int64) != 0 {
+		t.Fatalf("page type is %d, want a data page", header[1])
+	}
+	rows := int(header[5].(thriftFields)[1].(int64))
+	page := b[r.pos : r.pos+int(header[3].(int64))]
+	if r.pos+len(page)-int(meta[9].(int64)) != int(meta[7].(int64)) {
+		t.Errorf("chunk of %d bytes, the metadata has %d", r.pos+len(page)-int(meta[9].(int64)), meta[7])
+	}
+
+	levels := int(binary.LittleEndian.Uint32(page))
+	run := &thriftReader{b: page[4 : 4+levels]}
+	if h := run.varint(); h&1 != 1 || int(h>>1) != (rows+7)/8 {
+		t.Fatalf("definition levels run header %d for %d rows", h, rows)
+	}
+	defs := make([]bool, rows)
+	for i := range defs {
+		defs[i] = page[4+run.pos+i/8]&(1<<(i%8)) != 0
+	}
+	return defs, page[4+levels:]
+}
+
+func TestParquetFile(t *testing.T) {
+	start := time.Date(2022, 7, 20, 8, 0, 0, 123456000, time.UTC)
+	records := []*Record{
+		{Coords: &kml.Coordinate{Lon: -77, Lat: 38.9}, Time: start,
+			Data: map[string]interface{}{"id": int64(1), "name": "first", "speed": 1.5, "ok": true}},
+		{Coords: &kml.Coordinate{Lon: -77.1, Lat: 39, Alt: 20},
+			Data: map[string]interface{}{"id": int64(2), "speed": int64(3), "ok": false}},
+		{Time: start.Add(time.Minute),
+			Data: map[string]interface{}{"id": int64(3), "name": "third", "ok": true}},
+	}
+	var buf bytes.Buffer
+	s, err := NewParquetSink(&buf, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		r.Data["SOURCE_FILE_PATH"], r.Data["SOURCE_TABLE"] = "test.sqlite", "t"
+		r.Columns = []string{"SOURCE_FILE_PATH", "SOURCE_TABLE", "id", "name", "speed", "ok"}
+		if err = s.Record(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if string(b[:4]) != "PAR1" || string(b[len(b)-4:]) != "PAR1" {
+		t.Fatalf("file is not framed by PAR1")
+	}
+	size := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	r := &thriftReader{b: b[len(b)-8-size : len(b)-8]}
+	meta := r.record()
+	if r.pos != size {
+		t.Fatalf("footer is %d bytes, the file metadata %d", size, r.pos)
+	}
+
+	if meta[1].(int64) != 1 {
+		t.Errorf("version is %d", meta[1])
+	}
+	if meta[3].(int64) != int64(len(records)) {
+		t.Errorf("%d rows, want %d", meta[3], len(records))
+	}
+	if meta[6].(string) != "geo-sqlite-dumper version test" {
+		t.Errorf("created by %q", meta[6])
+	}
+
+	// Name, physical type, converted type and logical type of each column
+	want := []struct {
+		name      string
+		kind      int64
+		converted interface{}
+		logical   thriftFields
+	}{
+		{"geometry", parquetByteArray, nil, nil},
+		{"time", parquetInt64, int64(parquetTimestampMicros),
+			thriftFields{8: thriftFields{1: true, 2: thriftFields{2: thriftFields{}}}}},
+		{"SOURCE_FILE_PATH", parquetByteArray, int64(parquetUTF8), thriftFields{1: thriftFields{}}},
+		{"SOURCE_TABLE", parquetByteArray, int64(parquetUTF8), thriftFields{1: thriftFields{}}},
+		{"id", parquetInt64, nil, nil},
+		{"name", parquetByteArray, int64(parquetUTF8), thriftFields{1: thriftFields{}}},
+		{"speed", parquetDouble, nil, nil},
+		{"ok", parquetBoolean, nil, nil},
+	}
+	schema := meta[2].([]interface{})
+	if root := schema[0].(thriftFields); root[4] != "schema" || root[5] != int64(len(want)) {
+		t.Errorf("schema root is %v", root)
+	}
+	if len(schema) != len(want)+1 {
+		t.Fatalf("%d schema elements, want %d", len(schema), len(want)+1)
+	}
+	for i, w := range want {
+		el := schema[i+1].(thriftFields)
+		if el[4] != w.name || el[1] != w.kind || el[3] != int64(parquetOptional) {
+			t.Errorf("column %d is %v, want %s of type %d", i, el, w.name, w.kind)
+		}
+		if el[6] != w.converted {
+			t.Errorf("converted type of %s is %v, want %v", w.name, el[6], w.converted)
+		}
+		if logical, _ := el[10].(thriftFields); !reflect.DeepEqual(logical, w.logical) {
+			t.Errorf("logical type of %s is %v, want %v", w.name, el[10], w.logical)
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 || groups[0].(thriftFields)[3] != int64(len(records)) {
+		t.Fatalf("row groups are %v", groups)
+	}
+	chunks := groups[0].(thriftFields)[1].([]interface{})
+	if len(chunks) != len(want) {
+		t.Fatalf("%d column chunks, want %d", len(chunks), len(want))
+	}
+
+	// Read the values back from the pages
+	defs, values := parquetValues(t, b, chunks[0].(thriftFields))
+	if !reflect.DeepEqual(defs, []bool{true, true, false}) {
+		t.Errorf("geometry definition levels are %v", defs)
+	}
+	n := int(binary.LittleEndian.Uint32(values))
+	g, err := wkb.Unmarshal(values[4 : 4+n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := g.(*geom.Point); !ok || p.X() != -77 || p.Y() != 38.9 {
+		t.Errorf("first geometry is %v", g)
+	}
+
+	defs, values = parquetValues(t, b, chunks[1].(thriftFields))
+	if !reflect.DeepEqual(defs, []bool{true, false, true}) {
+		t.Errorf("time definition levels are %v", defs)
+	}
+	if us := int64(binary.LittleEndian.Uint64(values)); us != start.UnixNano()/1000 {
+		t.Errorf("first time is %d, want %d", us, start.UnixNano()/1000)
+	}
+
+	_, values = parquetValues(t, b, chunks[4].(thriftFields))
+	for i := 0; i < len(records); i++ {
+		if id := int64(binary.LittleEndian.Uint64(values[8*i:])); id != int64(i+1) {
+			t.Errorf("id of row %d is %d", i, id)
+		}
+	}
+
+	defs, values = parquetValues(t, b, chunks[6].(thriftFields))
+	if !reflect.DeepEqual(defs, []bool{true, true, false}) {
+		t.Errorf("speed definition levels are %v", defs)
+	}
+	if f := math.Float64frombits(binary.LittleEndian.Uint64(values[8:])); f != 3 {
+		t.Errorf("speed of the second row is %v", f)
+	}
+
+	_, values = parquetValues(t, b, chunks[7].(thriftFields))
+	if values[0] != 0x05 {
+		t.Errorf("ok flags are %08b, want 00000101", values[0])
+	}
+
+	kv := meta[5].([]interface{})
+	if len(kv) != 1 || kv[0].(thriftFields)[1] != "geo" {
+		t.Fatalf("key values are %v", kv)
+	}
+	var geo struct {
+		Version       string                      `json:"version"`
+		PrimaryColumn string                      `json:"primary_column"`
+		Columns       map[string]geoParquetColumn `json:"columns"`
+	}
+	if err = json.Unmarshal([]byte(kv[0].(thriftFields)[2].(string)), &geo); err != nil {
+		t.Fatal(err)
+	}
+	if geo.Version != "1.0.0" || geo.PrimaryColumn != "geometry" {
+		t.Errorf("geo metadata is %+v", geo)
+	}
+	col := geo.Columns["geometry"]
+	if col.Encoding != "WKB" || !reflect.DeepEqual(col.GeometryTypes, []string{"Point", "Point Z"}) ||
+		!reflect.DeepEqual(col.Bbox, []float64{-77.1, 38.9, -77, 39}) {
+		t.Errorf("geometry column metadata is %+v", col)
+	}
+}
@@ -0,0 +1,344 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Profile maps the tables of one kind of database to the roles of their
+// columns, so the schema of a new app can be read without a code change
+type Profile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// Fingerprint is the tables, with some of their columns, which a
+	// database must have for the profile to be used on it.  A profile without
+	// one is used on every database.
+	Fingerprint map[string][]string `json:"fingerprint"`
+
+	Tables []*TableProfile `json:"tables"`
+}
+
+// TableProfile is the column roles of the tables matching a name pattern.
+// Only the latitude and longitude, a geometry or a protobuf point are needed,
+// the other roles are optional and the altitude and time are found from the column names when
+// left out.
+type TableProfile struct {
+	Table    string    `json:"table"` // glob pattern of the table names, case insensitive
+	Lat      ColumnRef `json:"lat"`
+	Lon      ColumnRef `json:"lon"`
+	Geometry ColumnRef `json:"geometry"` // WKB, GeoPackage, SpatiaLite, WKT or GeoJSON column
+	Alt      ColumnRef `json:"alt"`
+	Time     ColumnRef `json:"time"`
+	Epoch    string    `json:"epoch"` // epoch of the time column, detected when empty
+	Accuracy ColumnRef `json:"accuracy"`
+	Speed    ColumnRef `json:"speed"`
+	Course   ColumnRef `json:"course"`
+	Label    ColumnRef `json:"label"` // column used to name the points
+
+	// Protobuf is a blob column with the position in a protocol buffer, for
+	// apps which keep no position columns
+	Protobuf *ProtobufPoint `json:"protobuf"`
+
+	// Units of the mapped columns by role, such as {"lat": "e7", "speed":
+	// "km/h"}, or a number to multiply the values by
+	Units map[string]string `json:"units"`
+
+	// Join is a table left joined to the rows, such as the places of visits
+	Join *Join `json:"join"`
+
+	// Where limits the rows read, in SQL with the table as a and the joined
+	// table as b
+	Where string `json:"where"`
+
+	// Enums are the names of the codes of a column, written to a _DECODED
+	// column, such as {"ZKIND": {"0": "Photo", "1": "Video"}}
+	Enums map[string]map[string]string `json:"enums"`
+
+	// NoPosition are the values stored in both the latitude and longitude in
+	// place of a missing position
+	NoPosition []float64 `json:"no_position"`
+
+	// EventsOnly rows are only drawn as events, not as points
+	EventsOnly bool `json:"events_only"`
+
+	// Waypoints rows are single places, such as the locations of interest or
+	// where photos were taken, drawn as points without a path between them
+	// and written to GPX as waypoints
+	Waypoints bool `json:"waypoints"`
+
+	// Skip tables are not read at all, such as tables only used in a join
+	Skip bool `json:"skip"`
+
+	scales map[string]float64
+	enums  map[string]map[string]string
+}
+
+// ColumnRef is the name of a column, or in JSON a list of the names the
+// column goes by in different versions of an app, the first found is used
+type ColumnRef []string
+
+// UnmarshalJSON reads a single name or a list of them
+func (c *ColumnRef) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*c = ColumnRef{name}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(c))
+}
+
+// find returns the first of the names found in the columns, or -1
+func (c ColumnRef) find(clm_names []string) int {
+	for _, name := range c {
+		if i := findColumn(clm_names, name); i >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// Join is a table left joined on a column of the mapped table
+type Join struct {
+	Table string `json:"table"`
+	On    string `json:"on"`  // column of the mapped table
+	Key   string `json:"key"` // column of the joined table
+}
+
+// Profiles is a list of profiles, the first table pattern to match a table
+// is used
+type Profiles []*Profile
+
+// unitScales are the units known for each role, to degrees, meters, meters
+// per second and degrees
+var unitScales = map[string]map[string]float64{
+	"lat":      {"deg": 1, "e5": 1e-5, "e6": 1e-6, "e7": 1e-7, "rad": 180 / math.Pi},
+	"lon":      {"deg": 1, "e5": 1e-5, "e6": 1e-6, "e7": 1e-7, "rad": 180 / math.Pi},
+	"alt":      {"m": 1, "cm": 0.01, "mm": 0.001, "km": 1000, "ft": 0.3048},
+	"accuracy": {"m": 1, "cm": 0.01, "mm": 0.001, "km": 1000, "ft": 0.3048},
+	"speed": {"m/s": 1, "km/h": 1 / 3.6, "mph": 0.44704, "knots": 1852.0 / 3600,
+		"ft/s": 0.3048},
+	"course": {"deg": 1, "rad": 180 / math.Pi},
+}
+
+// LoadProfiles reads a JSON file of one profile or a list of them
+func LoadProfiles(r io.Reader) (Profiles, error) {
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var ret Profiles
+	if t := bytes.TrimSpace(buf); len(t) > 0 && t[0] == '[' {
+		err = json.Unmarshal(buf, &ret)
+	} else {
+		p := &Profile{}
+		err = json.Unmarshal(buf, p)
+		ret = Profiles{p}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading profile, %s", err)
+	}
+	for _, p := range ret {
+		if err = p.check(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// check makes sure the tables have a position and the units and epochs are
+// known
+func (p *Profile) check() error {
+	for _, t := range p.Tables {
+		if t.Table == "" || (!t.Skip && (len(t.Lat) == 0 || len(t.Lon) == 0) && len(t.Geometry) == 0 && t.Protobuf == nil) {
+			return fmt.Errorf("profile %q needs a table, lat and lon, a geometry or a protobuf for every table", p.Name)
+		}
+		if t.Protobuf != nil && (t.Protobuf.Column == "" || t.Protobuf.Lat == 0 || t.Protobuf.Lon == 0) {
+			return fmt.Errorf("profile %q protobuf of table %q needs a column, lat and lon", p.Name, t.Table)
+		}
+		if _, err := path.Match(strings.ToLower(t.Table), ""); err != nil {
+			return fmt.Errorf("profile %q table %q, %s", p.Name, t.Table, err)
+		}
+		if t.Epoch != "" {
+			t.Epoch = strings.ToLower(t.Epoch)
+			if !contains(Epochs, t.Epoch) {
+				return fmt.Errorf("profile %q unknown epoch %q, expected one of %s", p.Name, t.Epoch, strings.Join(Epochs, ", "))
+			}
+		}
+		if t.Join != nil && (t.Join.Table == "" || t.Join.On == "" || t.Join.Key == "") {
+			return fmt.Errorf("profile %q join on table %q needs a table, on and key", p.Name, t.Table)
+		}
+		t.enums = make(map[string]map[string]string)
+		for column, names := range t.Enums {
+			t.enums[strings.ToLower(column)] = names
+		}
+		t.scales = make(map[string]float64)
+		for role, unit := range t.Units {
+			units, ok := unitScales[role]
+			if !ok {
+				return fmt.Errorf("profile %q unknown role %q for units", p.Name, role)
+			}
+			scale, ok := units[strings.ToLower(unit)]
+			if !ok {
+				if scale, err := strconv.ParseFloat(unit, 64); err == nil {
+					t.scales[role] = scale
+					continue
+				}
+				var known []string
+				for u := range units {
+					known = append(known, u)
+				}
+				sort.Strings(known)
+				return fmt.Errorf("profile %q unknown %s unit %q, expected a number or one of %s", p.Name, role, unit, strings.Join(known, ", "))
+			}
+			t.scales[role] = scale
+		}
+	}
+	return nil
+}
+
+// Fits returns true if the database schema, the columns of every table, has
+// the fingerprint of the profile
+func (p *Profile) Fits(schema map[string][]string) bool {
+	for table, columns := range p.Fingerprint {
+		var have []string
+		for t, c := range schema {
+			if strings.EqualFold(t, table) {
+				have = c
+				break
+			}
+		}
+		if have == nil {
+			return false
+		}
+		for _, c := range columns {
+			if findColumn(have, c) < 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ForSchema returns the profiles which fit the database schema
+func (p Profiles) ForSchema(schema map[string][]string) Profiles {
+	var ret Profiles
+	for _, pr := range p {
+		if pr.Fits(schema) {
+			ret = append(ret, pr)
+		}
+	}
+	return ret
+}
+
+// Match returns the first table profile whose pattern matches the table and
+// whose position, geometry or protobuf columns are in the schema, in the
+// table or its join
+func (p Profiles) Match(table string, schema map[string][]string) (*Profile, *TableProfile) {
+	for _, pr := range p {
+		for _, t := range pr.Tables {
+			if ok, _ := path.Match(strings.ToLower(t.Table), strings.ToLower(table)); !ok {
+				continue
+			}
+			if t.Skip {
+				return pr, t
+			}
+			all := schema[table]
+			if j := t.joined(schema); j != "" {
+				all = append(append([]string{}, all...), schema[j]...)
+			}
+			if (t.Lat.find(all) >= 0 && t.Lon.find(all) >= 0) || t.Geometry.find(all) >= 0 || t.protobuf(all) >= 0 {
+				return pr, t
+			}
+		}
+	}
+	return nil, nil
+}
+
+// joined returns the table joined to the rows, when it is in the schema
+func (t *TableProfile) joined(schema map[string][]string) string {
+	if t.Join == nil {
+		return ""
+	}
+	if _, ok := schema[t.Join.Table]; !ok {
+		return ""
+	}
+	return t.Join.Table
+}
+
+// protobuf returns where the protobuf column is in the columns, or -1
+func (t *TableProfile) protobuf(clm_names []string) int {
+	if t.Protobuf == nil {
+		return -1
+	}
+	return findColumn(clm_names, t.Protobuf.Column)
+}
+
+// scale returns the factor to convert a role to the common units
+func (t *TableProfile) scale(role string) float64 {
+	if s, ok := t.scales[role]; ok {
+		return s
+	}
+	return 1
+}
+
+// decode returns the name of the code in a column, if the profile has one
+func (t *TableProfile) decode(column string, v interface{}) (string, bool) {
+	names, ok := t.enums[strings.ToLower(column)]
+	if !ok || v == nil {
+		return "", false
+	}
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	name, ok := names[fmt.Sprintf("%v", v)]
+	return name, ok
+}
+
+// noPosition returns true if the coordinate is a stand in for a missing
+// position, the value in both columns so a real fix on it in one is kept
+func (t *TableProfile) noPosition(lat, lon float64) bool {
+	for _, v := range t.NoPosition {
+		if lat == v && lon == v {
+			return true
+		}
+	}
+	return false
+}
+
+// toFloat reads a number out of a column value
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(strings.TrimSpace(string(n)), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,365 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-geom"
+)
+
+// projection converts the coordinates of a projected CRS into WGS84
+// longitude and latitude in degrees.  The datum shift is left out, which is
+// within a few meters for the modern datums and within a hundred or so for
+// the old ones.
+type projection func(x, y float64) (lon, lat float64)
+
+// ellipsoid is the semi-major axis and flattening of a datum
+type ellipsoid struct {
+	a, f float64
+}
+
+var (
+	wgs84 = ellipsoid{6378137, 1 / 298.257223563}
+	grs80 = ellipsoid{6378137, 1 / 298.257222101}
+)
+
+// e2 is the square of the eccentricity
+func (el ellipsoid) e2() float64 {
+	return el.f * (2 - el.f)
+}
+
+// epsgProjection returns the projection of the common EPSG codes, ok is
+// false for codes which are not known
+func epsgProjection(srid int) (proj projection, ok bool) {
+	switch {
+	case srid == 0 || srid == 4326 || srid == 4269 || srid == 4258 ||
+		srid == 4283 || srid == 4617 || srid == 4979 || srid == 4230:
+		// Geographic in degrees, 0 is the undefined geographic CRS of a
+		// GeoPackage
+		return nil, true
+	case srid == 3857 || srid == 900913 || srid == 3785 || srid == 102100:
+		return pseudoMercator(wgs84.a), true
+	case srid == 3395:
+		return mercator(wgs84, 0, 1, 0, 0, 1), true
+	case srid > 32600 && srid <= 32660:
+		return utm(wgs84, srid-32600, false), true
+	case srid > 32700 && srid <= 32760:
+		return utm(wgs84, srid-32700, true), true
+	case srid >= 25828 && srid <= 25838:
+		// ETRS89
+		return utm(grs80, srid-25800, false), true
+	case srid >= 26901 && srid <= 26923:
+		// NAD83
+		return utm(grs80, srid-26900, false), true
+	}
+	return nil, false
+}
+
+var (
+	wktParameter = regexp.MustCompile(`(?i)PARAMETER\s*\[\s*"([^"]+)"\s*,\s*([-+0-9.eE]+)`)
+	wktSpheroid  = regexp.MustCompile(`(?i)(?:SPHEROID|ELLIPSOID)\s*\[\s*"[^"]*"\s*,\s*([-+0-9.eE]+)\s*,\s*([-+0-9.eE]+)`)
+	wktUnit      = regexp.MustCompile(`(?i)UNIT\s*\[\s*"[^"]*"\s*,\s*([-+0-9.eE]+)`)
+	wktMethod    = regexp.MustCompile(`(?i)(?:PROJECTION|METHOD)\s*\[\s*"([^"]+)"`)
+	wktDatum     = regexp.MustCompile(`(?i)DATUM\s*\[\s*"([^"]+)"`)
+)
+
+// oldDatum returns the name of the datum of a CRS when it is an old one,
+// not on the WGS84 or GRS80 ellipsoid of WGS84, NAD83, ETRS89 and the other
+// modern datums, which are within a meter or two of each other.  The old ones
+// are read as WGS84 without the datum shift.
+func oldDatum(srid int, def string) string {
+	if srid == 4230 {
+		return "ED50"
+	}
+	s := wktSpheroid.FindStringSubmatch(def)
+	if s == nil {
+		return ""
+	}
+	if a, err := strconv.ParseFloat(s[1], 64); err != nil || a == wgs84.a {
+		return ""
+	}
+	if m := wktDatum.FindStringSubmatch(def); m != nil {
+		return m[1]
+	}
+	return "unknown"
+}
+
+// wktProjection reads the projection out of the WKT definition of a CRS, for
+// the transverse mercator, mercator and lambert conformal conic methods
+func wktProjection(def string) (projection, error) {
+	def = strings.TrimSpace(def)
+	upper := strings.ToUpper(def)
+	if strings.HasPrefix(upper, "GEOGCS") || strings.HasPrefix(upper, "GEOGCRS") || strings.HasPrefix(upper, "GEODCRS") {
+		return nil, nil
+	}
+	if !strings.HasPrefix(upper, "PROJCS") && !strings.HasPrefix(upper, "PROJCRS") {
+		return nil, fmt.Errorf("unknown CRS definition %.40q", def)
+	}
+	m := wktMethod.FindStringSubmatch(def)
+	if m == nil {
+		return nil, fmt.Errorf("no projection in CRS definition %.40q", def)
+	}
+	el := wgs84
+	if s := wktSpheroid.FindStringSubmatch(def); s != nil {
+		a, _ := strconv.ParseFloat(s[1], 64)
+		invf, _ := strconv.ParseFloat(s[2], 64)
+		if a > 0 {
+			el = ellipsoid{a, 0}
+			if invf > 0 {
+				el.f = 1 / invf
+			}
+		}
+	}
+	params := make(map[string]float64)
+	for _, p := range wktParameter.FindAllStringSubmatch(def, -1) {
+		name := strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(p[1]))
+		params[name], _ = strconv.ParseFloat(p[2], 64)
+	}
+	param := func(def float64, names ...string) float64 {
+		for _, n := range names {
+			if v, ok := params[n]; ok {
+				return v
+			}
+		}
+		return def
+	}
+	// The linear unit is the last one, after the degrees of the GEOGCS
+	unit := 1.0
+	if u := wktUnit.FindAllStringSubmatch(def, -1); len(u) > 0 {
+		if v, err := strconv.ParseFloat(u[len(u)-1][1], 64); err == nil && v > 0 {
+			unit = v
+		}
+	}
+	lat0 := param(0, "latitude_of_origin", "latitude_of_natural_origin", "latitude_of_false_origin", "latitude_of_center")
+	lon0 := param(0, "central_meridian", "longitude_of_natural_origin", "longitude_of_false_origin", "longitude_of_center")
+	k0 := param(1, "scale_factor", "scale_factor_at_natural_origin")
+	fe := param(0, "false_easting", "easting_at_false_origin")
+	fn := param(0, "false_northing", "northing_at_false_origin")
+
+	method := strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(m[1]))
+	switch {
+	case strings.Contains(method, "pseudo_mercator") || strings.Contains(method, "popular_visualisation") ||
+		strings.Contains(method, "auxiliary_sphere"):
+		return scaled(pseudoMercator(el.a), unit, fe, fn), nil
+	case strings.Contains(method, "transverse_mercator"):
+		return transverseMercator(el, lat0, lon0, k0, fe*unit, fn*unit, unit), nil
+	case strings.HasPrefix(method, "mercator"):
+		if lat1, ok := params["standard_parallel_1"]; ok {
+			phi := lat1 * math.Pi / 180
+			k0 = math.Cos(phi) / math.Sqrt(1-el.e2()*math.Pow(math.Sin(phi), 2))
+		}
+		return mercator(el, lon0, k0, fe*unit, fn*unit, unit), nil
+	case strings.HasPrefix(method, "lambert_conformal_conic"):
+		lat1 := param(lat0, "standard_parallel_1", "latitude_of_1st_standard_parallel")
+		lat2 := param(lat1, "standard_parallel_2", "latitude_of_2nd_standard_parallel")
+		return lambertConformal(el, lat0, lon0, lat1, lat2, k0, fe*unit, fn*unit, unit), nil
+	}
+	return nil, fmt.Errorf("unsupported projection %q", m[1])
+}
+
+// scaled converts the units and false origin before a projection in meters
+func scaled(proj projection, unit, fe, fn float64) projection {
+	return func(x, y float64) (float64, float64) {
+		return proj((x-fe)*unit, (y-fn)*unit)
+	}
+}
+
+// pseudoMercator is the spherical mercator of the web maps
+func pseudoMercator(a float64) projection {
+	return func(x, y float64) (float64, float64) {
+		lon := x / a * 180 / math.Pi
+		lat := (math.Pi/2 - 2*math.Atan(math.Exp(-y/a))) * 180 / math.Pi
+		return lon, lat
+	}
+}
+
+// isometricLatitude solves for the latitude of the ts of the mercator and
+// lambert projections
+func isometricLatitude(e, ts float64) float64 {
+	phi := math.Pi/2 - 2*math.Atan(ts)
+	for i := 0; i < 15; i++ {
+		es := e * math.Sin(phi)
+		next := math.Pi/2 - 2*math.Atan(ts*math.Pow((1-es)/(1+es), e/2))
+		if math.Abs(next-phi) < 1e-12 {
+			return next
+		}
+		phi = next
+	}
+	return phi
+}
+
+// mercator is the ellipsoidal mercator, with the false origin in the units
+// of the CRS already converted to meters
+func mercator(el ellipsoid, lon0, k0, fe, fn, unit float64) projection {
+	e := math.Sqrt(el.e2())
+	return func(x, y float64) (float64, float64) {
+		x, y = x*unit-fe, y*unit-fn
+		lon := lon0 + x/(el.a*k0)*180/math.Pi
+		lat := isometricLatitude(e, math.Exp(-y/(el.a*k0))) * 180 / math.Pi
+		return lon, lat
+	}
+}
+
+// meridianArc is the distance along the meridian from the equator
+func meridianArc(el ellipsoid, phi float64) float64 {
+	e2 := el.e2()
+	e4, e6 := e2*e2, e2*e2*e2
+	return el.a * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+		(35*e6/3072)*math.Sin(6*phi))
+}
+
+// utm is a zone of the universal transverse mercator
+func utm(el ellipsoid, zone int, south bool) projection {
+	fn := 0.0
+	if south {
+		fn = 10000000
+	}
+	return transverseMercator(el, 0, float64(zone*6-183), 0.9996, 500000, fn, 1)
+}
+
+// transverseMercator is the inverse of Snyder's series for the transverse
+// mercator, with the false origin in meters
+func transverseMercator(el ellipsoid, lat0, lon0, k0, fe, fn, unit float64) projection {
+	e2 := el.e2()
+	ep2 := e2 / (1 - e2)
+	m0 := meridianArc(el, lat0*math.Pi/180)
+	se := math.Sqrt(1 - e2)
+	e1 := (1 - se) / (1 + se)
+	return func(x, y float64) (float64, float64) {
+		x, y = x*unit-fe, y*unit-fn
+		m := m0 + y/k0
+		mu := m / (el.a * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+		phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+			(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+			(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+			(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+		sin, cos, tan := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+		c1 := ep2 * cos * cos
+		t1 := tan * tan
+		n1 := el.a / math.Sqrt(1-e2*sin*sin)
+		r1 := el.a * (1 - e2) / math.Pow(1-e2*sin*sin, 1.5)
+		d := x / (n1 * k0)
+		lat := phi1 - (n1*tan/r1)*(d*d/2-
+			(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+			(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+		lon := (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+			(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cos
+		return lon0 + lon*180/math.Pi, lat * 180 / math.Pi
+	}
+}
+
+// lambertConformal is the inverse of the lambert conformal conic with one or
+// two standard parallels, with the false origin in meters
+func lambertConformal(el ellipsoid, lat0, lon0, lat1, lat2, k0, fe, fn, unit float64) projection {
+	e := math.Sqrt(el.e2())
+	rad := math.Pi / 180
+	m := func(phi float64) float64 {
+		return math.Cos(phi) / math.Sqrt(1-el.e2()*math.Pow(math.Sin(phi), 2))
+	}
+	t := func(phi float64) float64 {
+		es := e * math.Sin(phi)
+		return math.Tan(math.Pi/4-phi/2) / math.Pow((1-es)/(1+es), e/2)
+	}
+	phi0, phi1, phi2 := lat0*rad, lat1*rad, lat2*rad
+	n := math.Sin(phi1)
+	if lat1 != lat2 {
+		n = (math.Log(m(phi1)) - math.Log(m(phi2))) / (math.Log(t(phi1)) - math.Log(t(phi2)))
+	}
+	f := m(phi1) / (n * math.Pow(t(phi1), n))
+	rho0 := el.a * f * math.Pow(t(phi0), n) * k0
+	return func(x, y float64) (float64, float64) {
+		x, y = x*unit-fe, rho0-(y*unit-fn)
+		sign := 1.0
+		if n < 0 {
+			sign = -1
+		}
+		rho := sign * math.Hypot(x, y)
+		theta := math.Atan2(sign*x, sign*y)
+		ts := math.Pow(rho/(el.a*f*k0), 1/n)
+		lat := isometricLatitude(e, ts)
+		return lon0 + theta/n/rad, lat / rad
+	}
+}
+
+// projector finds the projection of each SRID in a file once, from the
+// common EPSG codes or the definitions in the file
+type projector struct {
+	defs  map[int]string
+	projs map[int]projection
+	errs  map[int]error
+}
+
+func newProjector(defs map[int]string) *projector {
+	return &projector{defs: defs, projs: make(map[int]projection), errs: make(map[int]error)}
+}
+
+// datum returns the name of the datum of the SRID when it is an old one,
+// whose positions are off by up to a few hundred meters without the shift
+func (p *projector) datum(srid int) string {
+	if _, ok := epsgProjection(srid); ok {
+		return oldDatum(srid, "")
+	}
+	return oldDatum(srid, p.defs[srid])
+}
+
+// get returns the projection of the SRID, nil when the coordinates are
+// already in degrees
+func (p *projector) get(srid int) (projection, error) {
+	if proj, ok := p.projs[srid]; ok {
+		return proj, p.errs[srid]
+	}
+	proj, ok := epsgProjection(srid)
+	var err error
+	if !ok {
+		def, found := p.defs[srid]
+		switch {
+		case !found && srid < 0:
+			err = fmt.Errorf("undefined cartesian CRS %d", srid)
+		case !found:
+			err = fmt.Errorf("unknown CRS %d", srid)
+		default:
+			proj, err = wktProjection(def)
+		}
+	}
+	p.projs[srid], p.errs[srid] = proj, err
+	return proj, err
+}
+
+// inDegrees returns true if the coordinates of the geometry could be
+// longitudes and latitudes
+func inDegrees(g geom.T) bool {
+	b := g.Bounds()
+	return b.Min(0) >= -180 && b.Max(0) <= 180 && b.Min(1) >= -90 && b.Max(1) <= 90
+}
+
+// reproject converts the coordinates of a geometry in place
+func reproject(g geom.T, proj projection) {
+	if gc, ok := g.(*geom.GeometryCollection); ok {
+		for _, part := range gc.Geoms() {
+			reproject(part, proj)
+		}
+		return
+	}
+	flat := g.FlatCoords()
+	stride := g.Stride()
+	for i := 0; i+1 < len(flat); i += stride {
+		flat[i], flat[i+1] = proj(flat[i], flat[i+1])
+	}
+}
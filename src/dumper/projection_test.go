@@ -0,0 +1,138 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Within about a centimeter, the published coordinates are to the
+// centimeter
+const projTolerance = 2e-7
+
+func checkProjection(t *testing.T, name string, proj projection, x, y, lon, lat float64) {
+	t.Helper()
+	if proj == nil {
+		t.Errorf("%s has no projection", name)
+		return
+	}
+	got_lon, got_lat := proj(x, y)
+	if math.Abs(got_lon-lon) > projTolerance || math.Abs(got_lat-lat) > projTolerance {
+		t.Errorf("%s %v, %v is at %.9f, %.9f, want %.9f, %.9f", name, x, y, got_lon, got_lat, lon, lat)
+	}
+}
+
+func TestEPSGProjection(t *testing.T) {
+	for _, tc := range []struct {
+		srid     int
+		x, y     float64
+		lon, lat float64
+	}{
+		// The utm zone 32 test of PROJ, on GRS80 which is within a
+		// tenth of a millimeter of WGS84 here
+		{32632, 691875.632139661, 6098907.825005012, 12, 55},
+		{25832, 691875.632139661, 6098907.825005012, 12, 55},
+		// The Brandenburg Gate and Cape Town, worked out with Karney's
+		// series for the transverse mercator
+		{32633, 389917.833, 5819701.919, 13.3777, 52.5163},
+		{32734, 259583.222, 6245888.045, 18.4, -33.9},
+		// The central meridians on the equator
+		{32633, 500000, 0, 15, 0},
+		{32760, 500000, 10000000, 177, 0},
+		// The Pseudo-Mercator example of EPSG guidance note 7-2
+		{3857, -11169055.58, 2800000.00, -100.333333333, 24.381786944},
+	} {
+		proj, ok := epsgProjection(tc.srid)
+		if !ok {
+			t.Errorf("EPSG:%d is not known", tc.srid)
+			continue
+		}
+		checkProjection(t, fmt.Sprintf("EPSG:%d", tc.srid), proj, tc.x, tc.y, tc.lon, tc.lat)
+	}
+	if proj, ok := epsgProjection(4326); !ok || proj != nil {
+		t.Errorf("EPSG:4326 is projected")
+	}
+	if _, ok := epsgProjection(2056); ok {
+		t.Errorf("EPSG:2056 is known")
+	}
+}
+
+// The examples of EPSG guidance note 7-2 for each method
+func TestWKTProjection(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		def      string
+		x, y     float64
+		lon, lat float64
+	}{
+		{"OSGB 1936 / British National Grid", `PROJCS["OSGB 1936 / British National Grid",
+			GEOGCS["OSGB 1936",DATUM["OSGB_1936",SPHEROID["Airy 1830",6377563.396,299.3249646]],
+				PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],
+			PROJECTION["Transverse_Mercator"],PARAMETER["latitude_of_origin",49],
+			PARAMETER["central_meridian",-2],PARAMETER["scale_factor",0.9996012717],
+			PARAMETER["false_easting",400000],PARAMETER["false_northing",-100000],UNIT["metre",1]]`,
+			577274.99, 69740.49, 0.5, 50.5},
+		{"NAD27 / Texas South Central", `PROJCS["NAD27 / Texas South Central",
+			GEOGCS["NAD27",DATUM["North_American_Datum_1927",SPHEROID["Clarke 1866",6378206.4,294.9786982138982]],
+				PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],
+			PROJECTION["Lambert_Conformal_Conic_2SP"],PARAMETER["standard_parallel_1",28.38333333333333],
+			PARAMETER["standard_parallel_2",30.28333333333333],PARAMETER["latitude_of_origin",27.83333333333333],
+			PARAMETER["central_meridian",-99],PARAMETER["false_easting",2000000],PARAMETER["false_northing",0],
+			UNIT["US survey foot",0.3048006096012192]]`,
+			2963503.91, 254759.80, -96, 28.5},
+		{"JAD69 / Jamaica National Grid", `PROJCS["JAD69 / Jamaica National Grid",
+			GEOGCS["JAD69",DATUM["Jamaica_1969",SPHEROID["Clarke 1866",6378206.4,294.9786982138982]],
+				PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],
+			PROJECTION["Lambert_Conformal_Conic_1SP"],PARAMETER["latitude_of_origin",18],
+			PARAMETER["central_meridian",-77],PARAMETER["scale_factor",1],
+			PARAMETER["false_easting",250000],PARAMETER["false_northing",150000],UNIT["metre",1]]`,
+			255966.58, 142493.51, -76.943683333, 17.932166667},
+		{"Makassar / NEIEZ", `PROJCS["Makassar / NEIEZ",
+			GEOGCS["Makassar",DATUM["Makassar",SPHEROID["Bessel 1841",6377397.155,299.1528128]],
+				PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],
+			PROJECTION["Mercator_1SP"],PARAMETER["central_meridian",110],PARAMETER["scale_factor",0.997],
+			PARAMETER["false_easting",3900000],PARAMETER["false_northing",900000],UNIT["metre",1]]`,
+			5009726.58, 569150.82, 120, -3},
+		{"Pulkovo 1942 / Caspian Sea Mercator", `PROJCS["Pulkovo 1942 / Caspian Sea Mercator",
+			GEOGCS["Pulkovo 1942",DATUM["Pulkovo_1942",SPHEROID["Krassowsky 1940",6378245,298.3]],
+				PRIMEM["Greenwich",0],UNIT["degree",0.0174532925199433]],
+			PROJECTION["Mercator_2SP"],PARAMETER["standard_parallel_1",42],PARAMETER["central_meridian",51],
+			PARAMETER["false_easting",0],PARAMETER["false_northing",0],UNIT["metre",1]]`,
+			165704.29, 5171848.07, 53, 53},
+	} {
+		proj, err := wktProjection(tc.def)
+		if err != nil {
+			t.Errorf("%s, %s", tc.name, err)
+			continue
+		}
+		checkProjection(t, tc.name, proj, tc.x, tc.y, tc.lon, tc.lat)
+	}
+}
+
+func TestOldDatum(t *testing.T) {
+	p := newProjector(map[int]string{
+		27700:  `PROJCS["OSGB 1936 / British National Grid",GEOGCS["OSGB 1936",DATUM["OSGB_1936",SPHEROID["Airy 1830",6377563.396,299.3249646]]]]`,
+		2263:   `PROJCS["NAD83 / New York Long Island (ftUS)",GEOGCS["NAD83",DATUM["North_American_Datum_1983",SPHEROID["GRS 1980",6378137,298.257222101]]]]`,
+		102100: `PROJCS["WGS_1984_Web_Mercator_Auxiliary_Sphere",GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137,298.257223563]]]]`,
+		3003:   `PROJCS["Monte Mario / Italy zone 1",GEOGCS["Monte Mario",DATUM["D_Monte_Mario",SPHEROID["International_1924",6378388,297]]]]`,
+		2154:   `PROJCS["RGF93 / Lambert-93",GEOGCS["RGF93",DATUM["Reseau_Geodesique_Francais_1993",SPHEROID["GRS 1980",6378137,298.257222101]]]]`,
+	})
+	for srid, want := range map[int]string{27700: "OSGB_1936", 2263: "", 102100: "", 3003: "D_Monte_Mario", 2154: "",
+		4230: "ED50", 4326: "", 32633: "", 25832: "", 9999: ""} {
+		if got := p.datum(srid); got != want {
+			t.Errorf("datum of %d is %q, want %q", srid, got, want)
+		}
+	}
+}
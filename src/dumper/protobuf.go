@@ -0,0 +1,137 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Wire types of the protocol buffer encoding
+const (
+	pbVarint  = 0
+	pbFixed64 = 1
+	pbBytes   = 2
+	pbFixed32 = 5
+)
+
+// pbField is a field of a protocol buffer message, with the value of the
+// fixed and varint types in num and of the length delimited ones in data
+type pbField struct {
+	id   uint64
+	kind int
+	num  uint64
+	data []byte
+}
+
+// pbFields splits a protocol buffer message into its fields, false when the
+// bytes are not a message
+func pbFields(b []byte) ([]pbField, bool) {
+	var ret []pbField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 || key>>3 == 0 {
+			return nil, false
+		}
+		b = b[n:]
+		f := pbField{id: key >> 3, kind: int(key & 7)}
+		switch f.kind {
+		case pbVarint:
+			if f.num, n = binary.Uvarint(b); n <= 0 {
+				return nil, false
+			}
+		case pbFixed64:
+			if n = 8; len(b) < n {
+				return nil, false
+			}
+			f.num = binary.LittleEndian.Uint64(b)
+		case pbFixed32:
+			if n = 4; len(b) < n {
+				return nil, false
+			}
+			f.num = uint64(binary.LittleEndian.Uint32(b))
+		case pbBytes:
+			size, m := binary.Uvarint(b)
+			if m <= 0 || size > uint64(len(b)-m) {
+				return nil, false
+			}
+			f.data = b[m : m+int(size)]
+			n = m + int(size)
+		default:
+			return nil, false
+		}
+		b = b[n:]
+		ret = append(ret, f)
+	}
+	return ret, true
+}
+
+// ProtobufPoint is where a position is in a protocol buffer blob, the field
+// numbers of the latitude and longitude doubles of a message nested anywhere
+// in it, as in the LatLng messages of Google Maps
+type ProtobufPoint struct {
+	Column string `json:"column"`
+	Lat    uint64 `json:"lat"`
+	Lon    uint64 `json:"lon"`
+}
+
+// Find returns the first position in the blob, depth first.  The blobs of
+// some Google apps have a short header in front of the message, so when the
+// blob is not a message it is read from its first field 1.
+func (p *ProtobufPoint) Find(b []byte) (lat, lon float64, ok bool) {
+	fields, ok := pbFields(b)
+	if !ok {
+		i := bytes.IndexByte(b, 1<<3|pbBytes)
+		if i <= 0 {
+			return 0, 0, false
+		}
+		if fields, ok = pbFields(b[i:]); !ok {
+			return 0, 0, false
+		}
+	}
+	return p.find(fields, 0)
+}
+
+// find looks in the fields of a message and then in the ones nested in it
+func (p *ProtobufPoint) find(fields []pbField, depth int) (lat, lon float64, ok bool) {
+	var has_lat, has_lon bool
+	for _, f := range fields {
+		if f.kind != pbFixed64 {
+			continue
+		}
+		switch f.id {
+		case p.Lat:
+			lat, has_lat = math.Float64frombits(f.num), true
+		case p.Lon:
+			lon, has_lon = math.Float64frombits(f.num), true
+		}
+	}
+	if has_lat && has_lon && math.Abs(lat) <= 90 && math.Abs(lon) <= 180 && (lat != 0 || lon != 0) {
+		return lat, lon, true
+	}
+	if depth > 32 {
+		return 0, 0, false
+	}
+	for _, f := range fields {
+		if f.kind != pbBytes {
+			continue
+		}
+		if nested, ok := pbFields(f.data); ok {
+			if lat, lon, ok = p.find(nested, depth+1); ok {
+				return lat, lon, true
+			}
+		}
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,74 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// pbMessage builds a protocol buffer message out of encoded fields
+func pbMessage(fields ...[]byte) []byte {
+	var ret []byte
+	for _, f := range fields {
+		ret = append(ret, f...)
+	}
+	return ret
+}
+
+func pbKey(id uint64, kind int, v uint64) []byte {
+	b := make([]byte, 2*binary.MaxVarintLen64)
+	n := binary.PutUvarint(b, id<<3|uint64(kind))
+	return b[:n+binary.PutUvarint(b[n:], v)]
+}
+
+func pbDouble(id uint64, v float64) []byte {
+	b := make([]byte, binary.MaxVarintLen64+8)
+	n := binary.PutUvarint(b, id<<3|pbFixed64)
+	binary.LittleEndian.PutUint64(b[n:], math.Float64bits(v))
+	return b[:n+8]
+}
+
+func pbString(id uint64, v []byte) []byte {
+	return append(pbKey(id, pbBytes, uint64(len(v))), v...)
+}
+
+func pbInt(id uint64, v uint64) []byte {
+	return pbKey(id, pbVarint, v)
+}
+
+func TestProtobufPoint(t *testing.T) {
+	p := &ProtobufPoint{Column: "_data", Lat: 3, Lon: 4}
+	place := pbMessage(pbString(1, []byte("White House")),
+		pbString(2, pbMessage(pbInt(1, 7), pbDouble(3, 500), pbDouble(4, 0.5))),
+		pbString(8, pbMessage(pbDouble(3, 38.8977), pbDouble(4, -77.0365))))
+	blob := pbMessage(pbInt(1, 1), pbString(4, place))
+
+	for _, tc := range []struct {
+		name string
+		blob []byte
+		ok   bool
+	}{
+		{"nested", blob, true},
+		{"after a header", append([]byte{0x01, 0x00, 0x02}, pbMessage(pbString(1, place))...), true},
+		{"latitude out of range", pbMessage(pbDouble(3, 500), pbDouble(4, 0.5)), false},
+		{"no longitude", pbMessage(pbDouble(3, 38.8977)), false},
+		{"not a message", []byte("text"), false},
+	} {
+		lat, lon, ok := p.Find(tc.blob)
+		if ok != tc.ok || (ok && (lat != 38.8977 || lon != -77.0365)) {
+			t.Errorf("%s found %v, %v, %v", tc.name, lat, lon, ok)
+		}
+	}
+}
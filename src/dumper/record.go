@@ -0,0 +1,77 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"time"
+
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-kml"
+)
+
+// contains checks if a string is present in a slice
+func contains(s []string, str string) bool {
+	for _, v := range s {
+		if v == str {
+			return true
+		}
+	}
+	return false
+}
+
+// Record is one row read from a table, with the position and time found in
+// its columns and where it was read from
+type Record struct {
+	Coords   *kml.Coordinate // nil when the row has no position
+	Geometry geom.T          // shape of a geometry column, nil for a plain position
+	Time     time.Time       // zero when the row has no date column
+	Local    time.Time       // civil time where the row was, zero unless asked for
+	Label    string          // name from the label column of a profile, if any
+	Waypoint bool            // a single place rather than a point along a track
+	Data     map[string]interface{}
+	Columns  []string // keys of Data in the order they were read
+	File     string   // source file path
+	Table    string   // source table, empty for a custom query
+	Row      int      // row number within the table
+	Rowid    int64    // rowid of the row in its table, 0 when it has none
+}
+
+// localTime returns the civil time where the record was when it was found,
+// otherwise the time of the record
+func (r *Record) localTime() time.Time {
+	if !r.Local.IsZero() {
+		return r.Local
+	}
+	return r.Time
+}
+
+// Event is a series of records which are within the event time of each other
+type Event struct {
+	File      string
+	Table     string
+	Joined    string // table left joined to the rows, if any
+	Waypoints bool   // the records are single places, not a track
+	Records   []*Record
+	Dist      float64 // distance covered in meters
+	Alt       float64 // sum of the altitudes, for the mean
+}
+
+// build a slice with the coordinates of the records which have a position
+func coords(elms []*Record) (ret []kml.Coordinate) {
+	for _, e := range elms {
+		if e.Coords != nil {
+			ret = append(ret, *e.Coords)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,299 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/twpayne/go-kml"
+)
+
+// Super-overlay tuning
+const (
+	regionMaxDepth  = 24  // deepest tile level, stops runaway splits on stacked points
+	regionGrid      = 16  // thinning grid cells per tile side
+	regionMinPixels = 128 // tile is shown once it covers this many pixels
+	regionMaxPixels = 512 // thinned points are hidden once the children take over
+)
+
+type regionBox struct {
+	north, south, east, west float64
+}
+
+// quadrant returns the i-th quarter of the box, 0=NW 1=NE 2=SW 3=SE
+func (b regionBox) quadrant(i int) regionBox {
+	mid_lat := (b.north + b.south) / 2
+	mid_lon := (b.east + b.west) / 2
+	q := b
+	if i < 2 {
+		q.south = mid_lat
+	} else {
+		q.north = mid_lat
+	}
+	if i%2 == 0 {
+		q.east = mid_lon
+	} else {
+		q.west = mid_lon
+	}
+	return q
+}
+
+// index returns which quadrant of the box the coordinate falls in
+func (b regionBox) index(c *kml.Coordinate) int {
+	i := 0
+	if c.Lat < (b.north+b.south)/2 {
+		i += 2
+	}
+	if c.Lon >= (b.east+b.west)/2 {
+		i++
+	}
+	return i
+}
+
+// region builds the KML Region for the box
+func (b regionBox) region(min_pixels, max_pixels float64) kml.Element {
+	return kml.Region(
+		kml.LatLonAltBox(
+			kml.North(b.north),
+			kml.South(b.south),
+			kml.East(b.east),
+			kml.West(b.west),
+		),
+		kml.LOD(
+			kml.MinLODPixels(min_pixels),
+			kml.MaxLODPixels(max_pixels),
+		),
+	)
+}
+
+// regionWriter builds the tiles of a super-overlay into a KMZ archive
+type regionWriter struct {
+	zw   *zip.Writer
+	name string
+	b    *KMLBuilder
+	max  int
+}
+
+// tileName is the archive path of a tile
+func tileName(z, x, y int) string {
+	return fmt.Sprintf("tile_%d_%d_%d.kml", z, x, y)
+}
+
+// placemark builds the point for an entry, count is the number of points the
+// entry stands in for on a thinned tile
+func (r *regionWriter) placemark(e *Record, count int) kml.Element {
+	if count > 1 {
+		// A thinned point stands in for many times, so it is left out of the
+		// time slider, and for many shapes, so it is only drawn as a point
+		pt := *e
+		pt.Geometry = nil
+		return r.b.placemark(&pt, fmt.Sprintf("%d points", count), "", false)
+	}
+	return r.b.placemark(e, entryTitle(e), "", true)
+}
+
+// thin keeps the first point in every grid cell of the box, counting how many
+// points fell into each cell
+func thin(entries []*Record, b regionBox) (keep []*Record, counts []int) {
+	cells := make(map[int]int)
+	for _, e := range entries {
+		cx := int(regionGrid * (e.Coords.Lon - b.west) / (b.east - b.west))
+		cy := int(regionGrid * (b.north - e.Coords.Lat) / (b.north - b.south))
+		cell := cy*(regionGrid+1) + cx
+		if i, ok := cells[cell]; ok {
+			counts[i]++
+			continue
+		}
+		cells[cell] = len(keep)
+		keep = append(keep, e)
+		counts = append(counts, 1)
+	}
+	return
+}
+
+// tile writes a tile and, when it has too many points to show, its children
+func (r *regionWriter) tile(entries []*Record, b regionBox, z, x, y int) error {
+	leaf := len(entries) <= r.max || z >= regionMaxDepth
+
+	var points []kml.Element
+	// The top tile is always shown so there is an overview when zoomed out
+	min_pixels := float64(regionMinPixels)
+	if z == 0 {
+		min_pixels = 0
+	}
+	max_pixels := -1.0
+	if leaf {
+		for _, e := range entries {
+			points = append(points, r.placemark(e, 1))
+		}
+	} else {
+		max_pixels = regionMaxPixels
+		keep, counts := thin(entries, b)
+		for i, e := range keep {
+			points = append(points, r.placemark(e, counts[i]))
+		}
+	}
+
+	elements := append([]kml.Element{kml.Name(fmt.Sprintf("%s %d/%d/%d", r.name, z, x, y))},
+		r.b.styles.elements(kmzPointIcon)...)
+	elements = append(elements, r.b.schemas.elements()...)
+	elements = append(elements, kml.Folder(
+		append([]kml.Element{
+			kml.Name(fmt.Sprintf("Points (%d)", len(entries))),
+			b.region(min_pixels, max_pixels),
+		}, points...)...,
+	))
+
+	var quads [4][]*Record
+	if !leaf {
+		for _, e := range entries {
+			i := b.index(e.Coords)
+			quads[i] = append(quads[i], e)
+		}
+		for i, q := range quads {
+			if len(q) == 0 {
+				continue
+			}
+			cx, cy := 2*x+i%2, 2*y+i/2
+			elements = append(elements, kml.NetworkLink(
+				kml.Name(fmt.Sprintf("%d/%d/%d", z+1, cx, cy)),
+				b.quadrant(i).region(regionMinPixels, -1),
+				kml.Link(
+					kml.Href(tileName(z+1, cx, cy)),
+					kml.ViewRefreshMode(kml.ViewRefreshModeOnRegion),
+				),
+			))
+		}
+	}
+
+	f, err := r.zw.Create(tileName(z, x, y))
+	if err != nil {
+		return err
+	}
+	if err = kml.KML(kml.Document(elements...)).WriteIndent(f, "", "  "); err != nil {
+		return err
+	}
+
+	for i, q := range quads {
+		if len(q) == 0 {
+			continue
+		}
+		if err = r.tile(q, b.quadrant(i), z+1, 2*x+i%2, 2*y+i/2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRegionated writes a KMZ super-overlay of the entries: a quadtree of
+// tiles each with a Region, where the coarse tiles show thinned points and the
+// finer tiles are only loaded by the viewer once they are in view
+func writeRegionated(w io.Writer, name string, b *KMLBuilder, entries []*Record, max int) error {
+	var pts []*Record
+	box := regionBox{north: -90, south: 90, east: -180, west: 180}
+	for _, e := range entries {
+		if e.Coords == nil {
+			continue
+		}
+		pts = append(pts, e)
+		box.north = math.Max(box.north, e.Coords.Lat)
+		box.south = math.Min(box.south, e.Coords.Lat)
+		box.east = math.Max(box.east, e.Coords.Lon)
+		box.west = math.Min(box.west, e.Coords.Lon)
+		// Hand out the styles and fill in the schemas before any tile
+		// includes them
+		file, table := entrySource(e)
+		b.styles.id(file, table)
+		b.observe(e)
+	}
+	if len(pts) == 0 {
+		box = regionBox{north: 90, south: -90, east: 180, west: -180}
+	}
+	// Pad the box so the edge points are inside the thinning grid
+	pad := math.Max(math.Max(box.north-box.south, box.east-box.west)*0.01, 1e-6)
+	box.north = math.Min(90, box.north+pad)
+	box.south = math.Max(-90, box.south-pad)
+	box.east = math.Min(180, box.east+pad)
+	box.west = math.Max(-180, box.west-pad)
+
+	r := &regionWriter{
+		zw:   zip.NewWriter(w),
+		name: name,
+		b:    b,
+		max:  max,
+	}
+
+	f, err := r.zw.Create("doc.kml")
+	if err != nil {
+		return err
+	}
+	doc := kml.KML(kml.Document(
+		kml.Name(name),
+		kml.Description("Built using geo-sqlite-dumper, https://github.com/pschou/geo-sqlite-dumper"),
+		kml.Open(true),
+		kml.NetworkLink(
+			kml.Name(fmt.Sprintf("Points (%d)", len(pts))),
+			box.region(0, -1),
+			kml.Link(
+				kml.Href(tileName(0, 0, 0)),
+				kml.ViewRefreshMode(kml.ViewRefreshModeOnRegion),
+			),
+		),
+	))
+	if err = doc.WriteIndent(f, "", "  "); err != nil {
+		return err
+	}
+
+	if err = r.tile(pts, box, 0, 0, 0); err != nil {
+		return err
+	}
+
+	if f, err = r.zw.Create(kmzPointIcon); err != nil {
+		return err
+	}
+	if err = writePointIcon(f); err != nil {
+		return err
+	}
+	return r.zw.Close()
+}
+
+// regionateSink keeps the points in memory, the bounds of the quadtree are
+// only known once all of them are read, and writes the super-overlay on close
+type regionateSink struct {
+	NopSink
+	w       io.Writer
+	name    string
+	b       *KMLBuilder
+	max     int
+	entries []*Record
+}
+
+// NewRegionateSink returns a sink writing a super-overlay KMZ archive to w,
+// with at most max points in a tile
+func NewRegionateSink(w io.Writer, name string, b *KMLBuilder, max int) Sink {
+	return &regionateSink{w: w, name: name, b: b, max: max}
+}
+
+func (r *regionateSink) Record(e *Record) error {
+	if e.Coords != nil {
+		r.entries = append(r.entries, e)
+	}
+	return nil
+}
+
+func (r *regionateSink) Close() error {
+	return writeRegionated(r.w, r.name, r.b, r.entries, r.max)
+}
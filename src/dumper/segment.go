@@ -0,0 +1,84 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"math"
+	"time"
+
+	"github.com/twpayne/go-kml"
+)
+
+// Segmenter splits a time ordered series of records into events, an event
+// ends when the gap to the next record is more than Gap
+type Segmenter struct {
+	Gap       time.Duration
+	Emit      func(ev *Event) error
+	Waypoints bool // mark the events as waypoints, see Event
+
+	file, table, joined string
+	ev                  *Event
+	prev                *kml.Coordinate
+}
+
+// NewSegmenter returns a segmenter for the records of one table, every event
+// is handed to emit once it is complete
+func NewSegmenter(gap time.Duration, file, table, joined string, emit func(ev *Event) error) *Segmenter {
+	return &Segmenter{Gap: gap, Emit: emit, file: file, table: table, joined: joined}
+}
+
+// Add adds the record to the current event, first emitting the event if the
+// record is too far past the end of it
+func (s *Segmenter) Add(r *Record) error {
+	if s.ev == nil {
+		s.ev = &Event{File: s.file, Table: s.table, Joined: s.joined, Waypoints: s.Waypoints}
+	}
+	if !r.Time.IsZero() && len(s.ev.Records) > 0 && r.Time.Sub(s.ev.Records[len(s.ev.Records)-1].Time) > s.Gap {
+		if err := s.Flush(); err != nil {
+			return err
+		}
+		s.ev = &Event{File: s.file, Table: s.table, Joined: s.joined, Waypoints: s.Waypoints}
+	}
+	s.ev.Records = append(s.ev.Records, r)
+	if r.Coords != nil {
+		s.ev.Alt += r.Coords.Alt
+		if s.prev != nil {
+			s.ev.Dist += Distance(*s.prev, *r.Coords)
+		}
+	}
+	s.prev = r.Coords
+	return nil
+}
+
+// Flush emits the current event, if it has any records
+func (s *Segmenter) Flush() error {
+	ev := s.ev
+	s.ev, s.prev = nil, nil
+	if ev == nil || len(ev.Records) == 0 {
+		return nil
+	}
+	return s.Emit(ev)
+}
+
+// Distance is the distance in meters between two coordinates, taking the
+// altitude into account
+func Distance(a, b kml.Coordinate) float64 {
+	// Center point for altitude
+	r1 := EarthRadius(a.Lat)
+	r2 := EarthRadius(b.Lat)
+	arc := ArcDistance(a.Lat, a.Lon, b.Lat, b.Lon)
+	// Using a first order cartesian approximation, and not the
+	// incomplete elliptic intergral:
+	return math.Sqrt(Sq(r1+a.Alt-r2-b.Alt) +
+		Sq(arc*(r1+a.Alt+r2+b.Alt)/2))
+}
@@ -0,0 +1,624 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/twpayne/go-geom"
+)
+
+// ESRI Shapefile reference:
+// https://www.esri.com/content/dam/esrisites/sitecore-archive/Files/Pdfs/library/whitepapers/pdfs/shapefile.pdf
+
+const (
+	shpNull     = 0
+	shpPoint    = 1
+	shpPolyLine = 3
+	shpPolygon  = 5
+)
+
+// shpWGS84 is the .prj of every shapefile written, as ArcGIS writes it
+const shpWGS84 = `GEOGCS["GCS_WGS_1984",DATUM["D_WGS_1984",SPHEROID["WGS_1984",6378137.0,298.257223563]],` +
+	`PRIMEM["Greenwich",0.0],UNIT["Degree",0.0174532925199433]]`
+
+// dbfMaxFields is the most fields a DBF file can hold
+const dbfMaxFields = 255
+
+// dbfField is a column of the DBF table, typed by the values seen in it
+type dbfField struct {
+	column string
+	name   string // at most 10 characters
+	number bool   // integers or decimals
+	logic  bool   // true or false
+	text   bool   // anything else, or a mix of the kinds above
+	ints   int    // widest integer part of the numbers
+	decs   int    // most decimals of the numbers
+	size   int    // longest value as text
+}
+
+// add widens the field to fit the value
+func (f *dbfField) add(v interface{}) {
+	switch val := v.(type) {
+	case nil:
+		return
+	case bool:
+		f.logic = true
+	case int, int64:
+		f.number = true
+		f.ints = maxInt(f.ints, len(fmt.Sprintf("%d", val)))
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return
+		}
+		f.number = true
+		s := strconv.FormatFloat(val, 'f', -1, 64)
+		if i := strings.IndexByte(s, '.'); i >= 0 {
+			f.ints = maxInt(f.ints, i)
+			f.decs = maxInt(f.decs, len(s)-i-1)
+		} else {
+			f.ints = maxInt(f.ints, len(s))
+		}
+	default:
+		f.text = true
+	}
+	f.size = maxInt(f.size, len(valueText(v)))
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// kind returns the DBF type, width and decimal count of the field, numbers
+// are kept within the 24 characters the GIS tools read and anything which
+// does not fit is written as text
+func (f *dbfField) kind() (byte, int, int) {
+	if f.number && !f.text && !f.logic && f.ints < 24 {
+		decs := f.decs
+		if decs > 15 {
+			decs = 15
+		}
+		if f.ints+1+decs > 24 {
+			decs = 24 - 1 - f.ints
+		}
+		if decs == 0 {
+			return 'N', f.ints, 0
+		}
+		return 'N', f.ints + 1 + decs, decs
+	}
+	if f.logic && !f.text && !f.number {
+		return 'L', 1, 0
+	}
+	size := f.size
+	if size > 254 {
+		size = 254
+	} else if size < 1 {
+		size = 1
+	}
+	return 'C', size, 0
+}
+
+// valueText is a value as text, as the CSV writes it without the quotes
+func valueText(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case int, int64:
+		return fmt.Sprintf("%d", val)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	}
+	strB, _ := json.Marshal(v)
+	return string(strB)
+}
+
+// dbfValue renders a value to the width of its field
+func dbfValue(v interface{}, kind byte, width, decs int) string {
+	var s string
+	switch kind {
+	case 'N':
+		switch val := v.(type) {
+		case int, int64:
+			s = fmt.Sprintf("%d", val)
+		case float64:
+			if !math.IsNaN(val) && !math.IsInf(val, 0) {
+				s = strconv.FormatFloat(val, 'f', decs, 64)
+				// Rounding can carry into another integer digit, such as
+				// 9.9996 to 10.000, so give up decimals until it fits
+				for d := decs - 1; len(s) > width && d >= 0; d-- {
+					s = strconv.FormatFloat(val, 'f', d, 64)
+				}
+			}
+		}
+		if len(s) > width {
+			s = ""
+		}
+		return strings.Repeat(" ", width-len(s)) + s
+	case 'L':
+		switch v {
+		case true:
+			return "T"
+		case false:
+			return "F"
+		}
+		return "?"
+	}
+	s = valueText(v)
+	if len(s) > width {
+		// Cut on a character, not within one
+		s = s[:width]
+		for len(s) > 0 && !utf8.ValidString(s) {
+			s = s[:len(s)-1]
+		}
+	}
+	return s + strings.Repeat(" ", width-len(s))
+}
+
+// dbfName shortens a column to a DBF field name of at most 10 characters,
+// which is not yet used
+func dbfName(column string, used map[string]bool) string {
+	clean := []byte(column)
+	for i, c := range clean {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			clean[i] = '_'
+		}
+	}
+	name := string(clean)
+	if len(name) > 10 {
+		name = name[:10]
+	}
+	if name == "" {
+		name = "FIELD"
+	}
+	base := name
+	for i := 1; used[strings.ToUpper(name)]; i++ {
+		suffix := fmt.Sprintf("_%d", i)
+		if len(base)+len(suffix) > 10 {
+			name = base[:10-len(suffix)] + suffix
+		} else {
+			name = base + suffix
+		}
+	}
+	used[strings.ToUpper(name)] = true
+	return name
+}
+
+// shpLayer is one shapefile, the shapes are written as they come in and the
+// attributes are spooled until the types of the fields are known
+type shpLayer struct {
+	path     string // without the extension
+	kind     int32
+	shp, shx *os.File
+	shpw     *bufio.Writer
+	shxw     *bufio.Writer
+	offset   int // in 16 bit words, where the next shape goes
+	count    int
+	bounds   [4]float64 // xmin, ymin, xmax, ymax
+	bounded  bool
+	data     *recordSpool
+	fields   map[string]*dbfField
+}
+
+func newShpLayer(path string, kind int32) (*shpLayer, error) {
+	l := &shpLayer{path: path, kind: kind, offset: 50, fields: make(map[string]*dbfField)}
+	var err error
+	if l.shp, err = os.Create(path + ".shp"); err != nil {
+		return nil, err
+	}
+	if l.shx, err = os.Create(path + ".shx"); err != nil {
+		l.shp.Close()
+		return nil, err
+	}
+	if l.data, err = newRecordSpool(); err != nil {
+		l.shp.Close()
+		l.shx.Close()
+		return nil, err
+	}
+	l.shpw, l.shxw = bufio.NewWriter(l.shp), bufio.NewWriter(l.shx)
+	// The headers are filled in once the lengths and bounds are known
+	l.shpw.Write(make([]byte, 100))
+	l.shxw.Write(make([]byte, 100))
+	return l, nil
+}
+
+// extend grows the bounds over the points
+func extend(bounds *[4]float64, bounded *bool, flat []float64) {
+	for i := 0; i+1 < len(flat); i += 2 {
+		x, y := flat[i], flat[i+1]
+		if !*bounded {
+			*bounds = [4]float64{x, y, x, y}
+			*bounded = true
+		}
+		bounds[0], bounds[2] = math.Min(bounds[0], x), math.Max(bounds[2], x)
+		bounds[1], bounds[3] = math.Min(bounds[1], y), math.Max(bounds[3], y)
+	}
+}
+
+// write adds a shape, a point or the parts of a polyline or polygon, each
+// the x, y pairs of a line or ring, or a null shape when there are no parts,
+// with its attributes
+func (l *shpLayer) write(parts [][]float64, e *Record) error {
+	var content bytes.Buffer
+	switch {
+	case len(parts) == 0:
+		binary.Write(&content, binary.LittleEndian, int32(shpNull))
+	case l.kind == shpPoint:
+		binary.Write(&content, binary.LittleEndian, int32(shpPoint))
+		binary.Write(&content, binary.LittleEndian, parts[0][:2])
+		extend(&l.bounds, &l.bounded, parts[0][:2])
+	default:
+		var box [4]float64
+		var boxed bool
+		var starts []int32
+		points := 0
+		for _, part := range parts {
+			extend(&box, &boxed, part)
+			starts = append(starts, int32(points))
+			points += len(part) / 2
+		}
+		binary.Write(&content, binary.LittleEndian, l.kind)
+		binary.Write(&content, binary.LittleEndian, box[:])
+		binary.Write(&content, binary.LittleEndian, []int32{int32(len(parts)), int32(points)})
+		binary.Write(&content, binary.LittleEndian, starts)
+		for _, part := range parts {
+			binary.Write(&content, binary.LittleEndian, part)
+		}
+		extend(&l.bounds, &l.bounded, box[:])
+	}
+	words := content.Len() / 2
+	binary.Write(l.shpw, binary.BigEndian, []int32{int32(l.count + 1), int32(words)})
+	l.shpw.Write(content.Bytes())
+	binary.Write(l.shxw, binary.BigEndian, []int32{int32(l.offset), int32(words)})
+	l.offset += 4 + words
+	l.count++
+
+	for _, c := range e.Columns {
+		f, ok := l.fields[c]
+		if !ok {
+			f = &dbfField{column: c}
+			l.fields[c] = f
+		}
+		f.add(e.Data[c])
+	}
+	return l.data.add(e)
+}
+
+// header is the 100 bytes starting the .shp and .shx files
+func (l *shpLayer) header(words int) []byte {
+	var h bytes.Buffer
+	binary.Write(&h, binary.BigEndian, []int32{9994, 0, 0, 0, 0, 0, int32(words)})
+	binary.Write(&h, binary.LittleEndian, []int32{1000, l.kind})
+	binary.Write(&h, binary.LittleEndian, l.bounds[:])
+	binary.Write(&h, binary.LittleEndian, []float64{0, 0, 0, 0})
+	return h.Bytes()
+}
+
+// close finishes the .shp and .shx, then writes the .dbf, .prj and .cpg and
+// the fields.csv listing the column of every DBF field
+func (l *shpLayer) close() error {
+	defer l.data.close()
+	for _, f := range []struct {
+		file  *os.File
+		w     *bufio.Writer
+		words int
+	}{{l.shp, l.shpw, l.offset}, {l.shx, l.shxw, 50 + 4*l.count}} {
+		if err := f.w.Flush(); err != nil {
+			return err
+		}
+		if _, err := f.file.WriteAt(l.header(f.words), 0); err != nil {
+			return err
+		}
+		if err := f.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	used := make(map[string]bool)
+	var fields []*dbfField
+	for _, c := range l.data.columns.names {
+		if len(fields) == dbfMaxFields {
+			log.Printf("Warning: %s.dbf can only hold %d fields, the columns after %s are left out\n",
+				l.path, dbfMaxFields, fields[len(fields)-1].column)
+			break
+		}
+		f := l.fields[c]
+		f.name = dbfName(c, used)
+		fields = append(fields, f)
+	}
+	if err := l.writeDBF(fields); err != nil {
+		return err
+	}
+
+	var names bytes.Buffer
+	names.WriteString("\"FIELD\",\"COLUMN\"\n")
+	for _, f := range fields {
+		fmt.Fprintf(&names, "%q,%q\n", f.name, f.column)
+	}
+	if err := os.WriteFile(l.path+".fields.csv", names.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.path+".prj", []byte(shpWGS84), 0644); err != nil {
+		return err
+	}
+	return os.WriteFile(l.path+".cpg", []byte("UTF-8"), 0644)
+}
+
+// writeDBF writes the dBase III table of the attributes, one row per shape
+func (l *shpLayer) writeDBF(fields []*dbfField) error {
+	f, err := os.Create(l.path + ".dbf")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	type layout struct {
+		kind        byte
+		width, decs int
+	}
+	layouts := make([]layout, len(fields))
+	size := 1 // the deleted flag
+	for i, fld := range fields {
+		kind, width, decs := fld.kind()
+		layouts[i] = layout{kind, width, decs}
+		size += width
+	}
+
+	now := time.Now()
+	w.Write([]byte{0x03, byte(now.Year() - 1900), byte(now.Month()), byte(now.Day())})
+	binary.Write(w, binary.LittleEndian, uint32(l.count))
+	binary.Write(w, binary.LittleEndian, []uint16{uint16(32 + 32*len(fields) + 1), uint16(size)})
+	w.Write(make([]byte, 20))
+	for i, fld := range fields {
+		var desc [32]byte
+		copy(desc[:11], fld.name)
+		desc[11] = layouts[i].kind
+		desc[16] = byte(layouts[i].width)
+		desc[17] = byte(layouts[i].decs)
+		w.Write(desc[:])
+	}
+	w.WriteByte(0x0D)
+
+	err = l.data.replay(func(data map[string]interface{}) error {
+		w.WriteByte(' ')
+		for i, fld := range fields {
+			w.WriteString(dbfValue(data[fld.column], layouts[i].kind, layouts[i].width, layouts[i].decs))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteByte(0x1A)
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// shpSink writes a set of shapefiles into a directory, a point shapefile for
+// each source table, holding the rows of that table from every file, and,
+// when lines is set, an events polyline shapefile with a line for every
+// event.  Only one kind of shape can be in a shapefile, so the lines and
+// polygons of a table go to their own polyline and polygon shapefiles, named
+// after the table with _lines and _polygons, and any other shape is placed
+// at its center.  DBF field names are cut to 10 characters, so each
+// shapefile has a fields.csv with the column of every field.
+type shpSink struct {
+	NopSink
+	dir    string
+	lines  bool
+	layers map[shpKey]*shpLayer
+	order  []*shpLayer
+	used   map[string]bool
+}
+
+// shpKey is the source table and kind of shape of a shapefile
+type shpKey struct {
+	table string
+	kind  int32
+}
+
+// NewShapefileSink returns a sink writing shapefiles into dir, which is made
+// when it does not exist, with the event paths when lines is set
+func NewShapefileSink(dir string, lines bool) (Sink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &shpSink{dir: dir, lines: lines, layers: make(map[shpKey]*shpLayer), used: make(map[string]bool)}
+	if lines {
+		// Keep the name for the events, whichever table comes first
+		s.used["events"] = true
+	}
+	return s, nil
+}
+
+// layer returns the shapefile of a source table for a kind of shape,
+// creating it when the table is first seen with the kind
+func (s *shpSink) layer(table string, kind int32) (*shpLayer, error) {
+	if l, ok := s.layers[shpKey{table, kind}]; ok {
+		return l, nil
+	}
+	var name string
+	switch table {
+	case eventsLayer:
+		name = "events"
+		delete(s.used, name)
+	case "":
+		name = "query"
+	default:
+		// Only characters which are safe in a file name on every system
+		clean := []rune(table)
+		for i, c := range clean {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+				clean[i] = '_'
+			}
+		}
+		name = string(clean)
+	}
+	switch {
+	case table == eventsLayer:
+	case kind == shpPolyLine:
+		name += "_lines"
+	case kind == shpPolygon:
+		name += "_polygons"
+	}
+	base := name
+	for i := 1; s.used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+	s.used[strings.ToLower(name)] = true
+
+	l, err := newShpLayer(filepath.Join(s.dir, name), kind)
+	if err != nil {
+		return nil, err
+	}
+	s.layers[shpKey{table, kind}] = l
+	s.order = append(s.order, l)
+	return l, nil
+}
+
+// shpParts returns the kind of shapefile for a geometry and its parts, the
+// lines of a polyline or the rings of a polygon, which go clockwise around
+// the outside and counterclockwise around the holes.  Other geometries are
+// a point at the coordinate of the record.
+func shpParts(g geom.T) (int32, [][]float64) {
+	xy := func(g geom.T) []float64 {
+		flat, stride := g.FlatCoords(), g.Stride()
+		var ret []float64
+		for i := 0; i+1 < len(flat); i += stride {
+			ret = append(ret, flat[i], flat[i+1])
+		}
+		return ret
+	}
+	rings := func(p *geom.Polygon) [][]float64 {
+		var ret [][]float64
+		for i := 0; i < p.NumLinearRings(); i++ {
+			ring := xy(p.LinearRing(i))
+			if len(ring) < 8 && i == 0 {
+				// Without the outside the holes are left out too
+				return nil
+			} else if len(ring) < 8 {
+				continue
+			}
+			// The shoelace area is negative when the ring is clockwise
+			area := 0.0
+			for j := 0; j+3 < len(ring); j += 2 {
+				area += ring[j]*ring[j+3] - ring[j+2]*ring[j+1]
+			}
+			if (i == 0) == (area > 0) {
+				for a, b := 0, len(ring)-2; a < b; a, b = a+2, b-2 {
+					ring[a], ring[a+1], ring[b], ring[b+1] = ring[b], ring[b+1], ring[a], ring[a+1]
+				}
+			}
+			ret = append(ret, ring)
+		}
+		return ret
+	}
+	var parts [][]float64
+	switch t := g.(type) {
+	case *geom.LineString:
+		if line := xy(t); len(line) >= 4 {
+			parts = append(parts, line)
+		}
+		return shpPolyLine, parts
+	case *geom.MultiLineString:
+		for i := 0; i < t.NumLineStrings(); i++ {
+			if line := xy(t.LineString(i)); len(line) >= 4 {
+				parts = append(parts, line)
+			}
+		}
+		return shpPolyLine, parts
+	case *geom.Polygon:
+		return shpPolygon, rings(t)
+	case *geom.MultiPolygon:
+		for i := 0; i < t.NumPolygons(); i++ {
+			parts = append(parts, rings(t.Polygon(i))...)
+		}
+		return shpPolygon, parts
+	}
+	return shpPoint, nil
+}
+
+func (s *shpSink) Record(e *Record) error {
+	kind, parts := shpParts(e.Geometry)
+	if kind == shpPoint && e.Coords != nil {
+		parts = [][]float64{{e.Coords.Lon, e.Coords.Lat}}
+	}
+	l, err := s.layer(e.Table, kind)
+	if err != nil {
+		return err
+	}
+	return l.write(parts, e)
+}
+
+func (s *shpSink) Event(ev *Event) error {
+	if !s.lines || ev.Waypoints {
+		return nil
+	}
+	var flat []float64
+	for _, e := range ev.Records {
+		if e.Coords != nil {
+			flat = append(flat, e.Coords.Lon, e.Coords.Lat)
+		}
+	}
+	if len(flat) < 4 {
+		return nil
+	}
+	l, err := s.layer(eventsLayer, shpPolyLine)
+	if err != nil {
+		return err
+	}
+	e := &Record{
+		Data: map[string]interface{}{
+			"SOURCE_FILE_PATH": ev.File,
+			"SOURCE_TABLE":     ev.Table,
+			"POINTS":           len(ev.Records),
+			"START_TIME":       ev.Records[0].Time.UTC().Format("2006-01-02T15:04:05Z"),
+			"END_TIME":         ev.Records[len(ev.Records)-1].Time.UTC().Format("2006-01-02T15:04:05Z"),
+			"DISTANCE_M":       math.Round(ev.Dist*100) / 100,
+		},
+		Columns: []string{"SOURCE_FILE_PATH", "SOURCE_TABLE", "POINTS", "START_TIME", "END_TIME", "DISTANCE_M"},
+	}
+	return l.write([][]float64{flat}, e)
+}
+
+func (s *shpSink) Close() error {
+	for _, l := range s.order {
+		if err := l.close(); err != nil {
+			return fmt.Errorf("failed to write shapefile %s: %v", l.path, err)
+		}
+	}
+	return nil
+}
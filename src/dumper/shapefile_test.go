@@ -0,0 +1,188 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/twpayne/go-geom"
+)
+
+func TestDBFName(t *testing.T) {
+	used := make(map[string]bool)
+	for _, tc := range []struct{ column, want string }{
+		{"ZLATITUDE", "ZLATITUDE"},
+		{"ZTIMESTAMP", "ZTIMESTAMP"},
+		{"ZTIMESTAMP_LOCAL", "ZTIMESTA_1"},
+		{"ZTIMESTAMP_UTC", "ZTIMESTA_2"},
+		{"ztimestamp", "ztimesta_3"},
+		{"Speed (m/s)", "Speed__m_s"},
+		{"", "FIELD"},
+		{"", "FIELD_1"},
+		{"FIELD_1", "FIELD_1_1"},
+	} {
+		if got := dbfName(tc.column, used); got != tc.want {
+			t.Errorf("%q is named %q, want %q", tc.column, got, tc.want)
+		}
+	}
+
+	// Once the suffixes reach two digits the name is cut back further
+	used = make(map[string]bool)
+	var got string
+	for i := 0; i < 11; i++ {
+		got = dbfName("ZHORIZONTALACCURACY", used)
+		if len(got) > 10 {
+			t.Fatalf("%q is over 10 characters", got)
+		}
+	}
+	if got != "ZHORIZO_10" {
+		t.Errorf("11th ZHORIZONTALACCURACY is named %q, want ZHORIZO_10", got)
+	}
+	if len(used) != 11 {
+		t.Errorf("%d names for 11 columns", len(used))
+	}
+}
+
+func TestDBFFieldKind(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		values []interface{}
+		kind   byte
+		width  int
+		decs   int
+	}{
+		{"integers", []interface{}{int64(7), int64(-1234), nil}, 'N', 5, 0},
+		{"decimals", []interface{}{38.8977, -77.0365, int64(100)}, 'N', 8, 4},
+		{"whole floats", []interface{}{1.0, 250.0}, 'N', 3, 0},
+		{"decimals capped at 15", []interface{}{0.12345678901234568, 1e-20}, 'N', 17, 15},
+		{"width capped at 24", []interface{}{1e21, 0.123456}, 'N', 24, 1},
+		{"integer part too wide", []interface{}{1e24}, 'C', 25, 0},
+		{"booleans", []interface{}{true, false, nil}, 'L', 1, 0},
+		{"text", []interface{}{"Home", "Work"}, 'C', 4, 0},
+		{"mixed", []interface{}{int64(1), "one", true}, 'C', 4, 0},
+		{"long text", []interface{}{strings.Repeat("x", 300)}, 'C', 254, 0},
+		{"all NULL", []interface{}{nil, nil}, 'C', 1, 0},
+	} {
+		f := &dbfField{column: tc.name}
+		for _, v := range tc.values {
+			f.add(v)
+		}
+		kind, width, decs := f.kind()
+		if kind != tc.kind || width != tc.width || decs != tc.decs {
+			t.Errorf("%s is %c(%d,%d), want %c(%d,%d)", tc.name, kind, width, decs, tc.kind, tc.width, tc.decs)
+		}
+	}
+}
+
+func TestDBFValue(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		v     interface{}
+		kind  byte
+		width int
+		decs  int
+		want  string
+	}{
+		{"integer", int64(-42), 'N', 5, 0, "  -42"},
+		{"decimal", 38.8977, 'N', 8, 4, " 38.8977"},
+		{"padded decimals", 1.5, 'N', 8, 4, "  1.5000"},
+		{"rounded", 0.12345678901234568, 'N', 17, 15, "0.123456789012346"},
+		// Rounding carries into a new digit, a decimal is given up for it
+		{"carried", 9.9996, 'N', 5, 3, "10.00"},
+		{"too wide", int64(123456), 'N', 5, 0, "     "},
+		{"NULL number", nil, 'N', 4, 0, "    "},
+		{"true", true, 'L', 1, 0, "T"},
+		{"false", false, 'L', 1, 0, "F"},
+		{"NULL boolean", nil, 'L', 1, 0, "?"},
+		{"text", "Home", 'C', 6, 0, "Home  "},
+		{"cut text", "Homestead", 'C', 4, 0, "Home"},
+		// é is two bytes, cutting through it drops the whole character
+		{"cut within a character", "Café", 'C', 4, 0, "Caf "},
+		{"cut after a character", "Café", 'C', 5, 0, "Café"},
+		{"bytes", []byte("blob"), 'C', 4, 0, "blob"},
+	} {
+		got := dbfValue(tc.v, tc.kind, tc.width, tc.decs)
+		if got != tc.want {
+			t.Errorf("%s: %v is %q, want %q", tc.name, tc.v, got, tc.want)
+		}
+		if len(got) != tc.width {
+			t.Errorf("%s: %q is %d bytes for a field of %d", tc.name, got, len(got), tc.width)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("%s: %q is not valid UTF-8", tc.name, got)
+		}
+	}
+}
+
+// ringArea is the shoelace area of a ring, negative when it is clockwise
+func ringArea(ring []float64) float64 {
+	area := 0.0
+	for i := 0; i+3 < len(ring); i += 2 {
+		area += ring[i]*ring[i+3] - ring[i+2]*ring[i+1]
+	}
+	return area / 2
+}
+
+func TestShpPartsRingOrder(t *testing.T) {
+	// The outside counterclockwise and the hole clockwise, as GeoJSON has
+	// them, and the same polygon the other way round
+	outer := []geom.Coord{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	hole := []geom.Coord{{2, 2}, {2, 4}, {4, 4}, {4, 2}, {2, 2}}
+	reverse := func(ring []geom.Coord) []geom.Coord {
+		ret := make([]geom.Coord, len(ring))
+		for i, c := range ring {
+			ret[len(ring)-1-i] = c
+		}
+		return ret
+	}
+	for _, p := range []*geom.Polygon{
+		geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{outer, hole}),
+		geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{reverse(outer), reverse(hole)}),
+	} {
+		kind, parts := shpParts(p)
+		if kind != shpPolygon {
+			t.Fatalf("polygon is shape type %d", kind)
+		}
+		if len(parts) != 2 {
+			t.Fatalf("%d rings, want 2", len(parts))
+		}
+		if a := ringArea(parts[0]); a != -100 {
+			t.Errorf("outside has area %v, want -100 going clockwise", a)
+		}
+		if a := ringArea(parts[1]); a != 4 {
+			t.Errorf("hole has area %v, want 4 going counterclockwise", a)
+		}
+		// The rings are still closed
+		for i, ring := range parts {
+			if ring[0] != ring[len(ring)-2] || ring[1] != ring[len(ring)-1] {
+				t.Errorf("ring %d is not closed: %v", i, ring)
+			}
+		}
+	}
+
+	// A polygon with too few points outside is left out, holes and all
+	open := geom.NewPolygon(geom.XY).MustSetCoords([][]geom.Coord{{{0, 0}, {1, 1}, {0, 0}}, hole})
+	if _, parts := shpParts(open); len(parts) != 0 {
+		t.Errorf("degenerate polygon has %d rings", len(parts))
+	}
+
+	line := geom.NewLineString(geom.XYZ).MustSetCoords([]geom.Coord{{0, 0, 5}, {1, 1, 5}})
+	if kind, parts := shpParts(line); kind != shpPolyLine || len(parts) != 1 || len(parts[0]) != 4 {
+		t.Errorf("line is shape type %d with parts %v", kind, parts)
+	}
+	if kind, parts := shpParts(geom.NewPoint(geom.XY)); kind != shpPoint || parts != nil {
+		t.Errorf("point is shape type %d with parts %v", kind, parts)
+	}
+}
@@ -0,0 +1,279 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"os"
+)
+
+// Sink is an output which is handed the records as they are read, so nothing
+// has to be kept in memory longer than the output format needs.  For every
+// file BeginFile is called, then for every table with coordinates BeginTable,
+// Record and Event as the rows are read and EndTable, and last EndFile.
+// Close is called once all the files are done.
+type Sink interface {
+	BeginFile(file string) error
+	BeginTable(file, table string) error
+	// Record is called for every row, except those of a table only drawn as
+	// events, one a profile marks events_only or, with no profile, the
+	// transitions joined to their locations of interest
+	Record(e *Record) error
+	// Event is called for every series of rows within the event time
+	Event(ev *Event) error
+	EndTable(file, table string, rows int) error
+	EndFile(file string) error
+	Close() error
+}
+
+// NopSink can be embedded to only implement the calls a sink needs
+type NopSink struct{}
+
+func (NopSink) BeginFile(file string) error                 { return nil }
+func (NopSink) BeginTable(file, table string) error         { return nil }
+func (NopSink) Record(e *Record) error                      { return nil }
+func (NopSink) Event(ev *Event) error                       { return nil }
+func (NopSink) EndTable(file, table string, rows int) error { return nil }
+func (NopSink) EndFile(file string) error                   { return nil }
+func (NopSink) Close() error                                { return nil }
+
+// Sinks hands every call on to each of the sinks in turn
+type Sinks []Sink
+
+func (s Sinks) BeginFile(file string) error {
+	for _, o := range s {
+		if err := o.BeginFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Sinks) BeginTable(file, table string) error {
+	for _, o := range s {
+		if err := o.BeginTable(file, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Sinks) Record(e *Record) error {
+	for _, o := range s {
+		if err := o.Record(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Sinks) Event(ev *Event) error {
+	for _, o := range s {
+		if err := o.Event(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Sinks) EndTable(file, table string, rows int) error {
+	for _, o := range s {
+		if err := o.EndTable(file, table, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s Sinks) EndFile(file string) error {
+	for _, o := range s {
+		if err := o.EndFile(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close closes every sink, returning the first error
+func (s Sinks) Close() (err error) {
+	for _, o := range s {
+		if e := o.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+// columnSet is the list of column names in the order they were first seen
+type columnSet struct {
+	names []string
+	index map[string]bool
+}
+
+func (c *columnSet) add(names ...string) {
+	if c.index == nil {
+		c.index = make(map[string]bool)
+	}
+	for _, n := range names {
+		if !c.index[n] {
+			c.index[n] = true
+			c.names = append(c.names, n)
+		}
+	}
+}
+
+// spool is a temporary file which is removed once it is closed
+type spool struct {
+	*os.File
+}
+
+func newSpool() (*spool, error) {
+	f, err := os.CreateTemp("", "geo-sqlite-dumper-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spool{File: f}, nil
+}
+
+// reset empties the spool so it can be used again
+func (s *spool) reset() error {
+	if err := s.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.Seek(0, io.SeekStart)
+	return err
+}
+
+// copyTo writes the contents of the spool out to w
+func (s *spool) copyTo(w io.Writer) error {
+	if _, err := s.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, s)
+	return err
+}
+
+func (s *spool) close() error {
+	s.Close()
+	return os.Remove(s.Name())
+}
+
+// recordSpool keeps the rows in a temporary file until the full set of
+// columns is known, for outputs which need a header before the first row
+type recordSpool struct {
+	f       *spool
+	w       *bufio.Writer
+	enc     *gob.Encoder
+	columns columnSet
+}
+
+func newRecordSpool() (*recordSpool, error) {
+	f, err := newSpool()
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &recordSpool{f: f, w: w, enc: gob.NewEncoder(w)}, nil
+}
+
+func (s *recordSpool) add(e *Record) error {
+	s.columns.add(e.Columns...)
+	return s.enc.Encode(e.Data)
+}
+
+// replay calls fn for every row in the order they were added
+func (s *recordSpool) replay(fn func(data map[string]interface{}) error) error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(s.f))
+	for {
+		var data map[string]interface{}
+		if err := dec.Decode(&data); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *recordSpool) close() error {
+	return s.f.close()
+}
+
+// valueKind is the type a column is written as by the typed outputs
+type valueKind int
+
+const (
+	kindText valueKind = iota
+	kindInt
+	kindFloat
+	kindBool
+	kindBlob
+)
+
+// kindSet is the kinds of the values seen in a column
+type kindSet struct {
+	ints, floats, bools, str, blob bool
+}
+
+func (k *kindSet) see(v interface{}) {
+	switch v.(type) {
+	case nil:
+	case int, int64:
+		k.ints = true
+	case float64:
+		k.floats = true
+	case bool:
+		k.bools = true
+	case []byte:
+		k.blob = true
+	default:
+		k.str = true
+	}
+}
+
+// kind returns the type to write the column as, text when the values do not
+// fit one type, whole numbers and decimals are written as decimals
+func (k kindSet) kind() valueKind {
+	switch {
+	case k.str, k.bools && (k.ints || k.floats || k.blob), k.blob && (k.ints || k.floats):
+		return kindText
+	case k.floats:
+		return kindFloat
+	case k.ints:
+		return kindInt
+	case k.bools:
+		return kindBool
+	case k.blob:
+		return kindBlob
+	}
+	return kindText
+}
+
+// geoRow is a record as the outputs which need every row before they can be
+// written spool it, with its shape and time
+type geoRow struct {
+	Data     map[string]interface{}
+	Geometry []byte // WKB
+	Time     int64  // microseconds since the epoch
+	HasTime  bool
+}
@@ -0,0 +1,643 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/twpayne/go-geom"
+	"github.com/twpayne/go-geom/encoding/wkt"
+	"github.com/twpayne/go-kml"
+)
+
+// Source reads the records out of SQLite files, every table with latitude
+// and longitude or geometry columns or the rows of a custom query
+type Source struct {
+	Query       string         // custom query in place of reading every table
+	BusyTimeout time.Duration  // busy timeout for SQLite calls
+	EventTime   time.Duration  // time between records to split events on
+	Force       bool           // warn and skip files which are not SQLite
+	Epochs      EpochRules     // forced epochs of the date columns
+	Profiles    Profiles       // column roles of known tables, the names are used otherwise
+	TZ          *time.Location // zone to render the times in, UTC when nil
+	LocalTime   *TZFinder      // adds the local time where each point was, when set
+	Debug       bool           // verbose logging
+}
+
+// checkHeader makes sure the file starts with the SQLite header
+func checkHeader(f string) error {
+	header := make([]byte, 16)
+	test, err := os.Open(f)
+	if err != nil {
+		return fmt.Errorf("Unable to open file %q, err: %s", f, err)
+	}
+	defer test.Close()
+	n, err := test.Read(header)
+	if err != nil {
+		return fmt.Errorf("Unable to read file %q, err: %s", f, err)
+	}
+	if n == 0 {
+		return fmt.Errorf("Empty file or unable to read bytes in file %q", f)
+	}
+	if string(header) != "SQLite format 3\x00" {
+		return fmt.Errorf("Header of file is not in \"SQLite format 3\", %q", f)
+	}
+	return nil
+}
+
+// open opens the file read only, with the busy timeout set
+func (s *Source) open(f string) (*sqlite3.Conn, error) {
+	ef := ""
+	for _, c := range []byte(f) {
+		switch c {
+		case '/':
+			ef += "/"
+		default:
+			// Escape name so the sql open call will be sanitized
+			ef += fmt.Sprintf("%%%x", c)
+		}
+	}
+	// Open command reference:  https://www.sqlite.org/c3ref/open.html
+	conn, err := sqlite3.Open("file:"+ef+"?mode=ro&nolock=1&immutable=1", sqlite3.OPEN_READONLY)
+	if err != nil {
+		return nil, err
+	}
+
+	// It's always a good idea to set a busy timeout
+	conn.BusyTimeout(s.BusyTimeout)
+	return conn, nil
+}
+
+// ReadFile reads every table with coordinates in the file, or the custom
+// query, and hands the records and events to the sink as they are read
+func (s *Source) ReadFile(f string, out Sink) error {
+	if err := checkHeader(f); err != nil {
+		if s.Force {
+			log.Printf("Warning: %s\n", err)
+			return nil
+		}
+		return err
+	}
+
+	conn, err := s.open(f)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// If no query is specified, dump all tables to file
+	tbl_names := []string{""}
+	if s.Query == "" {
+		tbl_names, err = getTables(conn)
+	}
+
+	if err != nil {
+		log.Println("Make sure the file is in SQLite file format.", err)
+		return nil
+	}
+
+	// The profiles whose fingerprint is in the file are used on its tables,
+	// and only the tables in the contents of a GeoPackage are read
+	var schema map[string][]string
+	var profiles Profiles
+	var gp *geoPackage
+	if s.Query == "" {
+		if gp, err = readGeoPackage(conn, tbl_names); err != nil {
+			return err
+		}
+		if gp != nil {
+			if s.Debug {
+				log.Printf("Reading %s as a GeoPackage with tables %v", f, gp.tables)
+			}
+			tbl_names = gp.tables
+		}
+		if schema, err = getSchema(conn, tbl_names); err != nil {
+			return err
+		}
+		profiles = s.Profiles.ForSchema(schema)
+		if s.Debug {
+			for _, p := range profiles {
+				if len(p.Fingerprint) > 0 {
+					log.Printf("Recognized %s as %s", f, p.Name)
+				}
+			}
+		}
+	}
+
+	if err = out.BeginFile(f); err != nil {
+		return err
+	}
+	// Loop over all the tables found in database, or call custom query
+	for _, tbl_name := range tbl_names {
+		if err = s.readTable(conn, f, tbl_name, tbl_names, schema, profiles, gp, out); err != nil {
+			return err
+		}
+	}
+	return out.EndFile(f)
+}
+
+// rowidColumn is the name the rowid is selected as, after the columns of the
+// table so their indexes are the same with or without it
+const rowidColumn = "geo_sqlite_dumper_rowid"
+
+// selectRows prepares the select of the rows with the rowid of the table
+// as the last column, or without it for a table which has no rowid
+func selectRows(conn *sqlite3.Conn, rowid, from string) (*sqlite3.Stmt, bool, error) {
+	if stmt, err := conn.Prepare(`SELECT *, ` + rowid + ` AS ` + rowidColumn + ` FROM ` + from); err == nil {
+		return stmt, true, nil
+	}
+	stmt, err := conn.Prepare(`SELECT * FROM ` + from)
+	return stmt, false, err
+}
+
+// prepareTable selects the rows of a table ordered by the date found from the
+// column names.  Without a profile the transitions of the locations of
+// interest have the locations joined in, as they always have been.
+func (s *Source) prepareTable(conn *sqlite3.Conn, tbl_name string, tbl_names, clm_names []string) (stmt *sqlite3.Stmt, has_rowid bool, joined string, err error) {
+	idate := s.detectColumns(tbl_name, clm_names).Date
+	order := ""
+	if len(idate) > 0 {
+		order = ` ORDER BY a.` + quoteName(clm_names[idate[0]])
+	}
+
+	if join_tbl := strings.TrimSuffix(tbl_name, "TRANSITIONMO") + "MO"; join_tbl != tbl_name+"MO" && contains(tbl_names, join_tbl) {
+		stmt, has_rowid, err = selectRows(conn, `a._rowid_`, quoteName(tbl_name)+` AS a LEFT JOIN `+quoteName(join_tbl)+
+			` AS b ON a.ZLOCATIONOFINTEREST = b.Z_PK`+order)
+		if err == nil {
+			return stmt, has_rowid, join_tbl, nil
+		}
+	}
+
+	// Prepare an SQL statement for data parsing
+	stmt, has_rowid, err = selectRows(conn, `a._rowid_`, quoteName(tbl_name)+` AS a`+order)
+	return stmt, has_rowid, "", err
+}
+
+// prepareProfile selects the rows of a table mapped by a profile, ordered by
+// its time column and with the join of the profile when the table is there
+func (s *Source) prepareProfile(conn *sqlite3.Conn, tbl_name string, clm_names []string, joined string, tp *TableProfile) (*sqlite3.Stmt, bool, error) {
+	order := ""
+	if i := s.findRoles(tbl_name, clm_names, tp).date; i >= 0 {
+		order = ` ORDER BY a.` + quoteName(clm_names[i])
+	}
+	where := ""
+	if tp.Where != "" {
+		where = ` WHERE ` + tp.Where
+	}
+	if joined != "" {
+		return selectRows(conn, `a._rowid_`, quoteName(tbl_name)+` AS a LEFT JOIN `+quoteName(joined)+
+			` AS b ON a.`+quoteName(tp.Join.On)+` = b.`+quoteName(tp.Join.Key)+where+order)
+	}
+	return selectRows(conn, `a._rowid_`, quoteName(tbl_name)+` AS a`+where+order)
+}
+
+// roles is where the position, time and the other mapped values are in the
+// columns of the rows read, -1 when the rows have none
+type roles struct {
+	lat, lon, alt, date, accuracy, speed, course, label, geom, proto int
+}
+
+// findRoles places the roles from the table profile, with the altitude and
+// time found from the column names when the profile has none, or all of them
+// from the names when there is no profile
+func (s *Source) findRoles(tbl_name string, clm_names []string, tp *TableProfile) roles {
+	rl := roles{-1, -1, -1, -1, -1, -1, -1, -1, -1, -1}
+	if tp != nil {
+		rl = roles{
+			lat:      tp.Lat.find(clm_names),
+			lon:      tp.Lon.find(clm_names),
+			alt:      tp.Alt.find(clm_names),
+			date:     tp.Time.find(clm_names),
+			accuracy: tp.Accuracy.find(clm_names),
+			speed:    tp.Speed.find(clm_names),
+			course:   tp.Course.find(clm_names),
+			label:    tp.Label.find(clm_names),
+			geom:     tp.Geometry.find(clm_names),
+			proto:    tp.protobuf(clm_names),
+		}
+	}
+	cols := s.detectColumns(tbl_name, clm_names)
+	if tp == nil && cols.HasPosition() {
+		rl.lat, rl.lon = cols.Lat[0], cols.Long[0]
+	}
+	if tp == nil {
+		rl.geom = geometryColumn(clm_names, nil)
+	}
+	if rl.alt < 0 && len(cols.Alt) > 0 {
+		rl.alt = cols.Alt[0]
+	}
+	if rl.date < 0 && len(cols.Date) > 0 {
+		rl.date = cols.Date[0]
+	}
+	return rl
+}
+
+// addConverted adds the value of a role in the common units as a column,
+// when the profile converts it
+func addConverted(data_map map[string]interface{}, columns []string, tp *TableProfile, role, column string, v float64, ok bool) []string {
+	if !ok || tp.scale(role) == 1 {
+		return columns
+	}
+	data_map[column] = v
+	return append(columns, column)
+}
+
+// formatTime renders a parsed date column, with the offset when a zone was
+// chosen
+func (s *Source) formatTime(t time.Time) string {
+	if s.TZ == nil {
+		return t.Format("2006-01-02 15:04:05")
+	}
+	return t.In(s.TZ).Format(LocalLayout)
+}
+
+// detectColumns finds the position and time columns of a table, with the
+// columns named in the epoch rules added as dates
+func (s *Source) detectColumns(table string, clm_names []string) Columns {
+	cols := DetectColumns(clm_names)
+	for i, clm_name := range clm_names {
+		if _, named := s.Epochs.lookup(table, clm_name); named && !IsDateColumn(clm_name) {
+			cols.Date = append(cols.Date, i)
+		}
+	}
+	return cols
+}
+
+// readTable reads the rows of one table, or the custom query when the table
+// name is empty, splitting them into events as they are read
+func (s *Source) readTable(conn *sqlite3.Conn, f, tbl_name string, tbl_names []string, schema map[string][]string, profiles Profiles, gp *geoPackage, out Sink) (err error) {
+	if s.Debug {
+		log.Println("Table", tbl_name)
+	}
+
+	// The table joined in by a profile, if any
+	joined := ""
+	var tp *TableProfile
+	var stmt *sqlite3.Stmt
+	has_rowid := false
+	if s.Query == "" {
+		clm_names := schema[tbl_name]
+
+		var pr *Profile
+		pr, tp = profiles.Match(tbl_name, schema)
+		if tp != nil && tp.Skip {
+			if s.Debug {
+				log.Printf("Profile %q skips %s", pr.Name, tbl_name)
+			}
+			return nil
+		}
+		if tp != nil {
+			if s.Debug {
+				log.Printf("Using profile %q for %s", pr.Name, tbl_name)
+			}
+			joined = tp.joined(schema)
+			stmt, has_rowid, err = s.prepareProfile(conn, tbl_name, clm_names, joined, tp)
+			if err != nil && joined != "" {
+				// Read the table on its own if the join does not work
+				joined = ""
+				stmt, has_rowid, err = s.prepareProfile(conn, tbl_name, clm_names, joined, tp)
+			}
+		} else {
+			stmt, has_rowid, joined, err = s.prepareTable(conn, tbl_name, tbl_names, clm_names)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to select data from table: %v", err)
+		}
+	} else {
+		stmt, err = conn.Prepare(s.Query)
+		if err != nil {
+			return fmt.Errorf("failed to select data from table: %v", err)
+		}
+	}
+	defer stmt.Close()
+
+	clm_names := stmt.ColumnNames()
+	decl_types := stmt.DeclTypes()
+	if has_rowid {
+		clm_names = clm_names[:len(clm_names)-1]
+		decl_types = decl_types[:len(decl_types)-1]
+	}
+	if s.Debug {
+		log.Println("cols:", clm_names)
+	}
+
+	rl := s.findRoles(tbl_name, clm_names, tp)
+	geom_column, geom_srid := gp.column(tbl_name)
+	if tp == nil && geom_column != "" {
+		rl.geom = findColumn(clm_names, geom_column)
+	} else if rl.geom < 0 && tp == nil {
+		// GeoPackage and SpatiaLite declare the type of geometry columns
+		rl.geom = geometryColumn(clm_names, decl_types)
+	}
+	if tp == nil && geom_column == "" && rl.geom >= 0 && !IsGeometryColumn("", decl_types[rl.geom]) {
+		// A column only named like a geometry, such as a shape, is one once
+		// one of its values decodes
+		from := quoteName(tbl_name)
+		if s.Query != "" {
+			from = `(` + s.Query + `)`
+		}
+		if ok, err := decodesGeometry(conn, from, clm_names[rl.geom]); err == nil && !ok {
+			if s.Debug {
+				log.Printf("No geometry decodes in %s, it is read as a column", clm_names[rl.geom])
+			}
+			rl.geom = -1
+		}
+	}
+	projs := gp.projector()
+	bad_srids := make(map[int]bool)
+	has_position := rl.lat >= 0 && rl.lon >= 0
+	epochs := make([]string, len(clm_names))
+	is_date := make([]bool, len(clm_names))
+	for i, clm_name := range clm_names {
+		var named bool
+		epochs[i], named = s.Epochs.lookup(tbl_name, clm_name)
+		is_date[i] = named || IsDateColumn(clm_name)
+		if i == rl.date && tp != nil && tp.Time.find(clm_names) == i {
+			is_date[i] = true
+			if !named && tp.Epoch != "" {
+				epochs[i] = tp.Epoch
+			}
+		}
+	}
+	if !has_position && rl.geom < 0 && rl.proto < 0 {
+		if s.Debug {
+			log.Println("Missing lat or long in file")
+		}
+		return nil
+	}
+	// Without a profile the joined transitions are only drawn as events and
+	// the locations of interest have no path between them
+	points := tp == nil && joined == "" || tp != nil && !tp.EventsOnly
+	waypoints := tp != nil && tp.Waypoints || tp == nil && strings.HasSuffix(tbl_name, "OFINTERESTMO")
+
+	if err = out.BeginTable(f, tbl_name); err != nil {
+		return err
+	}
+
+	seg := NewSegmenter(s.EventTime, f, tbl_name, joined, func(ev *Event) error {
+		if s.Debug {
+			log.Println("storing event", ev.Records[0].Time, ev.Records[len(ev.Records)-1].Time)
+		}
+		return out.Event(ev)
+	})
+	seg.Waypoints = waypoints
+
+	count := 0
+
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return fmt.Errorf("step failed while querying data: %v", err)
+		}
+		if !hasRow {
+			break
+		}
+		count++
+
+		// Use Scan to access column data from a row
+		data := make([]interface{}, len(clm_names))
+		var ptr_data []interface{}
+		for i := range data {
+			ptr_data = append(ptr_data, &data[i])
+		}
+		var rowid int64
+		if has_rowid {
+			ptr_data = append(ptr_data, &rowid)
+		}
+		if err = stmt.Scan(ptr_data...); err != nil {
+			return fmt.Errorf("scan failed while querying data: %v", err)
+		}
+
+		// Load the data into a data_map, keeping the order the columns were
+		// read in for the table outputs
+		data_map := map[string]interface{}{
+			"SOURCE_FILE_PATH": f,
+			"SOURCE_TABLE":     tbl_name,
+		}
+		columns := []string{"SOURCE_FILE_PATH", "SOURCE_TABLE"}
+
+		for icol, clm_name := range clm_names {
+			if data[icol] == nil {
+				continue
+			}
+			if _, ok := data_map[clm_name]; ok {
+				// don't overwrite values, useful when two tables are left joined
+				continue
+			}
+			data_map[clm_name] = data[icol]
+			columns = append(columns, clm_name)
+			if is_date[icol] {
+				if t, _, ok := ParseTime(data[icol], epochs[icol]); ok {
+					data_map[clm_name+"_PARSED"] = s.formatTime(t)
+					columns = append(columns, clm_name+"_PARSED")
+				}
+			}
+			if tp != nil {
+				if name, ok := tp.decode(clm_name, data[icol]); ok {
+					data_map[clm_name+"_DECODED"] = name
+					columns = append(columns, clm_name+"_DECODED")
+				}
+			}
+		}
+
+		var kml_coord *kml.Coordinate
+		var c_time time.Time
+		var c_epoch string
+		if rl.date >= 0 {
+			c_time, c_epoch, _ = ParseTime(data[rl.date], epochs[rl.date])
+			if s.TZ != nil && !c_time.IsZero() {
+				c_time = c_time.In(s.TZ)
+			}
+		}
+
+		// A geometry gives the shape, placed at its point or the center of
+		// its bounds, the binary ones are written out as WKT
+		var shape geom.T
+		if rl.geom >= 0 && data[rl.geom] != nil {
+			g, srid, err := DecodeGeometry(data[rl.geom])
+			if s.Debug && err != nil {
+				log.Println("bad geometry", err)
+			}
+			clm_name := clm_names[rl.geom]
+			if _, ok := data[rl.geom].([]byte); ok && err == nil && g == nil {
+				data_map[clm_name] = ""
+			}
+			if err == nil && g != nil {
+				shape = g
+				kml_coord = geometryCoordinate(g)
+				if _, ok := data[rl.geom].([]byte); ok {
+					if text, err := wkt.Marshal(g); err == nil {
+						data_map[clm_name] = text
+					}
+				}
+				if srid == 0 {
+					srid = geom_srid
+				}
+				if srid > 0 {
+					data_map[clm_name+"_SRID"] = srid
+					columns = append(columns, clm_name+"_SRID")
+				}
+
+				// Projected coordinates are converted to degrees for the maps
+				proj, err := projs.get(srid)
+				if err != nil && !bad_srids[srid] {
+					bad_srids[srid] = true
+					log.Printf("Warning: %s in %s table %s, %s\n", clm_name, f, tbl_name, err)
+				} else if datum := projs.datum(srid); datum != "" && !bad_srids[srid] {
+					bad_srids[srid] = true
+					log.Printf("Warning: %s in %s table %s is on the %s datum, read as WGS84 without the datum shift, so the positions may be off by up to a few hundred meters\n",
+						clm_name, f, tbl_name, datum)
+				}
+				switch {
+				case proj != nil:
+					reproject(g, proj)
+					kml_coord = geometryCoordinate(g)
+				case err != nil && !inDegrees(g):
+					shape, kml_coord = nil, nil
+				}
+			}
+		}
+
+		var lat, long float64
+		var lat_ok, long_ok bool
+		if has_position && shape == nil {
+			lat, lat_ok = toFloat(data[rl.lat])
+			if s.Debug && !lat_ok {
+				log.Println("nil lat", data[rl.lat])
+			}
+
+			long, long_ok = toFloat(data[rl.lon])
+			if s.Debug && !long_ok {
+				log.Println("nil long", data[rl.lon])
+			}
+		}
+
+		// Apps keeping the position in a protocol buffer give it in degrees
+		if !has_position && shape == nil && rl.proto >= 0 {
+			if b, ok := data[rl.proto].([]byte); ok {
+				if lat, long, ok = tp.Protobuf.Find(b); ok {
+					lat_ok, long_ok = true, true
+					data_map["LATITUDE_DEG"], data_map["LONGITUDE_DEG"] = lat, long
+					columns = append(columns, "LATITUDE_DEG", "LONGITUDE_DEG")
+				}
+			}
+		}
+
+		if lat_ok && long_ok && tp != nil && tp.noPosition(lat, long) {
+			lat_ok, long_ok = false, false
+		}
+
+		if lat_ok && long_ok {
+			kml_coord = &kml.Coordinate{
+				Lon: long,
+				Lat: lat,
+			}
+			if rl.alt >= 0 {
+				alt, ok := toFloat(data[rl.alt])
+				if s.Debug && !ok {
+					log.Println("nil alt", data[rl.alt])
+				}
+				kml_coord.Alt = alt
+			}
+		}
+
+		// Convert the mapped columns into the common units
+		var label string
+		if tp != nil {
+			if kml_coord != nil && shape == nil {
+				kml_coord.Lat *= tp.scale("lat")
+				kml_coord.Lon *= tp.scale("lon")
+				kml_coord.Alt *= tp.scale("alt")
+				columns = addConverted(data_map, columns, tp, "lat", "LATITUDE_DEG", kml_coord.Lat, true)
+				columns = addConverted(data_map, columns, tp, "lon", "LONGITUDE_DEG", kml_coord.Lon, true)
+				columns = addConverted(data_map, columns, tp, "alt", "ALTITUDE_M", kml_coord.Alt, rl.alt >= 0)
+			}
+			for _, m := range []struct {
+				role, column string
+				icol         int
+			}{{"accuracy", "ACCURACY_M", rl.accuracy}, {"speed", "SPEED_MPS", rl.speed}, {"course", "COURSE_DEG", rl.course}} {
+				if m.icol < 0 {
+					continue
+				}
+				if v, ok := toFloat(data[m.icol]); ok {
+					data_map[m.column] = v * tp.scale(m.role)
+					columns = append(columns, m.column)
+				}
+			}
+			if rl.label >= 0 && data[rl.label] != nil {
+				if name, ok := tp.decode(clm_names[rl.label], data[rl.label]); ok {
+					label = name
+				} else if b, ok := data[rl.label].([]byte); ok {
+					label = string(b)
+				} else {
+					label = fmt.Sprintf("%v", data[rl.label])
+				}
+			}
+		}
+		if s.Debug {
+			log.Println("point: ", kml_coord, "@", c_time, c_epoch)
+		}
+
+		// The civil time where the point was, from the zone of its position
+		var local time.Time
+		if s.LocalTime != nil && kml_coord != nil && !c_time.IsZero() {
+			loc, tz_name := s.LocalTime.Location(kml_coord.Lat, kml_coord.Lon)
+			local = c_time.In(loc)
+			data_map["LOCAL_TIME"] = local.Format(LocalLayout)
+			data_map["LOCAL_TZ"] = tz_name
+			columns = append(columns, "LOCAL_TIME", "LOCAL_TZ")
+		}
+
+		// Lines and shapes are drawn on their own, not joined into a path,
+		// so any points before them are closed off as an event
+		if shape != nil && !isPointGeometry(shape) && !seg.Waypoints {
+			if err = seg.Flush(); err != nil {
+				return err
+			}
+			seg.Waypoints = true
+		}
+
+		r := &Record{
+			Coords:   kml_coord,
+			Geometry: shape,
+			Time:     c_time,
+			Local:    local,
+			Label:    label,
+			Waypoint: waypoints || seg.Waypoints,
+			Data:     data_map,
+			Columns:  columns,
+			File:     f,
+			Table:    tbl_name,
+			Row:      count,
+			Rowid:    rowid,
+		}
+		if err = seg.Add(r); err != nil {
+			return err
+		}
+		if points {
+			if err = out.Record(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err = seg.Flush(); err != nil {
+		return err
+	}
+	return out.EndTable(f, tbl_name, count)
+}
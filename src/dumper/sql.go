@@ -10,10 +10,11 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+package dumper
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/bvinc/go-sqlite-lite/sqlite3"
 	_ "github.com/twpayne/go-geom/encoding/kml"
@@ -80,3 +81,34 @@ func getColumns(conn *sqlite3.Conn, table string) ([]string, error) {
 	}
 	return columns, nil
 }
+
+// findColumn returns the first column with the name, ignoring case, or -1
+func findColumn(clm_names []string, name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, c := range clm_names {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// quoteName quotes a table or column name for a statement
+func quoteName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// getSchema returns the columns of every table
+func getSchema(conn *sqlite3.Conn, tables []string) (map[string][]string, error) {
+	schema := make(map[string][]string)
+	for _, t := range tables {
+		columns, err := getColumns(conn, t)
+		if err != nil {
+			return nil, err
+		}
+		schema[t] = columns
+	}
+	return schema, nil
+}
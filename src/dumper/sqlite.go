@@ -0,0 +1,295 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/twpayne/go-geom/encoding/wkt"
+)
+
+// utcLayout is how the times are written to the database outputs, in UTC
+const utcLayout = "2006-01-02T15:04:05.000Z"
+
+const sqliteSchema = `
+CREATE TABLE sources (
+  id INTEGER PRIMARY KEY,
+  file_path TEXT NOT NULL,
+  file_sha256 TEXT,
+  table_name TEXT,
+  rows INTEGER
+);
+CREATE TABLE events (
+  id INTEGER PRIMARY KEY,
+  source_id INTEGER NOT NULL REFERENCES sources(id),
+  joined_table TEXT,
+  waypoints BOOLEAN,
+  start_time TEXT,
+  end_time TEXT,
+  points INTEGER,
+  distance_m DOUBLE,
+  min_lat DOUBLE,
+  min_lon DOUBLE,
+  max_lat DOUBLE,
+  max_lon DOUBLE
+);
+CREATE TABLE points (
+  id INTEGER PRIMARY KEY,
+  source_id INTEGER NOT NULL REFERENCES sources(id),
+  source_rowid INTEGER,
+  source_row INTEGER,
+  event_id INTEGER REFERENCES events(id),
+  time TEXT,
+  lat DOUBLE,
+  lon DOUBLE,
+  alt DOUBLE,
+  label TEXT,
+  geometry TEXT,
+  data TEXT
+);
+CREATE VIRTUAL TABLE points_rtree USING rtree(id, min_lon, max_lon, min_lat, max_lat);
+`
+
+// sqliteIndexes are made once the rows are in, which is quicker than keeping
+// them up to date along the way
+const sqliteIndexes = `
+CREATE INDEX points_source_id ON points(source_id);
+CREATE INDEX points_event_id ON points(event_id);
+CREATE INDEX points_time ON points(time);
+CREATE INDEX events_source_id ON events(source_id);
+`
+
+// sqliteSink writes every record into one SQLite database, so the rows of all
+// the files can be queried together.  A row in sources is kept for each table
+// of each file, with the SHA-256 of the file, and points refers back to it
+// with the rowid and row number the point was read from.  The original
+// columns are kept as a JSON object in points.data, for the JSON functions of
+// SQLite, and points_rtree indexes the position or the bounds of the shape.
+type sqliteSink struct {
+	NopSink
+	conn   *sqlite3.Conn
+	points *sqlite3.Stmt
+	rtree  *sqlite3.Stmt
+	events *sqlite3.Stmt
+	hashes map[string]string // SHA-256 of each file
+	source int64             // id of the table being read
+	first  int64             // first and last point of the event being read,
+	last   int64             // 0 when none have been written
+}
+
+// NewSQLiteSink returns a sink writing the database to path, any file there
+// is replaced
+func NewSQLiteSink(path string) (Sink, error) {
+	conn, err := createDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &sqliteSink{conn: conn, hashes: make(map[string]string)}
+	if err = s.prepare(); err != nil {
+		s.finalize()
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqliteSink) prepare() (err error) {
+	if err = s.conn.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	if s.points, err = s.conn.Prepare(`INSERT INTO points (source_id, source_rowid, source_row, time,
+		lat, lon, alt, label, geometry, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`); err != nil {
+		return err
+	}
+	if s.rtree, err = s.conn.Prepare(`INSERT INTO points_rtree VALUES (?, ?, ?, ?, ?)`); err != nil {
+		return err
+	}
+	s.events, err = s.conn.Prepare(`INSERT INTO events (source_id, joined_table, waypoints, start_time,
+		end_time, points, distance_m, min_lat, min_lon, max_lat, max_lon) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	return err
+}
+
+// finalize closes the prepared statements
+func (s *sqliteSink) finalize() {
+	for _, stmt := range []*sqlite3.Stmt{s.points, s.rtree, s.events} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// hashFile returns the hex SHA-256 of the contents of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// jsonObject encodes the data as a JSON object with the keys in the order of
+// the columns, values JSON can not hold, such as NaN, are written as null
+func jsonObject(data map[string]interface{}, columns []string) string {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, c := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(c)
+		buf.Write(key)
+		buf.WriteByte(':')
+		if val, err := json.Marshal(data[c]); err == nil {
+			buf.Write(val)
+		} else {
+			buf.WriteString("null")
+		}
+	}
+	buf.WriteByte('}')
+	return buf.String()
+}
+
+func (s *sqliteSink) BeginFile(file string) error {
+	if _, ok := s.hashes[file]; !ok {
+		hash, err := hashFile(file)
+		if err != nil {
+			return err
+		}
+		s.hashes[file] = hash
+	}
+	return nil
+}
+
+func (s *sqliteSink) BeginTable(file, table string) error {
+	var tbl interface{}
+	if table != "" {
+		tbl = table
+	}
+	if err := s.conn.Exec(`INSERT INTO sources (file_path, file_sha256, table_name) VALUES (?, ?, ?)`,
+		file, s.hashes[file], tbl); err != nil {
+		return err
+	}
+	s.source = s.conn.LastInsertRowID()
+	s.first, s.last = 0, 0
+	return nil
+}
+
+func (s *sqliteSink) Record(e *Record) error {
+	var rowid, when, lat, lon, alt, label, shape interface{}
+	if e.Rowid != 0 {
+		rowid = e.Rowid
+	}
+	if !e.Time.IsZero() {
+		when = e.Time.UTC().Format(utcLayout)
+	}
+	if e.Coords != nil {
+		lat, lon, alt = e.Coords.Lat, e.Coords.Lon, e.Coords.Alt
+	}
+	if e.Label != "" {
+		label = e.Label
+	}
+	if e.Geometry != nil {
+		if text, err := wkt.Marshal(e.Geometry); err == nil {
+			shape = text
+		}
+	}
+	if err := s.points.Exec(s.source, rowid, e.Row, when, lat, lon, alt, label, shape,
+		jsonObject(e.Data, e.Columns)); err != nil {
+		return err
+	}
+	id := s.conn.LastInsertRowID()
+	if s.first == 0 {
+		s.first = id
+	}
+	s.last = id
+
+	switch {
+	case e.Geometry != nil:
+		b := e.Geometry.Bounds()
+		return s.rtree.Exec(id, b.Min(0), b.Max(0), b.Min(1), b.Max(1))
+	case e.Coords != nil:
+		return s.rtree.Exec(id, e.Coords.Lon, e.Coords.Lon, e.Coords.Lat, e.Coords.Lat)
+	}
+	return nil
+}
+
+func (s *sqliteSink) Event(ev *Event) error {
+	min_lat, min_lon := math.Inf(1), math.Inf(1)
+	max_lat, max_lon := math.Inf(-1), math.Inf(-1)
+	for _, e := range ev.Records {
+		if e.Coords != nil {
+			min_lat, max_lat = math.Min(min_lat, e.Coords.Lat), math.Max(max_lat, e.Coords.Lat)
+			min_lon, max_lon = math.Min(min_lon, e.Coords.Lon), math.Max(max_lon, e.Coords.Lon)
+		}
+	}
+	var joined, s_time, e_time interface{}
+	bounds := []interface{}{nil, nil, nil, nil}
+	if !math.IsInf(min_lat, 1) {
+		bounds = []interface{}{min_lat, min_lon, max_lat, max_lon}
+	}
+	if ev.Joined != "" {
+		joined = ev.Joined
+	}
+	if first := ev.Records[0].Time; !first.IsZero() {
+		s_time = first.UTC().Format(utcLayout)
+	}
+	if last := ev.Records[len(ev.Records)-1].Time; !last.IsZero() {
+		e_time = last.UTC().Format(utcLayout)
+	}
+	args := append([]interface{}{s.source, joined, ev.Waypoints, s_time, e_time, len(ev.Records), ev.Dist}, bounds...)
+	if err := s.events.Exec(args...); err != nil {
+		return err
+	}
+
+	// Every point is in an event and an event is handed over before the
+	// point after it, so the points written since the last one are its own
+	first, last := s.first, s.last
+	s.first, s.last = 0, 0
+	if first == 0 {
+		// The table only has its events written
+		return nil
+	}
+	return s.conn.Exec(`UPDATE points SET event_id = ? WHERE id BETWEEN ? AND ?`,
+		s.conn.LastInsertRowID(), first, last)
+}
+
+func (s *sqliteSink) EndTable(file, table string, rows int) error {
+	return s.conn.Exec(`UPDATE sources SET rows = ? WHERE id = ?`, rows, s.source)
+}
+
+// Close adds the indexes and commits the database
+func (s *sqliteSink) Close() error {
+	s.finalize()
+	err := s.conn.Exec(sqliteIndexes)
+	if err != nil {
+		s.conn.Rollback()
+	} else {
+		err = s.conn.Commit()
+	}
+	if e := s.conn.Close(); err == nil {
+		err = e
+	}
+	return err
+}
@@ -0,0 +1,159 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bvinc/go-sqlite-lite/sqlite3"
+)
+
+// createDatabase opens a new SQLite file for an output, removing any file
+// already there, with the writes put in one transaction until it is closed
+func createDatabase(path string) (*sqlite3.Conn, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	conn, err := sqlite3.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = conn.Exec(`PRAGMA journal_mode = OFF; PRAGMA synchronous = OFF`); err == nil {
+		err = conn.Begin()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// sqlType is the declared type of a column for the first value put in it
+func sqlType(v interface{}) string {
+	switch v.(type) {
+	case int, int64:
+		return "INTEGER"
+	case float64:
+		return "DOUBLE"
+	case bool:
+		return "BOOLEAN"
+	case []byte:
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+// sqlValue returns the value as one SQLite can bind
+func sqlValue(v interface{}) interface{} {
+	switch v.(type) {
+	case nil, int, int64, float64, bool, string, []byte:
+		return v
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// sqlTable is a table written by an output, the fixed columns are set by the
+// output and the data columns are added as they are first seen
+type sqlTable struct {
+	conn    *sqlite3.Conn
+	name    string
+	fixed   []string
+	columns []string          // data columns in the order they were added
+	names   map[string]string // table column of each data column
+	used    map[string]bool   // lower case names taken, as SQLite ignores the case
+	insert  *sqlite3.Stmt
+}
+
+// newSQLTable creates the table, columns are the definitions of the fixed
+// columns, such as `fid INTEGER PRIMARY KEY AUTOINCREMENT`
+func newSQLTable(conn *sqlite3.Conn, name string, columns ...string) (*sqlTable, error) {
+	t := &sqlTable{conn: conn, name: name, names: make(map[string]string), used: make(map[string]bool)}
+	for _, c := range columns {
+		n := strings.Fields(c)[0]
+		t.fixed = append(t.fixed, n)
+		t.used[strings.ToLower(n)] = true
+	}
+	if err := conn.Exec(`CREATE TABLE ` + quoteName(name) + ` (` + strings.Join(columns, ", ") + `)`); err != nil {
+		return nil, fmt.Errorf("failed to create table %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// add makes sure the data column is in the table, typed by its value
+func (t *sqlTable) add(column string, v interface{}) error {
+	if _, ok := t.names[column]; ok {
+		return nil
+	}
+	name := column
+	for i := 1; t.used[strings.ToLower(name)]; i++ {
+		name = fmt.Sprintf("%s_%d", column, i)
+	}
+	if err := t.conn.Exec(`ALTER TABLE ` + quoteName(t.name) + ` ADD COLUMN ` + quoteName(name) + ` ` + sqlType(v)); err != nil {
+		return fmt.Errorf("failed to add column %s to %s: %v", name, t.name, err)
+	}
+	t.names[column] = name
+	t.used[strings.ToLower(name)] = true
+	t.columns = append(t.columns, column)
+	if t.insert != nil {
+		t.insert.Close()
+		t.insert = nil
+	}
+	return nil
+}
+
+// write inserts a row of the fixed values followed by the data, the data
+// columns are added first when they are new
+func (t *sqlTable) write(data map[string]interface{}, columns []string, fixed ...interface{}) error {
+	for _, c := range columns {
+		if v, ok := data[c]; ok && v != nil {
+			if err := t.add(c, v); err != nil {
+				return err
+			}
+		}
+	}
+	if t.insert == nil {
+		names := append([]string{}, t.fixed...)
+		for _, c := range t.columns {
+			names = append(names, t.names[c])
+		}
+		for i := range names {
+			names[i] = quoteName(names[i])
+		}
+		stmt, err := t.conn.Prepare(`INSERT INTO ` + quoteName(t.name) + ` (` + strings.Join(names, ", ") +
+			`) VALUES (?` + strings.Repeat(", ?", len(names)-1) + `)`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare insert into %s: %v", t.name, err)
+		}
+		t.insert = stmt
+	}
+	args := append([]interface{}{}, fixed...)
+	for _, c := range t.columns {
+		args = append(args, sqlValue(data[c]))
+	}
+	if err := t.insert.Exec(args...); err != nil {
+		return fmt.Errorf("failed to insert into %s: %v", t.name, err)
+	}
+	return nil
+}
+
+// close finalizes the insert statement
+func (t *sqlTable) close() error {
+	if t.insert == nil {
+		return nil
+	}
+	err := t.insert.Close()
+	t.insert = nil
+	return err
+}
@@ -0,0 +1,254 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/twpayne/go-kml"
+)
+
+// Style rule targets
+const (
+	stylePointColor = "point-color"
+	stylePointScale = "point-scale"
+	styleLineColor  = "line-color"
+	styleLineWidth  = "line-width"
+)
+
+// styleHour is the pseudo column for the hour of day of the entry time
+const styleHour = "hour"
+
+// styleRule maps the value of a column onto a color, scale or width for the
+// points or path segments drawn on a map
+type styleRule struct {
+	target   string
+	column   string
+	min, max float64
+}
+
+// parseStyleRule parses a rule in the form TARGET=COLUMN[:MIN:MAX], the range
+// is only optional for the hour column
+func parseStyleRule(rule string) (*styleRule, error) {
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("style rule %q is not in the form TARGET=COLUMN[:MIN:MAX]", rule)
+	}
+	r := &styleRule{target: strings.ToLower(strings.TrimSpace(parts[0]))}
+	switch r.target {
+	case stylePointColor, stylePointScale, styleLineColor, styleLineWidth:
+	case "icon-scale":
+		r.target = stylePointScale
+	default:
+		return nil, fmt.Errorf("unknown style target %q, expected one of %s, %s, %s or %s", r.target,
+			stylePointColor, stylePointScale, styleLineColor, styleLineWidth)
+	}
+
+	args := strings.Split(parts[1], ":")
+	r.column = strings.TrimSpace(args[0])
+	switch len(args) {
+	case 1:
+		if strings.ToLower(r.column) != styleHour {
+			return nil, fmt.Errorf("style rule %q needs a MIN:MAX range for column %q", rule, r.column)
+		}
+		r.column, r.min, r.max = styleHour, 0, 24
+	case 3:
+		var err error
+		if r.min, err = strconv.ParseFloat(args[1], 64); err != nil {
+			return nil, fmt.Errorf("style rule %q has an invalid minimum: %v", rule, err)
+		}
+		if r.max, err = strconv.ParseFloat(args[2], 64); err != nil {
+			return nil, fmt.Errorf("style rule %q has an invalid maximum: %v", rule, err)
+		}
+		if r.min == r.max {
+			return nil, fmt.Errorf("style rule %q has an empty range", rule)
+		}
+	default:
+		return nil, fmt.Errorf("style rule %q is not in the form TARGET=COLUMN[:MIN:MAX]", rule)
+	}
+	return r, nil
+}
+
+// value returns the column value for an entry, false if it is not numeric
+func (r *styleRule) value(e *Record) (float64, bool) {
+	if v, ok := e.Data[r.column]; ok {
+		switch val := v.(type) {
+		case float64:
+			return val, true
+		case int64:
+			return float64(val), true
+		case int:
+			return float64(val), true
+		}
+		return 0, false
+	}
+	if r.column == styleHour && !e.Time.IsZero() {
+		return float64(e.Time.Hour()) + float64(e.Time.Minute())/60, true
+	}
+	return 0, false
+}
+
+// fraction returns where the value falls in the rule range, from 0 to 1
+func (r *styleRule) fraction(v float64) float64 {
+	f := (v - r.min) / (r.max - r.min)
+	return math.Max(0, math.Min(1, f))
+}
+
+// color turns a fraction into a color.  The hour of day goes around the color
+// wheel so midnight meets itself, anything else goes from green to red.
+func (r *styleRule) color(f float64) color.RGBA {
+	if r.column == styleHour {
+		return hueColor(f * 360)
+	}
+	return hueColor(120 * (1 - f))
+}
+
+// hueColor returns a fully saturated color for a hue in degrees
+func hueColor(h float64) color.RGBA {
+	h = math.Mod(h, 360) / 60
+	x := 1 - math.Abs(math.Mod(h, 2)-1)
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	return color.RGBA{R: uint8(r * 255), G: uint8(g * 255), B: uint8(b * 255), A: 0xff}
+}
+
+// StyleRules is a set of style rules for the map outputs
+type StyleRules []*styleRule
+
+// ParseStyleRules parses every rule in the form TARGET=COLUMN[:MIN:MAX],
+// stopping at the first error
+func ParseStyleRules(rules []string) (StyleRules, error) {
+	var ret StyleRules
+	for _, rule := range rules {
+		r, err := parseStyleRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, r)
+	}
+	return ret, nil
+}
+
+// hasLine returns true if any rule applies to path segments
+func (rs StyleRules) hasLine() bool {
+	for _, r := range rs {
+		if r.target == styleLineColor || r.target == styleLineWidth {
+			return true
+		}
+	}
+	return false
+}
+
+// pointStyle returns the color and scale for a point, nil when no rule applies
+func (rs StyleRules) pointStyle(e *Record) (c *color.RGBA, scale *float64) {
+	for _, r := range rs {
+		v, ok := r.value(e)
+		if !ok {
+			continue
+		}
+		switch r.target {
+		case stylePointColor:
+			col := r.color(r.fraction(v))
+			c = &col
+		case stylePointScale:
+			s := 0.5 + 1.5*r.fraction(v)
+			scale = &s
+		}
+	}
+	return
+}
+
+// segmentStyle returns the color and width for the segment between two
+// points using the mean of their values, nil when no rule applies
+func (rs StyleRules) segmentStyle(a, b *Record) (c *color.RGBA, width *float64) {
+	for _, r := range rs {
+		va, ok_a := r.value(a)
+		vb, ok_b := r.value(b)
+		var v float64
+		switch {
+		case ok_a && ok_b:
+			v = (va + vb) / 2
+		case ok_a:
+			v = va
+		case ok_b:
+			v = vb
+		default:
+			continue
+		}
+		switch r.target {
+		case styleLineColor:
+			col := r.color(r.fraction(v))
+			c = &col
+		case styleLineWidth:
+			w := 1 + 7*r.fraction(v)
+			width = &w
+		}
+	}
+	return
+}
+
+// kmlPointStyle returns an inline KML style for a point, nil if none applies.
+// The icon itself comes from the shared style the point also refers to.
+func (rs StyleRules) kmlPointStyle(e *Record) kml.Element {
+	c, scale := rs.pointStyle(e)
+	if c == nil && scale == nil {
+		return nil
+	}
+	icon := kml.IconStyle()
+	if c != nil {
+		icon.Add(kml.Color(*c))
+	}
+	if scale != nil {
+		icon.Add(kml.Scale(*scale))
+	}
+	return kml.Style(icon)
+}
+
+// kmlSegmentStyle returns an inline KML style for a segment, nil if none
+// applies
+func (rs StyleRules) kmlSegmentStyle(a, b *Record) kml.Element {
+	c, width := rs.segmentStyle(a, b)
+	if c == nil && width == nil {
+		return nil
+	}
+	line := kml.LineStyle()
+	if c != nil {
+		line.Add(kml.Color(*c))
+	}
+	if width != nil {
+		line.Add(kml.Width(*width))
+	}
+	return kml.Style(line)
+}
+
+// hexColor formats a color as #rrggbb for the GeoJSON simplestyle properties
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
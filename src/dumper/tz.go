@@ -0,0 +1,379 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zoneTab is the tzdb zone.tab, the public domain list of zones with the
+// position of the city each one is named after
+//
+//go:embed zone.tab
+var zoneTab string
+
+// tzBounds is the time zone polygons of timezone-boundary-builder, with the
+// oceans, simplified to about 300m by tzbounds_gen.go.  The data is under the
+// ODbL, see tzbounds.LICENSE.
+//
+//go:generate go run tzbounds_gen.go combined-with-oceans.json
+//go:embed tzbounds.bin.gz
+var tzBounds []byte
+
+// LocalLayout is the layout of the times rendered with their UTC offset
+const LocalLayout = "2006-01-02 15:04:05 -07:00"
+
+// seaDistance is how far, in radians of arc, a point can be from the nearest
+// zone city before it is taken to be at sea, about 2000km
+const seaDistance = 2000.0 / 6371
+
+// tzCity is a row of the zone table
+type tzCity struct {
+	name     string
+	lat, lon float64
+}
+
+// tzBand is the height in degrees of the bands of latitude the edges of a
+// polygon are split into, so a point is only tested against the edges beside it
+const tzBand = 0.25
+
+// tzEdge is an edge of a ring of a polygon
+type tzEdge struct {
+	lon1, lat1, lon2, lat2 float64
+}
+
+// tzArea is a polygon of a time zone, with its edges by band of latitude
+type tzArea struct {
+	name           string
+	bands          [][]tzEdge
+	minLat, minLon float64
+	maxLat, maxLon float64
+}
+
+// newTZArea returns the polygon of the rings, outer ring first, of [lon, lat]
+// points
+func newTZArea(name string, rings [][][2]float64) tzArea {
+	a := tzArea{name: name, minLat: 90, minLon: 180, maxLat: -90, maxLon: -180}
+	for _, ring := range rings {
+		for _, pt := range ring {
+			a.minLon, a.maxLon = math.Min(a.minLon, pt[0]), math.Max(a.maxLon, pt[0])
+			a.minLat, a.maxLat = math.Min(a.minLat, pt[1]), math.Max(a.maxLat, pt[1])
+		}
+	}
+	a.bands = make([][]tzEdge, a.band(a.maxLat)+1)
+	for _, ring := range rings {
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			e := tzEdge{ring[j][0], ring[j][1], ring[i][0], ring[i][1]}
+			for b := a.band(math.Min(e.lat1, e.lat2)); b <= a.band(math.Max(e.lat1, e.lat2)); b++ {
+				a.bands[b] = append(a.bands[b], e)
+			}
+		}
+	}
+	return a
+}
+
+// band returns the band of a latitude within the polygon
+func (a *tzArea) band(lat float64) int {
+	if lat <= a.minLat {
+		return 0
+	}
+	return int((lat - a.minLat) / tzBand)
+}
+
+// tzIndex is a set of time zone polygons, with the polygons whose bounds
+// overlap each square degree
+type tzIndex struct {
+	areas []tzArea
+	cells map[int][]int
+}
+
+func tzCell(lat, lon int) int {
+	return lat*360 + lon
+}
+
+func (x *tzIndex) add(a tzArea) {
+	if x.cells == nil {
+		x.cells = make(map[int][]int)
+	}
+	x.areas = append(x.areas, a)
+	for lat := int(math.Floor(a.minLat)); lat <= int(math.Floor(a.maxLat)); lat++ {
+		for lon := int(math.Floor(a.minLon)); lon <= int(math.Floor(a.maxLon)); lon++ {
+			cell := tzCell(lat, lon)
+			x.cells[cell] = append(x.cells[cell], len(x.areas)-1)
+		}
+	}
+}
+
+// find returns the zone of the polygon containing the point, the ocean zones
+// are only used when no land zone has it, as the simplified polygons can
+// overlap a little along the coasts
+func (x *tzIndex) find(lat, lon float64) string {
+	ocean := ""
+	for _, i := range x.cells[tzCell(int(math.Floor(lat)), int(math.Floor(lon)))] {
+		a := &x.areas[i]
+		if !a.contains(lat, lon) {
+			continue
+		}
+		if !strings.HasPrefix(a.name, "Etc/") {
+			return a.name
+		}
+		if ocean == "" {
+			ocean = a.name
+		}
+	}
+	return ocean
+}
+
+// TZFinder looks up the local time zone of a position, offline, from the
+// built in time zone boundaries or those of a boundary file, which are used
+// first.  A point in no polygon, such as in a sliver the simplification left
+// between two zones, takes the zone of the nearest zone.tab city as an
+// approximation, or the nautical zone of its longitude when far from any.
+type TZFinder struct {
+	cities   []tzCity
+	override tzIndex // from LoadBoundaries
+	bounds   tzIndex // built in
+
+	mu   sync.Mutex
+	locs map[string]*time.Location
+}
+
+// NewTZFinder returns a finder using the embedded time zone boundaries and
+// zone table
+func NewTZFinder() *TZFinder {
+	f := &TZFinder{locs: make(map[string]*time.Location)}
+	if err := f.bounds.read(tzBounds); err != nil {
+		panic(fmt.Sprintf("reading the built in time zone boundaries, %s", err))
+	}
+	for _, line := range strings.Split(zoneTab, "\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.Split(line, "\t")
+		if len(parts) < 3 {
+			continue
+		}
+		lat, lon, ok := parseISO6709(parts[1])
+		if !ok {
+			continue
+		}
+		f.cities = append(f.cities, tzCity{name: parts[2], lat: lat, lon: lon})
+	}
+	return f
+}
+
+// parseISO6709 reads the ±DDMM[SS]±DDDMM[SS] positions of the zone table
+func parseISO6709(s string) (lat, lon float64, ok bool) {
+	i := strings.IndexAny(s[1:], "+-") + 1
+	if i < 1 {
+		return 0, 0, false
+	}
+	lat, ok = parseDMS(s[:i], 2)
+	if !ok {
+		return 0, 0, false
+	}
+	lon, ok = parseDMS(s[i:], 3)
+	return lat, lon, ok
+}
+
+// parseDMS reads one signed degrees, minutes and optional seconds value
+func parseDMS(s string, deg int) (float64, bool) {
+	digits := s[1:]
+	if len(digits) != deg+2 && len(digits) != deg+4 {
+		return 0, false
+	}
+	v := 0.0
+	for i, scale := 0, 1.0; i < len(digits); scale *= 60 {
+		n := deg
+		if i > 0 {
+			n = 2
+		}
+		p, err := strconv.Atoi(digits[i : i+n])
+		if err != nil {
+			return 0, false
+		}
+		v += float64(p) / scale
+		i += n
+	}
+	if s[0] == '-' {
+		v = -v
+	}
+	return v, true
+}
+
+// read adds the polygons of the format written by tzbounds_gen.go
+func (x *tzIndex) read(data []byte) error {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(zr)
+	count := func() int {
+		if err != nil {
+			return 0
+		}
+		var n uint64
+		n, err = binary.ReadUvarint(r)
+		return int(n)
+	}
+	coord := func() int64 {
+		if err != nil {
+			return 0
+		}
+		var v int64
+		v, err = binary.ReadVarint(r)
+		return v
+	}
+	for zones := count(); zones > 0 && err == nil; zones-- {
+		name := make([]byte, count())
+		if err == nil {
+			_, err = io.ReadFull(r, name)
+		}
+		for polys := count(); polys > 0 && err == nil; polys-- {
+			rings := make([][][2]float64, count())
+			for i := range rings {
+				ring := make([][2]float64, count())
+				var lon, lat int64
+				for j := range ring {
+					lon += coord()
+					lat += coord()
+					ring[j] = [2]float64{float64(lon) / 1e4, float64(lat) / 1e4}
+				}
+				rings[i] = ring
+			}
+			if err == nil {
+				x.add(newTZArea(string(name), rings))
+			}
+		}
+	}
+	return err
+}
+
+// LoadBoundaries reads a GeoJSON file of time zone polygons, such as the
+// releases of timezone-boundary-builder, with the zone name in the tzid
+// property.  Points inside a polygon use its zone in place of the built in
+// boundaries.
+func (f *TZFinder) LoadBoundaries(r io.Reader) error {
+	var fc struct {
+		Features []struct {
+			Properties struct {
+				TZID string `json:"tzid"`
+			} `json:"properties"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(r).Decode(&fc); err != nil {
+		return fmt.Errorf("reading time zone boundaries, %s", err)
+	}
+	for _, ft := range fc.Features {
+		if ft.Properties.TZID == "" {
+			continue
+		}
+		var polys [][][][2]float64
+		switch ft.Geometry.Type {
+		case "Polygon":
+			var p [][][2]float64
+			if err := json.Unmarshal(ft.Geometry.Coordinates, &p); err != nil {
+				return fmt.Errorf("reading time zone %q, %s", ft.Properties.TZID, err)
+			}
+			polys = append(polys, p)
+		case "MultiPolygon":
+			if err := json.Unmarshal(ft.Geometry.Coordinates, &polys); err != nil {
+				return fmt.Errorf("reading time zone %q, %s", ft.Properties.TZID, err)
+			}
+		default:
+			continue
+		}
+		for _, p := range polys {
+			f.override.add(newTZArea(ft.Properties.TZID, p))
+		}
+	}
+	return nil
+}
+
+// contains tests the point against the rings by the even-odd rule, so the
+// holes of the polygon are left out
+func (a *tzArea) contains(lat, lon float64) bool {
+	if lat < a.minLat || lat > a.maxLat || lon < a.minLon || lon > a.maxLon {
+		return false
+	}
+	in := false
+	for _, e := range a.bands[a.band(lat)] {
+		if (e.lat2 > lat) != (e.lat1 > lat) &&
+			lon < (e.lon1-e.lon2)*(lat-e.lat2)/(e.lat1-e.lat2)+e.lon2 {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Lookup returns the name of the time zone of a position
+func (f *TZFinder) Lookup(lat, lon float64) string {
+	if name := f.override.find(lat, lon); name != "" {
+		return name
+	}
+	if name := f.bounds.find(lat, lon); name != "" {
+		return name
+	}
+
+	best, best_dist := "", math.Inf(1)
+	for _, c := range f.cities {
+		if d := ArcDistance(lat, lon, c.lat, c.lon); d < best_dist {
+			best, best_dist = c.name, d
+		}
+	}
+	if best_dist <= seaDistance {
+		return best
+	}
+
+	// Nautical time, the signs of the Etc zones are reversed from the offset
+	switch h := int(math.Round(lon / 15)); {
+	case h > 0:
+		return fmt.Sprintf("Etc/GMT-%d", h)
+	case h < 0:
+		return fmt.Sprintf("Etc/GMT+%d", -h)
+	}
+	return "Etc/GMT"
+}
+
+// Location returns the time zone of a position along with its name
+func (f *TZFinder) Location(lat, lon float64) (*time.Location, string) {
+	name := f.Lookup(lat, lon)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if loc, ok := f.locs[name]; ok {
+		return loc, name
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		// Without the zone rules fall back to the nautical offset
+		loc = time.FixedZone(name, int(math.Round(lon/15))*3600)
+	}
+	f.locs[name] = loc
+	return loc, name
+}
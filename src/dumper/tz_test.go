@@ -0,0 +1,88 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"strings"
+	"testing"
+)
+
+// Places a few km from a zone border, with their zone in the unsimplified
+// timezone-boundary-builder polygons
+var tzBorderTests = []struct {
+	place    string
+	lat, lon float64
+	zone     string
+}{
+	{"Amarillo", 35.2220, -101.8313, "America/Chicago"},
+	{"El Paso", 31.7619, -106.4850, "America/Denver"},
+	{"Ciudad Juarez", 31.6904, -106.4245, "America/Ciudad_Juarez"},
+	{"Gary", 41.5934, -87.3464, "America/Chicago"},
+	{"Indianapolis", 39.7684, -86.1581, "America/Indiana/Indianapolis"},
+	{"Windsor", 42.3000, -83.0167, "America/Toronto"},
+	{"Detroit", 42.3486, -83.0567, "America/Detroit"},
+	{"Tijuana", 32.5149, -117.0382, "America/Tijuana"},
+	{"San Diego", 32.7157, -117.1611, "America/Los_Angeles"},
+	{"Tuba City", 36.1350, -111.2396, "America/Denver"},
+	{"Lake Havasu", 34.4839, -114.3225, "America/Phoenix"},
+	{"Needles", 34.8481, -114.6141, "America/Los_Angeles"},
+	{"Strasbourg", 48.5734, 7.7521, "Europe/Paris"},
+	{"Kehl", 48.5722, 7.8150, "Europe/Berlin"},
+	{"Sovetsk", 55.0814, 21.8855, "Europe/Kaliningrad"},
+	{"Blagoveshchensk", 50.2907, 127.5272, "Asia/Yakutsk"},
+	{"Heihe", 50.2449, 127.4888, "Asia/Shanghai"},
+	{"Pacific", 0, -140, "Etc/GMT+9"},
+}
+
+func TestTZFinderBorders(t *testing.T) {
+	f := NewTZFinder()
+	for _, tc := range tzBorderTests {
+		if got := f.Lookup(tc.lat, tc.lon); got != tc.zone {
+			t.Errorf("%s (%v, %v) is in %s, want %s", tc.place, tc.lat, tc.lon, got, tc.zone)
+		}
+	}
+}
+
+func TestTZFinderOverride(t *testing.T) {
+	f := NewTZFinder()
+	square := `{"type": "FeatureCollection", "features": [{"type": "Feature",
+		"properties": {"tzid": "America/Denver"},
+		"geometry": {"type": "Polygon", "coordinates": [[[-102, 35], [-101, 35], [-101, 36], [-102, 36], [-102, 35]],
+			[[-101.9, 35.1], [-101.9, 35.15], [-101.85, 35.15], [-101.85, 35.1], [-101.9, 35.1]]]}}]}`
+	if err := f.LoadBoundaries(strings.NewReader(square)); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Lookup(35.2220, -101.8313); got != "America/Denver" {
+		t.Errorf("point in the boundary file is in %s", got)
+	}
+	// The hole of the polygon and the points outside it use the built in ones
+	if got := f.Lookup(35.12, -101.88); got != "America/Chicago" {
+		t.Errorf("point in the hole of the boundary file is in %s", got)
+	}
+	if got := f.Lookup(31.7619, -106.4850); got != "America/Denver" {
+		t.Errorf("point outside the boundary file is in %s", got)
+	}
+}
+
+func TestTZFinderFallback(t *testing.T) {
+	// Without any polygons the nearest city and then the nautical zone is
+	// used
+	f := NewTZFinder()
+	f.bounds = tzIndex{}
+	if got := f.Lookup(40.7, -74.1); got != "America/New_York" {
+		t.Errorf("point beside New York is in %s", got)
+	}
+	if got := f.Lookup(-50, -140); got != "Etc/GMT+9" {
+		t.Errorf("point in the south Pacific is in %s", got)
+	}
+}
@@ -0,0 +1,206 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build ignore
+// +build ignore
+
+// tzbounds_gen simplifies the time zone polygons of a timezone-boundary-builder
+// release, the timezones-with-oceans GeoJSON, into the tzbounds.bin.gz built
+// into the dumper package:
+//
+//	go run tzbounds_gen.go combined-with-oceans.json
+//
+// Every ring is simplified with Douglas-Peucker to within tolerance degrees
+// and the points are rounded to 1e-4 degrees, about 11m.  The file is gzipped,
+// holding the count of zones and for each zone the length of its name, the
+// name and the count of polygons, for each polygon the count of rings, outer
+// ring first, and for each ring the count of points followed by the points as
+// the signed varint differences from the last point of the ring, longitude
+// then latitude, in 1e-4 degrees.
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+)
+
+const (
+	tolerance = 0.003
+	scale     = 1e4
+)
+
+type point [2]float64
+
+// simplify keeps the points of a closed ring which are further than
+// tolerance from the line through the points kept around them
+func simplify(ring []point) []point {
+	n := len(ring)
+	if n <= 4 {
+		return ring
+	}
+	keep := make([]bool, n)
+	far, far_dist := 0, -1.0
+	for i, p := range ring {
+		if d := math.Hypot(p[0]-ring[0][0], p[1]-ring[0][1]); d > far_dist {
+			far, far_dist = i, d
+		}
+	}
+	keep[0], keep[far], keep[n-1] = true, true, true
+	stack := [][2]int{{0, far}, {far, n - 1}}
+	for len(stack) > 0 {
+		a, b := stack[len(stack)-1][0], stack[len(stack)-1][1]
+		stack = stack[:len(stack)-1]
+		if b <= a+1 {
+			continue
+		}
+		dx, dy := ring[b][0]-ring[a][0], ring[b][1]-ring[a][1]
+		length := math.Hypot(dx, dy)
+		best, best_dist := -1, -1.0
+		for i := a + 1; i < b; i++ {
+			var d float64
+			if length == 0 {
+				d = math.Hypot(ring[i][0]-ring[a][0], ring[i][1]-ring[a][1])
+			} else {
+				d = math.Abs(dy*ring[i][0]-dx*ring[i][1]+ring[b][0]*ring[a][1]-ring[b][1]*ring[a][0]) / length
+			}
+			if d > best_dist {
+				best, best_dist = i, d
+			}
+		}
+		if best_dist > tolerance {
+			keep[best] = true
+			stack = append(stack, [2]int{a, best}, [2]int{best, b})
+		}
+	}
+	var out []point
+	for i, p := range ring {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: go run tzbounds_gen.go timezones.geojson")
+	}
+	b, err := ioutil.ReadFile(os.Args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+	var fc struct {
+		Features []struct {
+			Properties struct {
+				TZID string `json:"tzid"`
+			} `json:"properties"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err = json.Unmarshal(b, &fc); err != nil {
+		log.Fatal(err)
+	}
+
+	zones := make(map[string][][][]point)
+	for _, ft := range fc.Features {
+		var polys [][][]point
+		switch ft.Geometry.Type {
+		case "Polygon":
+			var p [][]point
+			err = json.Unmarshal(ft.Geometry.Coordinates, &p)
+			polys = append(polys, p)
+		case "MultiPolygon":
+			err = json.Unmarshal(ft.Geometry.Coordinates, &polys)
+		}
+		if err != nil {
+			log.Fatalf("reading %s, %s", ft.Properties.TZID, err)
+		}
+		zones[ft.Properties.TZID] = append(zones[ft.Properties.TZID], polys...)
+	}
+	var names []string
+	for name := range zones {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+	uvarint := func(v int) { out.Write(tmp[:binary.PutUvarint(tmp[:], uint64(v))]) }
+	varint := func(v int64) { out.Write(tmp[:binary.PutVarint(tmp[:], v)]) }
+	points := 0
+	uvarint(len(names))
+	for _, name := range names {
+		uvarint(len(name))
+		out.WriteString(name)
+		var polys [][][][2]int64
+		for _, poly := range zones[name] {
+			var rings [][][2]int64
+			for _, ring := range poly {
+				var r [][2]int64
+				for _, p := range simplify(ring) {
+					q := [2]int64{int64(math.Round(p[0] * scale)), int64(math.Round(p[1] * scale))}
+					if len(r) == 0 || q != r[len(r)-1] {
+						r = append(r, q)
+					}
+				}
+				if len(r) >= 4 {
+					rings = append(rings, r)
+				} else if len(rings) == 0 {
+					// The outer ring is too small to keep, and so are its holes
+					break
+				}
+			}
+			if len(rings) > 0 {
+				polys = append(polys, rings)
+			}
+		}
+		uvarint(len(polys))
+		for _, rings := range polys {
+			uvarint(len(rings))
+			for _, r := range rings {
+				uvarint(len(r))
+				var last [2]int64
+				for _, q := range r {
+					varint(q[0] - last[0])
+					varint(q[1] - last[1])
+					last = q
+				}
+				points += len(r)
+			}
+		}
+	}
+
+	f, err := os.Create("tzbounds.bin.gz")
+	if err != nil {
+		log.Fatal(err)
+	}
+	zw, _ := gzip.NewWriterLevel(f, gzip.BestCompression)
+	zw.Write(out.Bytes())
+	if err = zw.Close(); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d zones, %d points\n", len(names), points)
+}
@@ -0,0 +1,112 @@
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dumper
+
+import (
+	"time"
+
+	excelize "github.com/xuri/excelize/v2"
+)
+
+// xlsxSink writes the rows to a sheet with the excelize stream writer, which
+// keeps the sheet in a temporary file rather than in memory.  As with CSV the
+// rows are spooled until the end unless the columns are given up front.
+type xlsxSink struct {
+	NopSink
+	path    string
+	f       *excelize.File
+	sw      *excelize.StreamWriter
+	columns []string
+	spool   *recordSpool
+	row     int
+}
+
+// NewXLSXSink returns a sink saving a workbook to path once it is closed,
+// version is the version of the program put in the document properties and
+// columns may be nil to use every column which has a value
+func NewXLSXSink(path, sheet, version string, columns []string) (Sink, error) {
+	f := excelize.NewFile()
+	f.SetDocProps(&excelize.DocProperties{
+		Category:       "data",
+		ContentStatus:  "Final",
+		Created:        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Creator:        "GEO Sqlite Dumper " + version,
+		Description:    "This file was created by GEO Sqlite Dumper " + version + " (https://github.com/pschou/geo-sqlite-dumper)",
+		Identifier:     "xlsx",
+		Modified:       time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		LastModifiedBy: "GEO Sqlite Dumper " + version,
+		Revision:       "0",
+		Subject:        "Conversion from an SQLite file to XLSX",
+		Title:          "GEO Sqlite Dumper",
+		Language:       "en-US",
+		Version:        "1.0.0",
+	})
+	f.SetActiveSheet(f.NewSheet(sheet))
+	sw, err := f.NewStreamWriter(sheet)
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxSink{path: path, f: f, sw: sw, columns: columns}
+	if len(columns) > 0 {
+		return x, x.writeHeader()
+	}
+	x.spool, err = newRecordSpool()
+	return x, err
+}
+
+// setRow writes the values to the next row of the sheet
+func (x *xlsxSink) setRow(values []interface{}) error {
+	x.row++
+	cell, _ := excelize.CoordinatesToCellName(1, x.row)
+	return x.sw.SetRow(cell, values)
+}
+
+func (x *xlsxSink) writeHeader() error {
+	values := make([]interface{}, len(x.columns))
+	for i, clm_name := range x.columns {
+		values[i] = clm_name
+	}
+	return x.setRow(values)
+}
+
+func (x *xlsxSink) writeRow(data map[string]interface{}) error {
+	values := make([]interface{}, len(x.columns))
+	for i, clm_name := range x.columns {
+		values[i] = data[clm_name]
+	}
+	return x.setRow(values)
+}
+
+func (x *xlsxSink) Record(e *Record) error {
+	if x.spool != nil {
+		return x.spool.add(e)
+	}
+	return x.writeRow(e.Data)
+}
+
+func (x *xlsxSink) Close() error {
+	if x.spool != nil {
+		defer x.spool.close()
+		x.columns = x.spool.columns.names
+		if err := x.writeHeader(); err != nil {
+			return err
+		}
+		if err := x.spool.replay(x.writeRow); err != nil {
+			return err
+		}
+	}
+	if err := x.sw.Flush(); err != nil {
+		return err
+	}
+	return x.f.SaveAs(x.path)
+}
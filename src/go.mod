@@ -1,4 +1,4 @@
-module main
+module github.com/pschou/geo-sqlite-dumper/src
 
 go 1.16
 
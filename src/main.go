@@ -14,20 +14,18 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"log"
-	"math"
 	"os"
 	"strings"
 	"time"
+	_ "time/tzdata"
 
-	"github.com/bvinc/go-sqlite-lite/sqlite3"
+	"github.com/pschou/geo-sqlite-dumper/src/dumper"
 	"github.com/pschou/go-params"
-	"github.com/twpayne/go-kml"
-	excelize "github.com/xuri/excelize/v2"
 )
 
-var debug, escape_ascii *bool
 var version = ""
 
 func main() {
@@ -38,54 +36,151 @@ func main() {
 			"Usage: %s [options...] [files...]\n\n", version, os.Args[0])
 		params.PrintDefaults()
 	}
-	debug = params.Pres("debug", "Verbose output")
+	debug := params.Pres("debug", "Verbose output")
 	event_time := params.Duration("e event-time", 2*time.Hour, "Event qualifier, time between events to split on", "TIME")
 	event_bool := params.Pres("E show-event-lines", "Show event lines for a series of points within event-time")
 	force := params.Pres("force", "Ignore file/read errors and continue building output")
 	busy_timeout := params.Duration("timeout", 10*time.Second, "Busy timeout for SQLite calls", "TIME")
 	qry := params.String("q query", "", "Custom query for SQLite", "SQL")
 	file_list := params.String("list", "", "File with list of files to process, one line per file", "FILE")
+	epoch_rules := params.StringSlice("epoch", "Epoch of a date column in place of detecting it from the values, one of\n"+
+		strings.Join(dumper.Epochs, ", ")+",\n"+
+		"example: --epoch ZTIMESTAMP=unix-ms, or --epoch unix for every date column", "[TABLE.]COLUMN=EPOCH", 1)
+	tz := params.String("tz", "UTC", "Time zone to render the times in, an IANA name such as America/New_York\n"+
+		"or Local for the zone of this machine", "ZONE")
+	local_time := params.Pres("local-time", "Add LOCAL_TIME and LOCAL_TZ columns with the civil time where each point\n"+
+		"was, from the built in time zone polygons, and use it in the KML names")
+	tz_boundaries := params.String("tz-boundaries", "", "GeoJSON of time zone polygons with a tzid property for local-time, such\n"+
+		"as from timezone-boundary-builder, used before the built in ones; points\n"+
+		"in no polygon take the zone of the nearest zone city, an approximation", "FILE")
+	profile_files := params.StringSlice("profile", "JSON file mapping table name patterns to their lat, lon, alt, time, accuracy,\n"+
+		"speed, course and label columns, units and joins, the column names are used\n"+
+		"for tables without a profile", "FILE", 1)
+	builtin_profiles := params.Bool("builtin-profiles", true, "Use the built in profiles of the known iOS and app databases, each used\n"+
+		"when its tables are found in a file; without them tables are read by their\n"+
+		"column names, and *TRANSITIONMO tables are joined to their *MO locations", "T/F")
+	inspect := params.String("inspect", "", "Report the tables of each file, the columns read for the position and\n"+
+		"time with a confidence, and the time range and bounds of the points, as a\n"+
+		"\"table\" or \"json\", in place of writing any outputs", "FORMAT")
+	jobs := params.Int("j jobs", 1, "Number of files to read at once, the outputs are still written in the\n"+
+		"order of the files", "N")
 
 	params.GroupingSet("KML")
 	name := params.String("N name", "geo-sqlite-dumper", "Name to use for base KML folder", "TEXT")
 	kml_file := params.String("kml", "", "Export to KML file", "FILENAME")
+	gx_track := params.Pres("gx-track", "Write each event as a gx:Track in place of the points and path")
+	style_rules := params.StringSlice("style", "Style rule for map outputs, TARGET=COLUMN[:MIN:MAX], where TARGET is\n"+
+		"point-color, point-scale, line-color or line-width and COLUMN may be \"hour\" for the\n"+
+		"hour of day, example: --style line-color=ZSPEED:0:30", "RULE", 1)
+	kmz_file := params.String("kmz", "", "Export to KMZ file with the icons and legend embedded", "FILENAME")
+	kmz_split := params.Int("kmz-split", 50000, "Move folders into child documents loaded by NetworkLinks once the\n"+
+		"KMZ has more than this many points", "POINTS")
+	regionate_file := params.String("regionate", "", "Export to a regionated KMZ super-overlay, where Google Earth only loads\n"+
+		"the tiles in view, for browsing millions of points", "FILENAME")
+	region_points := params.Int("region-points", 1000, "Most points in a super-overlay tile before it is split and thinned", "POINTS")
+	balloon := params.Pres("balloon", "Add an HTML table of the columns as the placemark description, the\n"+
+		"columns are always included as typed ExtendedData")
+	legend := params.Pres("legend", "Add a legend of the file and table colors, written as a PNG next to the KML file")
 	params.GroupingSet("CSV")
-	escape_ascii = params.Bool("escape-ascii", false, "Escape non-ascii characters, useful for using tools that are not\n"+
+	escape_ascii := params.Bool("escape-ascii", false, "Escape non-ascii characters, useful for using tools that are not\n"+
 		"ascii safe/sanitizing special characters", "T/F")
 	csv_file := params.String("csv", "", "Export to CSV file", "FILENAME")
 	delimiter := params.String("delimiter", ",", "Delimiter for CSV output", "DELIM")
+	column_list := params.String("columns", "", "Comma separated columns for the CSV and XLSX outputs, when given the\n"+
+		"rows are written as they are read in place of once all the files are done", "LIST")
 	params.GroupingSet("XLSX")
 	xlsx_file := params.String("xlsx_file", "", "Export to XLSX file", "FILENAME")
 	xlsx_sheet := params.String("sheet", "geo-sqlite-dumper", "Sheet name to use in export", "NAME")
+	params.GroupingSet("GeoJSON")
+	geojson_file := params.String("geojson", "", "Export to GeoJSON file, event lines are included with show-event-lines", "FILENAME")
+	params.GroupingSet("GPX")
+	gpx_file := params.String("gpx", "", "Export to GPX file, events become tracks and places, such as locations of\n"+
+		"interest, waypoints", "FILENAME")
+	params.GroupingSet("GeoPackage")
+	gpkg_file := params.String("gpkg", "", "Export to an OGC GeoPackage with a feature table of each source table, keeping\n"+
+		"the column types, event lines are an events table with show-event-lines", "FILENAME")
+	params.GroupingSet("Shapefile")
+	shp_dir := params.String("shp", "", "Export to ESRI shapefiles in a directory, a point shapefile of each source table,\n"+
+		"with its lines and polygons in _lines and _polygons shapefiles, and an events\n"+
+		"polyline shapefile with show-event-lines", "DIR")
+	params.GroupingSet("SQLite")
+	sqlite_file := params.String("out-sqlite", "", "Export to one SQLite database of the sources, points and events of all the\n"+
+		"files, with the original columns as JSON and an R*Tree index", "FILENAME")
+	params.GroupingSet("Parquet")
+	parquet_file := params.String("parquet", "", "Export to a GeoParquet file with a WKB geometry, UTC timestamp and typed\n"+
+		"column for each column of the tables", "FILENAME")
+	params.GroupingSet("FlatGeobuf")
+	fgb_file := params.String("fgb", "", "Export to a FlatGeobuf file with a packed Hilbert R-tree index, event lines are\n"+
+		"included as LineStrings with show-event-lines", "FILENAME")
+	params.GroupingSet("NDJSON")
+	ndjson_file := params.String("ndjson", "", "Stream a JSON object per row as it is read, to a file or to stdout with -, keeping\n"+
+		"the column types with BLOBs in base64 and times in ISO-8601; with jobs each file\n"+
+		"is held in memory until it is read in full", "FILENAME|-")
 	params.CommandLine.Indent = 2
 	params.Parse()
 
-	if *xlsx_file != "" {
-		var err error
-		xlsxf, err := os.Create(*xlsx_file)
+	if *inspect != "" && *inspect != "table" && *inspect != "json" {
+		log.Fatalf("Unknown inspect format %q, expected table or json", *inspect)
+	}
+
+	rules, err := dumper.ParseStyleRules(*style_rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	epochs, err := dumper.ParseEpochRules(*epoch_rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var profiles dumper.Profiles
+	for _, pf := range *profile_files {
+		fh, err := os.Open(pf)
 		if err != nil {
-			panic(err)
+			log.Fatalf("Error reading in profile file %q, %s", pf, err)
 		}
-		xlsxf.Close()
+		p, err := dumper.LoadProfiles(fh)
+		fh.Close()
+		if err != nil {
+			log.Fatalf("Error in profile file %q, %s", pf, err)
+		}
+		profiles = append(profiles, p...)
 	}
-
-	var csvf, kmlf *os.File
-	if *csv_file != "" {
-		var err error
-		csvf, err = os.Create(*csv_file)
+	// The given profiles come first so they win over the built in ones
+	if *builtin_profiles {
+		p, err := dumper.BuiltinProfiles()
 		if err != nil {
-			panic(err)
+			log.Fatal(err)
 		}
-		defer csvf.Close()
+		profiles = append(profiles, p...)
 	}
 
-	if *kml_file != "" {
-		var err error
-		kmlf, err = os.Create(*kml_file)
-		if err != nil {
-			panic(err)
+	var tz_loc *time.Location
+	if *tz != "UTC" {
+		if tz_loc, err = time.LoadLocation(*tz); err != nil {
+			log.Fatalf("Error loading time zone %q, %s", *tz, err)
+		}
+	}
+	var tz_finder *dumper.TZFinder
+	if *local_time || *tz_boundaries != "" {
+		tz_finder = dumper.NewTZFinder()
+		if *tz_boundaries != "" {
+			bf, err := os.Open(*tz_boundaries)
+			if err != nil {
+				log.Fatalf("Error reading in time zone file %q, %s", *tz_boundaries, err)
+			}
+			err = tz_finder.LoadBoundaries(bufio.NewReader(bf))
+			bf.Close()
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	var columns []string
+	if *column_list != "" {
+		for _, c := range strings.Split(*column_list, ",") {
+			columns = append(columns, strings.TrimSpace(c))
 		}
-		defer kmlf.Close()
 	}
 
 	list := params.Args()
@@ -102,609 +197,222 @@ func main() {
 		fl.Close()
 	}
 
-	var sqlfileFolders []kml.Element
-	var all_clm_names []string
-	all_clm_names_used := make(map[string]bool)
-	var all_entries []*entry
+	src := &dumper.Source{
+		Query:       *qry,
+		BusyTimeout: *busy_timeout,
+		EventTime:   *event_time,
+		Force:       *force,
+		Epochs:      epochs,
+		Profiles:    profiles,
+		TZ:          tz_loc,
+		LocalTime:   tz_finder,
+		Debug:       *debug,
+	}
 
-	// Loop over the file names and load them into the sqlfileFolders slice
+	var files []string
 	for _, f := range list {
-		if f == "" {
-			continue
+		if f != "" {
+			files = append(files, f)
 		}
-		func() {
-			// Anonymous function to make sure the defer close will work after every file
-			// is done processing
-
-			{
-				header := make([]byte, 16)
-				test, err := os.Open(f)
-				if err != nil {
-					if *force {
-						log.Printf("Warning: Unable to open file %q, err: %s\n", f, err)
-						return
-					}
-					log.Fatalf("Unable to open file %q, err: %s\n", f, err)
-				}
-				n, err := test.Read(header)
-				if err != nil {
-					if *force {
-						log.Printf("Warning: Unable to read file %q, err: %s\n", f, err)
-						return
-					}
-					log.Fatalf("Unable to read file %q, err: %s\n", f, err)
-				}
-				if n == 0 {
-					if *force {
-						log.Printf("Warning: Empty file or unable to read bytes in file %q, err: %s\n", f, err)
-						return
-					}
-					log.Fatalf("Empty file or unable to read bytes in file %q\n", f)
-				}
-				if string(header) != "SQLite format 3\x00" {
-					if *force {
-						log.Printf("Warning: Header of file is not in \"SQLite format 3\", %q\n", f)
-						return
-					}
-					log.Fatalf("Header of file is not in \"SQLite format 3\", %q\n", f)
-				}
-				test.Close()
-			}
+	}
 
-			ef := ""
-			for _, c := range []byte(f) {
-				switch c {
-				case '/':
-					ef += "/"
-				default:
-					// Escape name so the sql open call will be sanitized
-					ef += fmt.Sprintf("%%%x", c)
-				}
-			}
-			// Open command reference:  https://www.sqlite.org/c3ref/open.html
-			conn, err := sqlite3.Open("file:"+ef+"?mode=ro&nolock=1&immutable=1", sqlite3.OPEN_READONLY)
+	// Report what would be read in place of writing the outputs
+	if *inspect != "" {
+		var reports []*dumper.FileReport
+		for _, f := range files {
+			rep, err := src.Inspect(f)
 			if err != nil {
-				panic(err)
+				if !*force {
+					log.Fatalf("Error inspecting %q, %s", f, err)
+				}
+				log.Printf("Warning: %s\n", err)
+				continue
 			}
-			defer conn.Close()
+			reports = append(reports, rep)
+		}
+		switch *inspect {
+		case "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(reports)
+		default:
+			err = dumper.WriteReports(os.Stdout, reports)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-			// It's always a good idea to set a busy timeout
-			conn.BusyTimeout(*busy_timeout)
+	// The map outputs share one builder so the styles and schemas agree
+	builder := dumper.NewKMLBuilder(rules, *event_bool, *gx_track, *balloon)
+	var out dumper.Sinks
 
-			// If no query is specified, dump all tables to file
-			tbl_names := []string{""}
-			if *qry == "" {
-				tbl_names, err = getTables(conn)
-			}
+	if *regionate_file != "" {
+		regionatef, err := os.Create(*regionate_file)
+		if err != nil {
+			panic(err)
+		}
+		defer regionatef.Close()
+		out = append(out, namedSink{name: *regionate_file,
+			Sink: dumper.NewRegionateSink(regionatef, *name, builder, *region_points)})
+	}
 
-			if err != nil {
-				fmt.Println("Make sure the file is in SQLite file format.", err)
-				return
-				//panic(err)
-			}
+	if *kmz_file != "" {
+		kmzf, err := os.Create(*kmz_file)
+		if err != nil {
+			panic(err)
+		}
+		defer kmzf.Close()
+		out = append(out, namedSink{name: *kmz_file,
+			Sink: dumper.NewKMZSink(kmzf, *name, builder, *legend, *qry != "", *kmz_split)})
+	}
 
-			// Declare all the variables for the tables in the file
-			var total_dist, total_alt float64
-			var tableFolders, eventFolders []kml.Element
-			var entries []*entry
-			var prev_kml_coord *kml.Coordinate
-			var tbl_name string
-
-			// Function to take the values collected and store them into a KML element
-			store_event := func() {
-				defer func() {
-					// When this function is returned, clear out the variables
-					total_dist, total_alt = 0, 0
-					prev_kml_coord = nil
-					entries = []*entry{}
-				}()
-
-				if len(entries) == 0 {
-					return
-				}
-				total_pts := float64(len(entries))
-				s_time := entries[0].time
-				e_time := entries[len(entries)-1].time
-				if *debug {
-					log.Println("storing event", s_time, e_time)
-				}
+	if *kml_file != "" {
+		kmlf, err := os.Create(*kml_file)
+		if err != nil {
+			panic(err)
+		}
+		defer kmlf.Close()
+		k, err := dumper.NewKMLSink(kmlf, *kml_file, *name, builder, *legend, *qry != "")
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *kml_file, Sink: k})
+	}
 
-				var elements []kml.Element
-				altMode := kml.AltitudeModeAbsolute
-				if total_alt == 0 {
-					altMode = kml.AltitudeModeClampToGround
-				}
-				// Create a path if more than one point is specified
-				if *event_bool && len(entries) > 1 && !strings.HasSuffix(tbl_name, "OFINTERESTMO") {
-					elements = append(elements,
-						kml.Placemark(
-							kml.Name("Path"),
-							kml.StyleURL("#yellowLineGreenPoly"),
-							kml.LineString(
-								kml.Extrude(true),
-								kml.Tessellate(true),
-								kml.AltitudeMode(altMode),
-								kml.Coordinates(coords(entries)...)),
-						),
-					)
-				}
+	if *csv_file != "" {
+		csvf, err := os.Create(*csv_file)
+		if err != nil {
+			panic(err)
+		}
+		defer csvf.Close()
+		c, err := dumper.NewCSVSink(csvf, *delimiter, *escape_ascii, columns)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *csv_file, Sink: c})
+	}
 
-				var pointElements []kml.Element
-				for _, entry := range entries {
-					if entry.coords != nil {
-						// Set the point title to the date
-						title := entry.time.Format(time.RFC3339Nano)
-						// If Z_PK exists, use that instead
-						if v, ok := entry.data["Z_PK"]; ok {
-							title = fmt.Sprintf("%v", v)
-						}
-
-						pointElements = append(pointElements,
-							kml.Placemark(
-								kml.Name(title),
-								entry.desc,
-								kml.Point(kml.Coordinates(*entry.coords)),
-							),
-						)
-					}
-				}
-				elements = append(elements, kml.Folder(
-					append([]kml.Element{
-						kml.Name("Points"),
-					},
-						pointElements...,
-					)...,
-				))
-
-				details := []kml.Element{}
-
-				if e_time.Sub(s_time) > 0 {
-					details = append(details,
-						kml.Name(fmt.Sprintf("Event (%d) %s - %s", len(entries), s_time.Format(time.RFC3339Nano), e_time.Format(time.RFC3339Nano))),
-					)
-				} else {
-					details = append(details,
-						kml.Name(fmt.Sprintf("Event (%d) %s", len(entries), s_time.Format(time.RFC3339Nano))))
-				}
+	if *xlsx_file != "" {
+		// Make sure the file can be written before reading anything
+		xlsxf, err := os.Create(*xlsx_file)
+		if err != nil {
+			panic(err)
+		}
+		xlsxf.Close()
+		x, err := dumper.NewXLSXSink(*xlsx_file, *xlsx_sheet, version, columns)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *xlsx_file, Sink: x})
+	}
 
-				if len(entries) > 1 {
-					details = append(details,
-						kml.Description(fmt.Sprintf("{time: %s, dist: %fm, mean altitude: %fm}", e_time.Sub(s_time), total_dist, total_alt/total_pts)),
-					)
-				}
+	if *geojson_file != "" {
+		geojsonf, err := os.Create(*geojson_file)
+		if err != nil {
+			panic(err)
+		}
+		defer geojsonf.Close()
+		out = append(out, namedSink{name: *geojson_file, Sink: dumper.NewGeoJSONSink(geojsonf, *event_bool, rules)})
+	}
 
-				eventFolders = append(eventFolders,
-					kml.Folder(
-						append(details,
-							elements...,
-						)...,
-					),
-				)
+	if *gpx_file != "" {
+		gpxf, err := os.Create(*gpx_file)
+		if err != nil {
+			panic(err)
+		}
+		defer gpxf.Close()
+		g, err := dumper.NewGPXSink(gpxf, *name, version)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *gpx_file, Sink: g})
+	}
 
-			}
+	if *gpkg_file != "" {
+		g, err := dumper.NewGPKGSink(*gpkg_file, *event_bool)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *gpkg_file, Sink: g})
+	}
 
-			// Loop over all the tables found in database, or call custom query
-			for _, tbl_name = range tbl_names {
-				func() { // Anonymous function to ensure the defer will close the statement as needed
-					// Ensure the variables are cleared on new table
-					total_dist, total_alt = 0, 0
-					prev_kml_coord = nil
-					entries = []*entry{}
-					desc_top := ""
-
-					if *debug {
-						log.Println("Table", tbl_name)
-					}
-
-					joined := false
-					var stmt *sqlite3.Stmt
-					if *qry == "" {
-						clm_names, err := getColumns(conn, tbl_name)
-						if err != nil {
-							panic(err)
-						}
-
-						var idate, idate_top []int
-						for i, col := range clm_names {
-							lcol := strings.ToLower(col)
-							if strings.HasSuffix(lcol, "date") || strings.HasSuffix(lcol, "timestamp") {
-								switch {
-								case strings.HasSuffix(lcol, "entrydate"):
-									idate_top = append(idate_top, i)
-								case strings.HasSuffix(lcol, "startdate"):
-									idate_top = append([]int{i}, idate_top...)
-								default:
-									//date = append(date, col)
-									idate = append(idate, i)
-								}
-							}
-						}
-						idate = append(idate_top, idate...)
-
-						sel_tbl := `SELECT * FROM ` + tbl_name
-
-						if strings.HasSuffix(tbl_name, "TRANSITIONMO") && contains(tbl_names, strings.TrimSuffix(tbl_name, "TRANSITIONMO")+"MO") {
-							join_tbl := strings.TrimSuffix(tbl_name, "TRANSITIONMO") + "MO"
-							desc_top = "Table " + tbl_name + " left joined with " + join_tbl + "\n"
-							sel_join_tbl := `SELECT * FROM ` + tbl_name + ` AS a LEFT JOIN ` + join_tbl + ` AS b ON a.ZLOCATIONOFINTEREST = b.Z_PK`
-							joined = true
-
-							// Prepare an SQL statement for data parsing with join operation
-							if len(idate) > 0 {
-								stmt, err = conn.Prepare(sel_join_tbl + ` ORDER BY a.` + clm_names[idate[0]])
-							} else {
-								stmt, err = conn.Prepare(sel_join_tbl)
-							}
-							if err != nil {
-								// Clear out statement on error
-								joined = false
-								stmt = nil
-							}
-						}
-
-						// Build the SQL statement if the join did not apply
-						if stmt == nil {
-							// Prepare an SQL statement for data parsing
-							if len(idate) > 0 {
-								stmt, err = conn.Prepare(sel_tbl + ` ORDER BY ` + clm_names[idate[0]])
-							} else {
-								stmt, err = conn.Prepare(sel_tbl)
-							}
-						}
-					} else {
-						stmt, err = conn.Prepare(*qry)
-					}
-					if err != nil {
-						log.Fatalf("failed to select data from table: %v", err)
-					}
-					defer stmt.Close()
-
-					clm_names := stmt.ColumnNames()
-					if err != nil {
-						panic(err)
-					}
-					if *debug {
-						log.Println("cols:", clm_names)
-					}
-
-					//var long, lat, alt, date []string
-					var ilong, ilat, ialt, idate, idate_top []int
-					for i, clm_name := range clm_names {
-						lcol := strings.ToLower(clm_name)
-						switch {
-						case strings.HasSuffix(lcol, "latitude"):
-							//lat = append(lat, col)
-							ilat = append(ilat, i)
-						case strings.HasSuffix(lcol, "longitude"):
-							//long = append(long, col)
-							ilong = append(ilong, i)
-						case strings.HasSuffix(lcol, "altitude"):
-							//alt = append(alt, col)
-							ialt = append(ialt, i)
-						case strings.HasSuffix(lcol, "date"):
-							switch {
-							case strings.HasSuffix(lcol, "entrydate"):
-								idate_top = append(idate_top, i)
-							case strings.HasSuffix(lcol, "startdate"):
-								idate_top = append([]int{i}, idate_top...)
-							default:
-								//date = append(date, col)
-								idate = append(idate, i)
-							}
-						}
-					}
-
-					idate = append(idate_top, idate...)
-
-					if !(len(ilong) > 0 && len(ilat) > 0) {
-						if *debug {
-							log.Println("Missing lat or long in file")
-						}
-						return
-					}
-
-					count := 0
-
-					for {
-						hasRow, err := stmt.Step()
-						if err != nil {
-							log.Fatalf("step failed while querying data: %v", err)
-						}
-						if !hasRow {
-							break
-						}
-						count++
-
-						// Use Scan to access column data from a row
-						data := make([]interface{}, len(clm_names))
-						var ptr_data []interface{}
-						for i := range data {
-							ptr_data = append(ptr_data, &data[i])
-						}
-						err = stmt.Scan(ptr_data...)
-						desc := fmt.Sprintf("i: %d", count)
-						if desc_top != "" {
-							desc = desc_top + desc
-						}
-
-						// Load the data into memory with a data_map for csv file and
-						// populate the description for the kml file
-						data_map := make(map[string]interface{})
-
-						{ // Store the file path in the csv output
-							data_map["SOURCE_FILE_PATH"] = f
-							if !contains(all_clm_names, "SOURCE_FILE_PATH") {
-								all_clm_names = append(all_clm_names, "SOURCE_FILE_PATH")
-								all_clm_names_used["SOURCE_FILE_PATH"] = true
-							}
-						}
-
-						{ // Store the table name in the csv output
-							data_map["SOURCE_TABLE"] = tbl_name
-							if !contains(all_clm_names, "SOURCE_TABLE") {
-								all_clm_names = append(all_clm_names, "SOURCE_TABLE")
-								all_clm_names_used["SOURCE_TABLE"] = true
-							}
-						}
-
-						for icol, clm_name := range clm_names {
-							if data[icol] == nil {
-								continue
-							}
-
-							// fill up the all_clm_names for csv headers
-							if !contains(all_clm_names, clm_name) {
-								all_clm_names = append(all_clm_names, clm_name)
-							}
-
-							if _, ok := data_map[clm_name]; ok {
-								// don't overwrite values, useful when two tables are left joined
-								continue
-							}
-							// ensure the column is exported in csv output
-							all_clm_names_used[clm_name] = true
-							var data_int interface{}
-							var data_suffix string
-							switch val := data[icol].(type) {
-							case float64:
-								if strings.HasSuffix(strings.ToLower(clm_name), "date") ||
-									strings.HasSuffix(strings.ToLower(clm_name), "timestamp") {
-									v_sec, v_dec := math.Modf(val)
-									v_time := time.Unix(int64(v_sec)+978307200, int64(v_dec+1e9)).UTC()
-									data_suffix = fmt.Sprintf(" (%s)", v_time)
-									data_map[clm_name+"_PARSED"] = v_time.Format("2006-01-02 15:04:05")
-									if !contains(all_clm_names, clm_name+"_PARSED") {
-										all_clm_names = append(all_clm_names, clm_name+"_PARSED")
-										all_clm_names_used[clm_name+"_PARSED"] = true
-									}
-								}
-								data_int = val
-							default:
-								data_int = val
-							}
-							// put the value in the datamap
-							data_map[clm_name] = data_int
-							// put the value in the kml description blob
-							desc += ",\n" + clm_name + ": " + fmt.Sprintf("%v", data_int) + data_suffix
-						}
-
-						var kml_coord *kml.Coordinate
-						var cur_time float64
-						var c_time time.Time
-
-						if len(idate) > 0 {
-							cur_time, _, _ = stmt.ColumnDouble(idate[0])
-							c_sec, c_dec := math.Modf(cur_time)
-							c_time = time.Unix(int64(c_sec)+978307200, int64(c_dec+1e9)).UTC()
-							if len(entries) > 0 && c_time.Sub(entries[len(entries)-1].time) > *event_time {
-								store_event()
-							}
-						}
-
-						loc_ok := true
-
-						lat, ok, err := stmt.ColumnDouble(ilat[0])
-						loc_ok = loc_ok && ok && err == nil
-						if *debug && (!ok || err != nil) {
-							log.Println("nil long", err)
-						}
-
-						long, ok, err := stmt.ColumnDouble(ilong[0])
-						loc_ok = loc_ok && ok && err == nil
-						if *debug && (!ok || err != nil) {
-							log.Println("nil lat", err)
-						}
-
-						if loc_ok {
-							if len(ialt) > 0 {
-								alt, ok, err := stmt.ColumnDouble(ilat[0])
-								if *debug && (!ok || err != nil) {
-									log.Println("nil alt", err)
-								}
-								kml_coord = &kml.Coordinate{
-									Lon: long,
-									Lat: lat,
-									Alt: alt,
-								}
-								total_alt += alt
-							} else {
-								kml_coord = &kml.Coordinate{
-									Lon: long,
-									Lat: lat,
-									Alt: 0,
-								}
-							}
-						}
-						if *debug {
-							log.Println("point: ", kml_coord, "@", cur_time, "/", c_time)
-						}
-
-						count := -1
-						if i, ok := find(tbl_names, "ZDATAPOINTCOUNT"); ok {
-							if val, ok, _ := stmt.ColumnInt(i); ok {
-								count = val
-							}
-						}
-						id := -1
-						if i, ok := find(tbl_names, "Z_PK"); ok {
-							if val, ok, _ := stmt.ColumnInt(i); ok {
-								id = val
-							}
-						}
-
-						c_entry := entry{
-							time:   c_time,
-							desc:   kml.Description(desc),
-							coords: kml_coord,
-							id:     id,
-							count:  count,
-							data:   data_map,
-						}
-						entries = append(entries, &c_entry)
-						if !joined {
-							all_entries = append(all_entries, &c_entry)
-						}
-						if prev_kml_coord != nil && kml_coord != nil {
-							// Center point for altitude
-							r1 := EarthRadius(prev_kml_coord.Lat)
-							r2 := EarthRadius(kml_coord.Lat)
-							arc := ArcDistance(
-								prev_kml_coord.Lat, prev_kml_coord.Lon,
-								kml_coord.Lat, kml_coord.Lon,
-							)
-							// Using a first order cartesian approximation, and not the
-							// incomplete elliptic intergral:
-							total_dist += math.Sqrt(Sq(r1+prev_kml_coord.Alt-r2-kml_coord.Alt) +
-								Sq(arc*(r1+prev_kml_coord.Alt+r2+kml_coord.Alt)/2))
-						}
-						prev_kml_coord = kml_coord
-
-						if err != nil {
-							log.Fatalf("scan failed while querying data: %v", err)
-						}
-					}
-
-					if len(entries) > 0 {
-						store_event()
-					}
-
-					tableFolders = append(tableFolders, kml.Folder(
-						append([]kml.Element{
-							kml.Name(fmt.Sprintf("%s (%d)", tbl_name, count)),
-							kml.Open(false),
-						},
-							eventFolders...,
-						)...,
-					))
-					eventFolders = []kml.Element{}
-
-				}()
-			}
-			if *qry == "" {
-				sqlfileFolders = append(sqlfileFolders, kml.Folder(
-					append([]kml.Element{
-						kml.Name(f),
-						kml.Open(false),
-					},
-						tableFolders...,
-					)...,
-				))
-			} else {
-				sqlfileFolders = tableFolders
-			}
-			tableFolders = []kml.Element{}
-		}()
+	if *shp_dir != "" {
+		s, err := dumper.NewShapefileSink(*shp_dir, *event_bool)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *shp_dir, Sink: s})
 	}
 
-	// Write out KML
-	if kmlf != nil {
-		result := kml.KML(
-			kml.Document(
-				append([]kml.Element{
-					kml.Name(*name),
-					kml.Description("Built using geo-sqlite-dumper, https://github.com/pschou/geo-sqlite-dumper"),
-					kml.Open(true),
-				},
-					sqlfileFolders...,
-				)...,
-			))
-		result.WriteIndent(kmlf, "", "  ")
+	if *sqlite_file != "" {
+		s, err := dumper.NewSQLiteSink(*sqlite_file)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *sqlite_file, Sink: s})
 	}
 
-	// Write out CSV
-	if csvf != nil {
-		co := bufio.NewWriter(csvf)
-		for i, clm_name := range all_clm_names {
-			if a, b := all_clm_names_used[clm_name]; !(a && b) {
-				continue
-			}
-			if i > 0 {
-				co.Write([]byte(*delimiter))
-			}
-			fmt.Fprintf(co, "%q", clm_name)
+	if *parquet_file != "" {
+		parquetf, err := os.Create(*parquet_file)
+		if err != nil {
+			panic(err)
 		}
-		co.Write([]byte{'\n'})
-		for _, e := range all_entries {
-			for i, clm_name := range all_clm_names {
-				if a, b := all_clm_names_used[clm_name]; !(a && b) {
-					continue
-				}
-				if i > 0 {
-					co.Write([]byte(*delimiter))
-				}
-				if edat, ok := e.data[clm_name]; ok {
-					fmt.Fprintf(co, "%q", interface2string(edat))
-				}
-			}
-			co.Write([]byte{'\n'})
+		defer parquetf.Close()
+		p, err := dumper.NewParquetSink(parquetf, version)
+		if err != nil {
+			panic(err)
 		}
-		co.Flush()
+		out = append(out, namedSink{name: *parquet_file, Sink: p})
 	}
-	// Write out CSV
-	if *xlsx_file != "" {
-		xlsxf := excelize.NewFile()
-		xlsxf.SetDocProps(&excelize.DocProperties{
-			Category:       "data",
-			ContentStatus:  "Final",
-			Created:        time.Now().UTC().Format("2006-01-02T15:04:05Z"),
-			Creator:        "GEO Sqlite Dumper " + version,
-			Description:    "This file was created by GEO Sqlite Dumper " + version + " (https://github.com/pschou/geo-sqlite-dumper)",
-			Identifier:     "xlsx",
-			Modified:       time.Now().UTC().Format("2006-01-02T15:04:05Z"),
-			LastModifiedBy: "GEO Sqlite Dumper " + version,
-			Revision:       "0",
-			Subject:        "Conversion from an SQLite file to XLSX",
-			Title:          "GEO Sqlite Dumper",
-			Language:       "en-US",
-			Version:        "1.0.0",
-		})
-		defer func() {
-			if err := xlsxf.SaveAs(*xlsx_file); err != nil {
-				panic(err)
-			}
-		}()
-		sheet := xlsxf.NewSheet(*xlsx_sheet)
-		xlsxf.SetActiveSheet(sheet)
-		{ // Build the header row
-			c := 0
-			for _, clm_name := range all_clm_names {
-				if a, b := all_clm_names_used[clm_name]; a && b {
-					c++
-					cell, _ := excelize.CoordinatesToCellName(c, 1)
-					xlsxf.SetCellValue(*xlsx_sheet, cell, clm_name)
-				}
-			}
+
+	if *fgb_file != "" {
+		fgbf, err := os.Create(*fgb_file)
+		if err != nil {
+			panic(err)
 		}
-		// Fill in the data
-		for r, e := range all_entries {
-			c := 0
-			for _, clm_name := range all_clm_names {
-				if a, b := all_clm_names_used[clm_name]; a && b {
-					c++
-					cell, _ := excelize.CoordinatesToCellName(c, r+2)
-					xlsxf.SetCellValue(*xlsx_sheet, cell, e.data[clm_name])
-				}
-			}
+		defer fgbf.Close()
+		f, err := dumper.NewFlatGeobufSink(fgbf, *name, *event_bool)
+		if err != nil {
+			panic(err)
+		}
+		out = append(out, namedSink{name: *fgb_file, Sink: f})
+	}
+
+	if *ndjson_file == "-" {
+		out = append(out, namedSink{name: "stdout", Sink: dumper.NewNDJSONSink(os.Stdout, true)})
+	} else if *ndjson_file != "" {
+		ndjsonf, err := os.Create(*ndjson_file)
+		if err != nil {
+			panic(err)
 		}
+		defer ndjsonf.Close()
+		out = append(out, namedSink{name: *ndjson_file, Sink: dumper.NewNDJSONSink(ndjsonf, false)})
+	}
+
+	// Read the files, every row is handed to the outputs as it is read or,
+	// with more than one job, once the files before it are done
+	if err := src.ReadFiles(files, *jobs, out); err != nil {
+		log.Fatal(err)
+	}
+
+	// Finish off the outputs which need all the rows before they can be
+	// written
+	if err := out.Close(); err != nil {
+		log.Fatalf("Error %s", err)
+	}
+}
+
+// namedSink adds the output file name to the errors when closing a sink
+type namedSink struct {
+	dumper.Sink
+	name string
+}
+
+func (n namedSink) Close() error {
+	if err := n.Sink.Close(); err != nil {
+		return fmt.Errorf("writing %q, %s", n.name, err)
 	}
+	return nil
 }